@@ -4,6 +4,7 @@ import (
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"time"
@@ -84,7 +85,7 @@ func validateMemoryPool(b *Blockchain, memoryPool []BlockData) bool {
 	balances := make(map[string]float64)
 	for _, block := range b.Chain {
 		for _, data := range block.Data {
-			if tx, ok := data.(Transaction); ok {
+			for _, tx := range balanceChanges(data) {
 				balances[tx.From] -= tx.Amount
 				balances[tx.To] += tx.Amount
 			}
@@ -95,7 +96,7 @@ func validateMemoryPool(b *Blockchain, memoryPool []BlockData) bool {
 	}
 
 	for _, data := range memoryPool {
-		if tx, ok := data.(Transaction); ok {
+		for _, tx := range balanceChanges(data) {
 			if balances[tx.From]-tx.Amount < 0 {
 				return false
 			}
@@ -117,23 +118,23 @@ func (b *Blockchain) mine(miner string) (Block, error) {
 		return b.Chain[0], nil
 	}
 
+	// Check if adding the reward exceeds the maximum coins limit
+	if b.getMinedCoins()+b.RewardPerBlock > b.MaxCoins {
+		return Block{}, fmt.Errorf("max coins limit reached")
+	}
+
 	lastBlock := b.Chain[len(b.Chain)-1]
 	reward := BlockReward{
 		Miner:  miner,
 		Amount: b.RewardPerBlock,
 	}
 	newBlock := Block{
-		Data:         b.MemoryPool,
+		Data:         b.revalidateMemoryPool(),
 		Reward:       reward,
 		PreviousHash: lastBlock.Hash,
 		Timestamp:    time.Now(),
 	}
 
-	// Check if adding the reward exceeds the maximum coins limit
-	if b.getMinedCoins()+b.RewardPerBlock > b.MaxCoins {
-		return Block{}, fmt.Errorf("max coins limit reached")
-	}
-
 	newBlock.mine(b.Difficulty)
 	b.Chain = append(b.Chain, newBlock)
 
@@ -143,6 +144,35 @@ func (b *Blockchain) mine(miner string) (Block, error) {
 	return newBlock, nil
 }
 
+// height returns the height of the block new operations are stored in, the genesis block being
+// at height 0. Here it is the block the memory pool is mined into next
+func (b Blockchain) height() int {
+	return len(b.Chain)
+}
+
+// revalidateMemoryPool validates the memory pool again at the height of the block being mined
+// Data that is no longer valid, such as a claim past its refund height, or that settles a lock
+// already settled by earlier data of the block is dropped
+func (b *Blockchain) revalidateMemoryPool() []BlockData {
+	pool := b.MemoryPool
+	b.MemoryPool = nil
+	settled := make(map[string]bool)
+	for _, data := range pool {
+		hashlock, settles := settledHashLock(data)
+		if settles && settled[hashlock] {
+			continue
+		}
+		if !data.Validate(b) || !validateMemoryPool(b, append(b.MemoryPool, data)) {
+			continue
+		}
+		if settles {
+			settled[hashlock] = true
+		}
+		b.MemoryPool = append(b.MemoryPool, data)
+	}
+	return b.MemoryPool
+}
+
 // isValid checks if the blockchain is valid
 func (b Blockchain) isValid() bool {
 	for i := range b.Chain[1:] {
@@ -160,7 +190,7 @@ func (b Blockchain) getBalance(address string) float64 {
 	balance := 0.0
 	for _, block := range b.Chain {
 		for _, data := range block.Data {
-			if tx, ok := data.(Transaction); ok {
+			for _, tx := range balanceChanges(data) {
 				if tx.From == address {
 					balance -= tx.Amount
 				}
@@ -176,6 +206,61 @@ func (b Blockchain) getBalance(address string) float64 {
 	return balance
 }
 
+// findHashLock returns the hash time-locked transfer identified by its hashlock, mined or in the memory pool
+func (b Blockchain) findHashLock(hashlock string) (HashLock, bool) {
+	hashlock = strings.ToLower(hashlock)
+	for _, block := range b.Chain {
+		for _, data := range block.Data {
+			if lock, ok := data.(HashLock); ok && lock.Hashlock == hashlock {
+				return lock, true
+			}
+		}
+	}
+	for _, data := range b.MemoryPool {
+		if lock, ok := data.(HashLock); ok && lock.Hashlock == hashlock {
+			return lock, true
+		}
+	}
+	return HashLock{}, false
+}
+
+// isHashLockSettled checks if a hash time-locked transfer was claimed or refunded in a mined block
+// Settlements waiting in the memory pool may no longer be valid when the next block is mined
+func (b Blockchain) isHashLockSettled(hashlock string) bool {
+	for _, block := range b.Chain {
+		for _, data := range block.Data {
+			if settled, settles := settledHashLock(data); settles && settled == hashlock {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getHashLockStatus reports if a hash time-locked transfer is pending, locked, claimed or refunded
+func (b Blockchain) getHashLockStatus(hashlock string) string {
+	status := "pending" // Waiting in the memory pool
+	for _, block := range b.Chain {
+		for _, data := range block.Data {
+			switch d := data.(type) {
+			case HashLock:
+				if d.Hashlock == hashlock {
+					status = "locked"
+				}
+			case HashLockClaim:
+				if d.Hashlock == hashlock {
+					return "claimed"
+				}
+			case HashLockRefund:
+				if d.Hashlock == hashlock {
+					return "refunded"
+				}
+			}
+		}
+	}
+	return status
+}
+
 // getMinedCoins calculates the total mined coins
 func (b Blockchain) getMinedCoins() float64 {
 	totalMined := 0.0
@@ -187,7 +272,8 @@ func (b Blockchain) getMinedCoins() float64 {
 
 // main sets up the server and routes
 func main() {
-	app := fiber.New()
+	// Immutable keeps values such as the miner wallet valid after the request returns
+	app := fiber.New(fiber.Config{Immutable: true})
 
 	// Initialize the blockchain with a difficulty of 2, reward of 10 coins per block, and a maximum of 1000 coins
 	blockchain := CreateBlockchain(2, 10, 1000)
@@ -235,12 +321,91 @@ func main() {
 		return c.Status(fiber.StatusCreated).JSON(response)
 	})
 
+	// Lock coins for a recipient under a SHA256 hashlock until a refund height
+	app.Post("/htlc/new", func(c *fiber.Ctx) error {
+		var lock HashLock
+		if err := c.BodyParser(&lock); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		// The hashlock is stored and compared to claimed digests in lowercase
+		lock.Hashlock = strings.ToLower(lock.Hashlock)
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		err := blockchain.addBlockData(lock)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{"message": "Hash lock added to the memory pool", "hashlock": lock.Hashlock}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Claim locked coins for the recipient by revealing the preimage before the refund height
+	app.Post("/htlc/claim", func(c *fiber.Ctx) error {
+		var claim HashLockClaim
+		if err := c.BodyParser(&claim); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, exists := blockchain.findHashLock(claim.Hashlock)
+		if !exists {
+			return c.Status(fiber.StatusNotFound).SendString("Hash lock not found")
+		}
+		claim.Hashlock, claim.To, claim.Amount = lock.Hashlock, lock.To, lock.Amount
+		err := blockchain.addBlockData(claim)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{"message": "Hash lock claim added to the memory pool"}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Refund locked coins to the sender from the refund height
+	app.Post("/htlc/refund", func(c *fiber.Ctx) error {
+		var refund HashLockRefund
+		if err := c.BodyParser(&refund); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, exists := blockchain.findHashLock(refund.Hashlock)
+		if !exists {
+			return c.Status(fiber.StatusNotFound).SendString("Hash lock not found")
+		}
+		refund.Hashlock, refund.From, refund.Amount = lock.Hashlock, lock.From, lock.Amount
+		err := blockchain.addBlockData(refund)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{"message": "Hash lock refund added to the memory pool"}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Get a hash time-locked transfer and its status
+	app.Get("/htlc/:hashlock", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, exists := blockchain.findHashLock(c.Params("hashlock"))
+		if !exists {
+			return c.Status(fiber.StatusNotFound).SendString("Hash lock not found")
+		}
+		response := fiber.Map{
+			"lock":   lock,
+			"status": blockchain.getHashLockStatus(lock.Hashlock),
+			"height": blockchain.height(),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
 	// Get the full blockchain
 	app.Get("/chain", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
 		response := fiber.Map{
 			"chain":      blockchain.Chain,
 			"length":     len(blockchain.Chain),
+			"height":     blockchain.height(),
 			"isValid":    blockchain.isValid(),
 			"minedCoins": blockchain.getMinedCoins(),
 		}
@@ -266,5 +431,9 @@ func main() {
 		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	app.Listen(":7000")
+	port := "7000"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+	app.Listen(":" + port)
 }
@@ -1,5 +1,10 @@
 package main
 
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
 // BlockData is an interface for data that can be stored in a block
 type BlockData interface {
 	Validate(blockchain *Blockchain) bool
@@ -21,9 +26,106 @@ type Transaction struct {
 // Validate checks if the transaction is valid
 func (t Transaction) Validate(blockchain *Blockchain) bool {
 	// Ensure that the transaction amount does not exceed the sender's balance
+	if t.From == HTLC_ESCROW_WALLET || t.To == HTLC_ESCROW_WALLET {
+		return false
+	}
 	if t.From != "0" { // "0" indicates a mining reward
 		balance := blockchain.getBalance(t.From)
 		return balance >= t.Amount
 	}
 	return true
 }
+
+// HTLC_ESCROW_WALLET holds the coins of hash time-locked transfers until they are claimed or refunded
+const HTLC_ESCROW_WALLET string = "HTLC Escrow"
+
+// HashLock represents coins locked for a recipient until the preimage of Hashlock is revealed
+// The coins can be refunded to the sender from RefundHeight. The hashlock identifies the lock
+type HashLock struct {
+	From         string  `json:"from"`
+	To           string  `json:"to"`
+	Amount       float64 `json:"amount"`
+	Hashlock     string  `json:"hashlock"` // Hex encoded SHA256 digest of the preimage
+	RefundHeight int     `json:"refund_height"`
+}
+
+// HashLockClaim represents the claim of a hash time-locked transfer by revealing its preimage
+type HashLockClaim struct {
+	Hashlock string  `json:"hashlock"`
+	Preimage string  `json:"preimage"` // Hex encoded
+	To       string  `json:"to"`
+	Amount   float64 `json:"amount"`
+}
+
+// HashLockRefund represents the refund of a hash time-locked transfer to its sender
+type HashLockRefund struct {
+	Hashlock string  `json:"hashlock"`
+	From     string  `json:"from"`
+	Amount   float64 `json:"amount"`
+}
+
+// Validate checks if the lock is valid, the balance is checked with the memory pool
+func (l HashLock) Validate(blockchain *Blockchain) bool {
+	if digest, err := hex.DecodeString(l.Hashlock); err != nil || len(digest) != sha256.Size {
+		return false
+	}
+	if l.From == l.To || l.From == HTLC_ESCROW_WALLET || l.To == HTLC_ESCROW_WALLET || l.Amount <= 0 {
+		return false
+	}
+	if l.RefundHeight <= blockchain.height() {
+		return false
+	}
+	// A hashlock can only be used once
+	_, exists := blockchain.findHashLock(l.Hashlock)
+	return !exists
+}
+
+// Validate checks if the preimage matches a lock not yet settled and not timed out
+func (c HashLockClaim) Validate(blockchain *Blockchain) bool {
+	lock, exists := blockchain.findHashLock(c.Hashlock)
+	if !exists || blockchain.isHashLockSettled(c.Hashlock) || c.To != lock.To || c.Amount != lock.Amount {
+		return false
+	}
+	preimage, err := hex.DecodeString(c.Preimage)
+	if err != nil {
+		return false
+	}
+	digest := sha256.Sum256(preimage)
+	// The claim must be mined before the refund height
+	return hex.EncodeToString(digest[:]) == lock.Hashlock && blockchain.height() < lock.RefundHeight
+}
+
+// Validate checks if the lock timed out and is not yet settled
+func (r HashLockRefund) Validate(blockchain *Blockchain) bool {
+	lock, exists := blockchain.findHashLock(r.Hashlock)
+	if !exists || blockchain.isHashLockSettled(r.Hashlock) || r.From != lock.From || r.Amount != lock.Amount {
+		return false
+	}
+	return blockchain.height() >= lock.RefundHeight
+}
+
+// settledHashLock returns the hashlock of the lock block data claims or refunds
+func settledHashLock(data BlockData) (string, bool) {
+	switch d := data.(type) {
+	case HashLockClaim:
+		return d.Hashlock, true
+	case HashLockRefund:
+		return d.Hashlock, true
+	}
+	return "", false
+}
+
+// balanceChanges returns the coins moved by block data as transactions
+func balanceChanges(data BlockData) []Transaction {
+	switch d := data.(type) {
+	case Transaction:
+		return []Transaction{d}
+	case HashLock:
+		return []Transaction{{From: d.From, To: HTLC_ESCROW_WALLET, Amount: d.Amount}}
+	case HashLockClaim:
+		return []Transaction{{From: HTLC_ESCROW_WALLET, To: d.To, Amount: d.Amount}}
+	case HashLockRefund:
+		return []Transaction{{From: HTLC_ESCROW_WALLET, To: d.From, Amount: d.Amount}}
+	}
+	return nil
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// ContractABI declares the methods a contract accepts and the events it emits
+// Parameter and return types are string, address, number, integer, bool, object, array or any
+type ContractABI struct {
+	Methods []MethodABI `json:"methods"`
+	Events  []EventABI  `json:"events"`
+}
+
+// MethodABI declares a method of a contract, its parameters and its return type
+// The method with an empty name declares the default behaviour, run when no method is called
+type MethodABI struct {
+	Name     string     `json:"name"`
+	Params   []ParamABI `json:"params"`
+	Returns  string     `json:"returns"`
+	ReadOnly bool       `json:"read_only"` // Only answers calls, executions on the chain are rejected
+	Payable  bool       `json:"payable"`   // Accepts coins attached to the call
+}
+
+// ParamABI declares a named parameter of a method or a field of an event payload
+type ParamABI struct {
+	Name     string `json:"name"`
+	Type     string `json:"type"`
+	Optional bool   `json:"optional"`
+}
+
+// EventABI declares an event of a contract, its indexed topics and its payload fields
+type EventABI struct {
+	Name    string     `json:"name"`
+	Topics  []string   `json:"topics"`
+	Payload []ParamABI `json:"payload"`
+}
+
+// Describer is implemented by the Code of contracts declaring their ABI
+type Describer interface {
+	ABI() ContractABI
+}
+
+// method returns the declaration of a method
+func (abi ContractABI) method(name string) (MethodABI, bool) {
+	for _, method := range abi.Methods {
+		if method.Name == name {
+			return method, true
+		}
+	}
+	return MethodABI{}, false
+}
+
+// checkType checks that a JSON value matches an ABI type
+func checkType(abiType string, raw json.RawMessage) bool {
+	var value any
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return false
+	}
+	switch abiType {
+	case "string", "address":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && number == math.Trunc(number)
+	case "bool":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]any)
+		return ok
+	case "array":
+		_, ok := value.([]any)
+		return ok
+	case "any":
+		return true
+	}
+	return false
+}
+
+// ValidateCall checks a method call against the ABI. An empty method runs the default
+// behaviour of the contract, checked against its declaration like any other method. Read-only
+// methods are rejected when the call is executed on the chain
+func (abi ContractABI) ValidateCall(method string, args map[string]json.RawMessage, value float64, onChain bool) error {
+	declaration, exists := abi.method(method)
+	if !exists {
+		if method == "" {
+			return fmt.Errorf("contract declares no default method, a method must be called")
+		}
+		return fmt.Errorf("unknown method %s", method)
+	}
+	if onChain && declaration.ReadOnly {
+		return fmt.Errorf("method %s is read-only and can only be called", method)
+	}
+	if value > 0 && !declaration.Payable {
+		return fmt.Errorf("method %s does not accept value", method)
+	}
+
+	declared := make(map[string]bool, len(declaration.Params))
+	for _, param := range declaration.Params {
+		declared[param.Name] = true
+		raw, exists := args[param.Name]
+		if !exists {
+			if param.Optional {
+				continue
+			}
+			return fmt.Errorf("missing argument %s of method %s", param.Name, method)
+		}
+		if !checkType(param.Type, raw) {
+			return fmt.Errorf("argument %s of method %s must be of type %s", param.Name, method, param.Type)
+		}
+	}
+	for name := range args {
+		if !declared[name] {
+			return fmt.Errorf("unknown argument %s of method %s", name, method)
+		}
+	}
+	return nil
+}
+
+// getContractABI returns the ABI declared by the current code version of a contract
+func (bc *Blockchain) getContractABI(contractID string) (ContractABI, bool, error) {
+	state, err := bc.getContractState(contractID)
+	if err != nil {
+		return ContractABI{}, false, err
+	}
+	describer, ok := state.Code.(Describer)
+	if !ok {
+		return ContractABI{}, false, nil
+	}
+	return describer.ABI(), true, nil
+}
+
+// validateContractCall checks a method call executed on the chain against the ABI of the
+// contract, when declared
+func (bc *Blockchain) validateContractCall(contractID string, method string, args map[string]json.RawMessage, value float64) error {
+	abi, declared, err := bc.getContractABI(contractID)
+	if err != nil || !declared {
+		return err
+	}
+	return abi.ValidateCall(method, args, value, true)
+}
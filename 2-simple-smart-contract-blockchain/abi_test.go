@@ -0,0 +1,64 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestReadOnlyMethodsAreOnlyCalled(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, executeContract(t, bc, alice, contractID, "increment", nil, 0))
+
+	err := bc.addContractExecution(signedExecution(t, alice, contractID, "count", nil, 0))
+	if err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("execution of a read-only method returned %v", err)
+	}
+
+	schedule := ContractSchedule{
+		ScheduleID:  "schedule-1",
+		Owner:       alice.ID,
+		ContractID:  contractID,
+		Method:      "count",
+		StartHeight: len(bc.Chain),
+		GasLimit:    1,
+		PrepaidGas:  1,
+		Nonce:       alice.nextNonce(),
+	}
+	schedule.Signature = alice.sign(t, schedule.signingMessage())
+	if err := bc.addSchedule(schedule); err == nil || !strings.Contains(err.Error(), "read-only") {
+		t.Fatalf("schedule of a read-only method returned %v", err)
+	}
+
+	call, err := bc.callContract(ContractExecution{ContractID: contractID, Method: "count", GasLimit: VIEW_GAS_LIMIT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Result != "1" {
+		t.Fatalf("count returned %q", call.Result)
+	}
+}
+
+func TestDefaultMethodIsCheckedAgainstItsDeclaration(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	token := deployContract(t, bc, alice, CONTRACT_TOKEN_TYPE, `{ "name": "Acme", "symbol": "ACM", "supply": 100 }`)
+
+	// The example declares its default method, which takes no argument
+	if err := bc.addContractExecution(signedExecution(t, alice, counter, "", map[string]any{"to": alice.ID}, 0)); err == nil || !strings.Contains(err.Error(), "unknown argument") {
+		t.Fatalf("default method with an undeclared argument returned %v", err)
+	}
+	if count := mustSucceed(t, executeContract(t, bc, alice, counter, "", nil, 1)); count != "1" {
+		t.Fatalf("default method counted %s", count)
+	}
+
+	// The token declares none, its methods must be called by name
+	err := bc.addContractExecution(signedExecution(t, alice, token, "", map[string]any{"to": alice.ID, "amount": 1}, 0))
+	if err == nil || !strings.Contains(err.Error(), "no default method") {
+		t.Fatalf("call without method to a contract declaring no default returned %v", err)
+	}
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const CONTRACT_AMM_TYPE string = "amm"
+
+func init() {
+	registerCode(CONTRACT_AMM_TYPE, func() Code { return &ContractCodeAMM{} })
+}
+
+// AMMSpecification is the specification of a liquidity pool, given as JSON at deployment
+// The pool trades the coins of the chain against the tokens of a token contract, Fee is the
+// fraction of each swap input kept by the pool
+type AMMSpecification struct {
+	Token string  `json:"token"`
+	Fee   float64 `json:"fee"`
+}
+
+// AMMReserves reports the reserves, price and liquidity shares of a pool
+type AMMReserves struct {
+	Coins       float64 `json:"coins"`
+	Tokens      int64   `json:"tokens"`
+	Price       float64 `json:"price"` // Coins per token
+	TotalShares float64 `json:"total_shares"`
+}
+
+// ContractCodeAMM implements a constant-product pool between coins and a token, liquidity
+// providers receive shares of the reserves and swaps pay a fee to the pool
+// Tokens are moved with the token contract's transfer_from, the pool must first be approved
+type ContractCodeAMM struct {
+	AMMSpecification
+	ReserveCoins  float64            `json:"reserve_coins"`
+	ReserveTokens int64              `json:"reserve_tokens"`
+	TotalShares   float64            `json:"total_shares"`
+	Shares        map[string]float64 `json:"shares"`
+}
+
+// Initialize configures the token and swap fee of the pool
+func (sc *ContractCodeAMM) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.AMMSpecification); err != nil {
+		return fmt.Errorf("amm specification must be JSON: %v", err)
+	}
+	sc.Shares = map[string]float64{}
+	return nil
+}
+
+func (sc *ContractCodeAMM) Validate(chain ChainReader) bool {
+	tokenType, _ := chain.ContractType(sc.Token)
+	return tokenType == CONTRACT_TOKEN_TYPE && sc.Fee >= 0 && sc.Fee < 1
+}
+
+func (sc *ContractCodeAMM) ABI() ContractABI {
+	integer := func(name string) ParamABI { return ParamABI{Name: name, Type: "integer"} }
+	number := func(name string) ParamABI { return ParamABI{Name: name, Type: "number"} }
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "reserves", Params: []ParamABI{}, Returns: "object", ReadOnly: true},
+			{Name: "price", Params: []ParamABI{}, Returns: "number", ReadOnly: true},
+			{Name: "quote", Params: []ParamABI{{Name: "input", Type: "string"}, number("amount")}, Returns: "number", ReadOnly: true},
+			{Name: "shares_of", Params: []ParamABI{{Name: "provider", Type: "address"}}, Returns: "number", ReadOnly: true},
+			{Name: "add_liquidity", Params: []ParamABI{integer("max_tokens"), {Name: "min_shares", Type: "number", Optional: true}}, Returns: "number", Payable: true},
+			{Name: "remove_liquidity", Params: []ParamABI{number("shares"), {Name: "min_coins", Type: "number", Optional: true}, {Name: "min_tokens", Type: "integer", Optional: true}}, Returns: "object"},
+			{Name: "swap_coins_for_tokens", Params: []ParamABI{integer("min_tokens")}, Returns: "integer", Payable: true},
+			{Name: "swap_tokens_for_coins", Params: []ParamABI{integer("tokens"), number("min_coins")}, Returns: "number"},
+		},
+		Events: []EventABI{
+			{Name: "LiquidityAdded", Topics: []string{"provider"}, Payload: []ParamABI{number("coins"), integer("tokens"), number("shares")}},
+			{Name: "LiquidityRemoved", Topics: []string{"provider"}, Payload: []ParamABI{number("coins"), integer("tokens"), number("shares")}},
+			{Name: "Swap", Topics: []string{"trader", "input"}, Payload: []ParamABI{number("amount_in"), number("amount_out")}},
+		},
+	}
+}
+
+func (sc *ContractCodeAMM) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "reserves":
+		return marshalResult(AMMReserves{
+			Coins:       sc.ReserveCoins,
+			Tokens:      sc.ReserveTokens,
+			Price:       sc.price(),
+			TotalShares: sc.TotalShares,
+		})
+	case "price":
+		return strconv.FormatFloat(sc.price(), 'f', -1, 64), nil
+	case "quote":
+		var input string
+		var amount float64
+		if err := ctx.Arg("input", &input); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("amount", &amount); err != nil {
+			return "", err
+		}
+		switch input {
+		case "coins":
+			return strconv.FormatInt(sc.tokensOut(amount), 10), nil
+		case "tokens":
+			// Tokens are whole units, as swap_tokens_for_coins takes them
+			if amount != math.Trunc(amount) || math.Abs(amount) > math.MaxInt64 {
+				return "", fmt.Errorf("amount of tokens must be a whole number")
+			}
+			return strconv.FormatFloat(sc.coinsOut(int64(amount)), 'f', -1, 64), nil
+		}
+		return "", fmt.Errorf("input must be coins or tokens")
+	case "shares_of":
+		var provider string
+		if err := ctx.Arg("provider", &provider); err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(sc.Shares[provider], 'f', -1, 64), nil
+	}
+
+	// The remaining methods move the coins and tokens of the caller
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	switch ctx.Method() {
+	case "add_liquidity":
+		return sc.addLiquidity(ctx)
+	case "remove_liquidity":
+		return sc.removeLiquidity(ctx)
+	case "swap_coins_for_tokens":
+		var minTokens int64
+		if err := ctx.Arg("min_tokens", &minTokens); err != nil {
+			return "", err
+		}
+		coins := ctx.Value()
+		if coins <= 0 {
+			return "", fmt.Errorf("swap must attach coins")
+		}
+		tokens := sc.tokensOut(coins)
+		if tokens <= 0 || tokens < minTokens {
+			return "", fmt.Errorf("swap would return %d tokens, below the minimum of %d", tokens, minTokens)
+		}
+		sc.ReserveCoins += coins
+		sc.ReserveTokens -= tokens
+		if err := sc.sendTokens(ctx, ctx.Caller(), tokens); err != nil {
+			return "", err
+		}
+		err := ctx.Emit("Swap", []string{ctx.Caller(), "coins"}, map[string]float64{
+			"amount_in":  coins,
+			"amount_out": float64(tokens),
+		})
+		return strconv.FormatInt(tokens, 10), err
+	case "swap_tokens_for_coins":
+		var tokens int64
+		var minCoins float64
+		if err := ctx.Arg("tokens", &tokens); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("min_coins", &minCoins); err != nil {
+			return "", err
+		}
+		if tokens <= 0 {
+			return "", fmt.Errorf("swap must send tokens")
+		}
+		coins := sc.coinsOut(tokens)
+		if coins <= 0 || coins < minCoins {
+			return "", fmt.Errorf("swap would return %v coins, below the minimum of %v", coins, minCoins)
+		}
+		if err := sc.receiveTokens(ctx, ctx.Caller(), tokens); err != nil {
+			return "", err
+		}
+		sc.ReserveTokens += tokens
+		sc.ReserveCoins -= coins
+		if err := ctx.Transfer(ctx.Caller(), coins); err != nil {
+			return "", err
+		}
+		err := ctx.Emit("Swap", []string{ctx.Caller(), "tokens"}, map[string]float64{
+			"amount_in":  float64(tokens),
+			"amount_out": coins,
+		})
+		return strconv.FormatFloat(coins, 'f', -1, 64), err
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// price returns the price of a token in coins, 0 for an empty pool
+func (sc *ContractCodeAMM) price() float64 {
+	if sc.ReserveTokens == 0 {
+		return 0
+	}
+	return sc.ReserveCoins / float64(sc.ReserveTokens)
+}
+
+// tokensOut calculates the tokens returned for coins keeping the product of the reserves
+func (sc *ContractCodeAMM) tokensOut(coins float64) int64 {
+	if coins <= 0 || sc.ReserveCoins == 0 {
+		return 0
+	}
+	in := coins * (1 - sc.Fee)
+	return int64(math.Floor(float64(sc.ReserveTokens) * in / (sc.ReserveCoins + in)))
+}
+
+// coinsOut calculates the coins returned for tokens keeping the product of the reserves
+func (sc *ContractCodeAMM) coinsOut(tokens int64) float64 {
+	if tokens <= 0 || sc.ReserveTokens == 0 {
+		return 0
+	}
+	in := float64(tokens) * (1 - sc.Fee)
+	return sc.ReserveCoins * in / (float64(sc.ReserveTokens) + in)
+}
+
+// receiveTokens moves tokens from a wallet to the pool through the allowance given to the pool
+func (sc *ContractCodeAMM) receiveTokens(ctx ExecutionContext, from string, tokens int64) error {
+	_, err := ctx.Call(sc.Token, "transfer_from", map[string]any{
+		"from":   from,
+		"to":     ctx.ContractID(),
+		"amount": tokens,
+	}, 0)
+	return err
+}
+
+// sendTokens moves tokens from the pool to a wallet
+func (sc *ContractCodeAMM) sendTokens(ctx ExecutionContext, to string, tokens int64) error {
+	if tokens == 0 {
+		return nil
+	}
+	_, err := ctx.Call(sc.Token, "transfer", map[string]any{
+		"to":     to,
+		"amount": tokens,
+	}, 0)
+	return err
+}
+
+// addLiquidity adds the attached coins and the matching tokens to the reserves, at the current
+// price once the pool holds liquidity, and credits the provider with shares
+func (sc *ContractCodeAMM) addLiquidity(ctx ExecutionContext) (string, error) {
+	var maxTokens int64
+	var minShares float64
+	if err := ctx.Arg("max_tokens", &maxTokens); err != nil {
+		return "", err
+	}
+	ctx.Arg("min_shares", &minShares) // Optional
+	coins := ctx.Value()
+	if coins <= 0 || maxTokens <= 0 {
+		return "", fmt.Errorf("liquidity must attach coins and tokens")
+	}
+
+	tokens := maxTokens
+	shares := math.Sqrt(coins * float64(maxTokens))
+	if sc.TotalShares > 0 {
+		tokens = int64(math.Ceil(coins * float64(sc.ReserveTokens) / sc.ReserveCoins))
+		if tokens > maxTokens {
+			return "", fmt.Errorf("liquidity requires %d tokens, above the maximum of %d", tokens, maxTokens)
+		}
+		shares = coins / sc.ReserveCoins * sc.TotalShares
+	}
+	if shares <= 0 || shares < minShares {
+		return "", fmt.Errorf("liquidity would mint %v shares, below the minimum of %v", shares, minShares)
+	}
+
+	if err := sc.receiveTokens(ctx, ctx.Caller(), tokens); err != nil {
+		return "", err
+	}
+	if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+		return "", err
+	}
+	sc.ReserveCoins += coins
+	sc.ReserveTokens += tokens
+	sc.TotalShares += shares
+	sc.Shares[ctx.Caller()] += shares
+	err := ctx.Emit("LiquidityAdded", []string{ctx.Caller()}, map[string]float64{
+		"coins":  coins,
+		"tokens": float64(tokens),
+		"shares": shares,
+	})
+	return strconv.FormatFloat(shares, 'f', -1, 64), err
+}
+
+// removeLiquidity burns shares of the provider and returns its portion of the reserves
+func (sc *ContractCodeAMM) removeLiquidity(ctx ExecutionContext) (string, error) {
+	var shares, minCoins float64
+	var minTokens int64
+	if err := ctx.Arg("shares", &shares); err != nil {
+		return "", err
+	}
+	ctx.Arg("min_coins", &minCoins)   // Optional
+	ctx.Arg("min_tokens", &minTokens) // Optional
+	provider := ctx.Caller()
+	if shares <= 0 || shares > sc.Shares[provider] {
+		return "", fmt.Errorf("shares must be positive and at most the %v shares of the provider", sc.Shares[provider])
+	}
+
+	portion := shares / sc.TotalShares
+	coins := sc.ReserveCoins * portion
+	tokens := int64(math.Floor(float64(sc.ReserveTokens) * portion))
+	if shares == sc.TotalShares {
+		coins, tokens = sc.ReserveCoins, sc.ReserveTokens
+	}
+	if coins < minCoins || tokens < minTokens {
+		return "", fmt.Errorf("liquidity would return %v coins and %d tokens, below the minimum", coins, tokens)
+	}
+
+	if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+		return "", err
+	}
+	sc.ReserveCoins -= coins
+	sc.ReserveTokens -= tokens
+	sc.TotalShares -= shares
+	sc.Shares[provider] -= shares
+	if sc.Shares[provider] == 0 {
+		delete(sc.Shares, provider)
+	}
+	if coins > 0 {
+		if err := ctx.Transfer(provider, coins); err != nil {
+			return "", err
+		}
+	}
+	if err := sc.sendTokens(ctx, provider, tokens); err != nil {
+		return "", err
+	}
+	result := map[string]float64{
+		"coins":  coins,
+		"tokens": float64(tokens),
+		"shares": shares,
+	}
+	if err := ctx.Emit("LiquidityRemoved", []string{provider}, result); err != nil {
+		return "", err
+	}
+	return marshalResult(result)
+}
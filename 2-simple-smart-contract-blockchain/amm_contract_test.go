@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// ammReserves reads the reserves of a pool
+func ammReserves(t *testing.T, bc *Blockchain, amm string) AMMReserves {
+	t.Helper()
+	var reserves AMMReserves
+	if err := json.Unmarshal([]byte(callView(t, bc, amm, "reserves", nil)), &reserves); err != nil {
+		t.Fatal(err)
+	}
+	return reserves
+}
+
+func TestAMMRequiresATokenContract(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+
+	for _, token := range []string{counter, "missing"} {
+		code, _ := newCode(CONTRACT_AMM_TYPE)
+		contract := SmartContract{
+			ContractID:    "amm-" + token,
+			Wallet:        alice.ID,
+			Type:          CONTRACT_AMM_TYPE,
+			Specification: fmt.Sprintf(`{ "token": %q, "fee": 0.003 }`, token),
+			Nonce:         alice.nextNonce(),
+			Code:          code,
+		}
+		contract.Signature = alice.sign(t, contract.signingMessage())
+		if err := bc.addContract(contract); err == nil || !strings.Contains(err.Error(), "validation failed") {
+			t.Fatalf("pool of %s was deployed: %v", token, err)
+		}
+	}
+}
+
+func TestAMMSwapsKeepTheProductOfTheReserves(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	token := deployToken(t, bc, alice, 10000)
+	amm := deployContract(t, bc, alice, CONTRACT_AMM_TYPE, fmt.Sprintf(`{ "token": %q, "fee": 0.003 }`, token))
+
+	mustSucceed(t, executeContract(t, bc, alice, token, "approve", map[string]any{"spender": amm, "amount": 1000}, 0))
+	if shares := mustSucceed(t, executeContract(t, bc, alice, amm, "add_liquidity", map[string]any{"max_tokens": 1000}, 10)); shares != "100" {
+		t.Fatalf("liquidity minted %s shares", shares)
+	}
+	product := func() float64 {
+		reserves := ammReserves(t, bc, amm)
+		return reserves.Coins * float64(reserves.Tokens)
+	}
+	before := product()
+
+	mustFail(t, executeContract(t, bc, bob, amm, "swap_coins_for_tokens", map[string]any{"min_tokens": 91}, 1))
+	if tokens := mustSucceed(t, executeContract(t, bc, bob, amm, "swap_coins_for_tokens", map[string]any{"min_tokens": 90}, 1)); tokens != "90" {
+		t.Fatalf("swap returned %s tokens", tokens)
+	}
+	if got := callView(t, bc, token, "balance_of", map[string]any{"owner": bob.ID}); got != "90" {
+		t.Fatalf("trader holds %s tokens", got)
+	}
+	if after := product(); after < before {
+		t.Fatalf("product of the reserves fell from %v to %v", before, after)
+	}
+
+	mustSucceed(t, executeContract(t, bc, bob, token, "approve", map[string]any{"spender": amm, "amount": 90}, 0))
+	mustFail(t, executeContract(t, bc, bob, amm, "swap_tokens_for_coins", map[string]any{"tokens": 90, "min_coins": 1}, 0))
+	mustSucceed(t, executeContract(t, bc, bob, amm, "swap_tokens_for_coins", map[string]any{"tokens": 90, "min_coins": 0.98}, 0))
+	if after := product(); after < before {
+		t.Fatalf("product of the reserves fell from %v to %v", before, after)
+	}
+
+	mustSucceed(t, executeContract(t, bc, alice, amm, "remove_liquidity", map[string]any{"shares": 100}, 0))
+	if reserves := ammReserves(t, bc, amm); reserves.Coins != 0 || reserves.Tokens != 0 || reserves.TotalShares != 0 {
+		t.Fatalf("pool kept %+v after all liquidity was removed", reserves)
+	}
+	if got := callView(t, bc, token, "balance_of", map[string]any{"owner": alice.ID}); got != "10000" {
+		t.Fatalf("provider holds %s tokens", got)
+	}
+}
+
+func TestAMMQuotesMatchTheSwaps(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	token := deployToken(t, bc, alice, 10000)
+	amm := deployContract(t, bc, alice, CONTRACT_AMM_TYPE, fmt.Sprintf(`{ "token": %q, "fee": 0.003 }`, token))
+	mustSucceed(t, executeContract(t, bc, alice, token, "approve", map[string]any{"spender": amm, "amount": 1000}, 0))
+	mustSucceed(t, executeContract(t, bc, alice, amm, "add_liquidity", map[string]any{"max_tokens": 1000}, 10))
+
+	quote := callView(t, bc, amm, "quote", map[string]any{"input": "coins", "amount": 1})
+	if tokens := mustSucceed(t, executeContract(t, bc, bob, amm, "swap_coins_for_tokens", map[string]any{"min_tokens": 0}, 1)); tokens != quote {
+		t.Fatalf("swap returned %s tokens, quoted %s", tokens, quote)
+	}
+	quote = callView(t, bc, amm, "quote", map[string]any{"input": "tokens", "amount": 50})
+	mustSucceed(t, executeContract(t, bc, bob, token, "approve", map[string]any{"spender": amm, "amount": 50}, 0))
+	if coins := mustSucceed(t, executeContract(t, bc, bob, amm, "swap_tokens_for_coins", map[string]any{"tokens": 50, "min_coins": 0}, 0)); coins != quote {
+		t.Fatalf("swap returned %s coins, quoted %s", coins, quote)
+	}
+
+	// Tokens are swapped in whole units, a fractional amount is not truncated into a quote
+	fractional := ContractExecution{ContractID: amm, Method: "quote", Args: marshalArgs(t, map[string]any{"input": "tokens", "amount": 2.5}), GasLimit: VIEW_GAS_LIMIT}
+	if call, err := bc.callContract(fractional); err == nil && call.Error == "" {
+		t.Fatalf("fractional amount of tokens was quoted %s", call.Result)
+	}
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+)
+
+const CONTRACT_AUCTION_TYPE string = "auction"
+
+func init() {
+	registerCode(CONTRACT_AUCTION_TYPE, func() Code { return &ContractCodeAuction{} })
+}
+
+// AuctionSpecification is the specification of a sealed-bid auction, given as JSON at deployment
+// Bids are committed before the CommitEnd block height and revealed before the RevealEnd height
+// Every bidder attaches the same Deposit, the highest bid accepted, so the attached value does
+// not reveal anything about a sealed bid
+type AuctionSpecification struct {
+	Item      string  `json:"item"`
+	MinBid    float64 `json:"min_bid"`
+	Deposit   float64 `json:"deposit"`
+	CommitEnd int     `json:"commit_end"`
+	RevealEnd int     `json:"reveal_end"`
+}
+
+// AuctionBid is the sealed bid of a bidder and the deposit covering it
+type AuctionBid struct {
+	Commitment string  `json:"commitment"` // Hex encoded SHA256 digest of bid|salt|bidder, bid as revealed
+	Deposit    float64 `json:"deposit"`
+	Bid        float64 `json:"bid"`
+	Revealed   bool    `json:"revealed"`
+	Refunded   bool    `json:"refunded"`
+}
+
+// AuctionStatus reports the phase and the leading bid of an auction
+type AuctionStatus struct {
+	AuctionSpecification
+	Seller        string  `json:"seller"`
+	Phase         string  `json:"phase"`
+	Bidders       int     `json:"bidders"`
+	HighestBidder string  `json:"highest_bidder"`
+	HighestBid    float64 `json:"highest_bid"`
+}
+
+// ContractCodeAuction implements a sealed-bid auction run by the deployer, bids are committed with
+// a deposit then revealed, the losing deposits are refunded and the highest bid pays the seller
+type ContractCodeAuction struct {
+	AuctionSpecification
+	Seller        string                 `json:"seller"`
+	Bids          map[string]*AuctionBid `json:"bids"`
+	HighestBidder string                 `json:"highest_bidder"`
+	Settled       bool                   `json:"settled"`
+}
+
+// auctionCommitment calculates the commitment of a bid, the hex encoded SHA256 digest of
+// bid|salt|bidder. The bid is committed as the exact string the bidder reveals, whatever its
+// number formatting, and binds the bidder so a commitment cannot be copied to mirror a bid
+func auctionCommitment(bid string, salt string, bidder string) string {
+	hash := sha256.Sum256([]byte(signingMessage(bid, salt, bidder)))
+	return hex.EncodeToString(hash[:])
+}
+
+// Initialize configures the item and phases of the auction, the deployer is the seller
+func (sc *ContractCodeAuction) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.AuctionSpecification); err != nil {
+		return fmt.Errorf("auction specification must be JSON: %v", err)
+	}
+	sc.Seller = owner
+	sc.Bids = map[string]*AuctionBid{}
+	return nil
+}
+
+func (sc *ContractCodeAuction) Validate(chain ChainReader) bool {
+	return sc.Item != "" && sc.MinBid >= 0 && sc.Deposit > 0 && sc.Deposit >= sc.MinBid &&
+		sc.CommitEnd > chain.BlockHeight() && sc.RevealEnd > sc.CommitEnd
+}
+
+func (sc *ContractCodeAuction) ABI() ContractABI {
+	bidder := ParamABI{Name: "bidder", Type: "address"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "auction", Params: []ParamABI{}, Returns: "object", ReadOnly: true},
+			{Name: "bid", Params: []ParamABI{bidder}, Returns: "object", ReadOnly: true},
+			{Name: "commit", Params: []ParamABI{{Name: "commitment", Type: "string"}}, Returns: "number", Payable: true},
+			{Name: "reveal", Params: []ParamABI{{Name: "bid", Type: "string"}, {Name: "salt", Type: "string"}}, Returns: "address"},
+			{Name: "settle", Params: []ParamABI{}, Returns: "address"},
+		},
+		Events: []EventABI{
+			{Name: "Committed", Topics: []string{"bidder"}, Payload: []ParamABI{{Name: "deposit", Type: "number"}}},
+			{Name: "Revealed", Topics: []string{"bidder"}, Payload: []ParamABI{{Name: "bid", Type: "number"}}},
+			{Name: "Refunded", Topics: []string{"bidder"}, Payload: []ParamABI{{Name: "amount", Type: "number"}}},
+			{Name: "Settled", Topics: []string{"winner"}, Payload: []ParamABI{{Name: "bid", Type: "number"}, {Name: "forfeited", Type: "number"}}},
+		},
+	}
+}
+
+func (sc *ContractCodeAuction) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "auction":
+		return marshalResult(sc.status(ctx.BlockHeight()))
+	case "bid":
+		var bidder string
+		if err := ctx.Arg("bidder", &bidder); err != nil {
+			return "", err
+		}
+		bid, exists := sc.Bids[bidder]
+		if !exists {
+			return "", fmt.Errorf("no bid from %s", bidder)
+		}
+		// Unrevealed bids stay sealed
+		return marshalResult(bid)
+	case "settle":
+		return sc.settle(ctx)
+	}
+
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	bidder := ctx.Caller()
+	switch ctx.Method() {
+	case "commit":
+		if phase := sc.phase(ctx.BlockHeight()); phase != "commit" {
+			return "", fmt.Errorf("auction is in the %s phase", phase)
+		}
+		if bidder == sc.Seller {
+			return "", fmt.Errorf("the seller cannot bid")
+		}
+		if _, exists := sc.Bids[bidder]; exists {
+			return "", fmt.Errorf("bid already committed")
+		}
+		bid := &AuctionBid{Deposit: ctx.Value()}
+		if err := ctx.Arg("commitment", &bid.Commitment); err != nil {
+			return "", err
+		}
+		if digest, err := hex.DecodeString(bid.Commitment); err != nil || len(digest) != sha256.Size {
+			return "", fmt.Errorf("commitment must be a hex encoded SHA256 digest")
+		}
+		if bid.Deposit != sc.Deposit {
+			return "", fmt.Errorf("commit must attach the deposit of %v", sc.Deposit)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		sc.Bids[bidder] = bid
+		return strconv.FormatFloat(bid.Deposit, 'f', -1, 64), ctx.Emit("Committed", []string{bidder}, map[string]float64{"deposit": bid.Deposit})
+	case "reveal":
+		if phase := sc.phase(ctx.BlockHeight()); phase != "reveal" {
+			return "", fmt.Errorf("auction is in the %s phase", phase)
+		}
+		bid, exists := sc.Bids[bidder]
+		if !exists {
+			return "", fmt.Errorf("no bid committed")
+		}
+		if bid.Revealed {
+			return "", fmt.Errorf("bid already revealed")
+		}
+		var revealed, salt string
+		if err := ctx.Arg("bid", &revealed); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("salt", &salt); err != nil {
+			return "", err
+		}
+		if auctionCommitment(revealed, salt, bidder) != bid.Commitment {
+			return "", fmt.Errorf("bid does not match the commitment")
+		}
+		amount, err := strconv.ParseFloat(revealed, 64)
+		if err != nil || amount < 0 || math.IsInf(amount, 0) || math.IsNaN(amount) {
+			return "", fmt.Errorf("bid must be a non-negative number")
+		}
+		if amount > bid.Deposit {
+			return "", fmt.Errorf("bid exceeds the deposit")
+		}
+		bid.Revealed = true
+		bid.Bid = amount
+		if err := ctx.Emit("Revealed", []string{bidder}, map[string]float64{"bid": amount}); err != nil {
+			return "", err
+		}
+
+		// The deposit of the bid losing the lead is refunded
+		var loser string
+		switch {
+		case amount < sc.MinBid:
+			loser = bidder
+		case sc.HighestBidder == "" || amount > sc.Bids[sc.HighestBidder].Bid:
+			loser, sc.HighestBidder = sc.HighestBidder, bidder
+		default:
+			loser = bidder
+		}
+		if loser != "" {
+			if err := sc.refund(ctx, loser, sc.Bids[loser].Deposit); err != nil {
+				return "", err
+			}
+		}
+		return sc.HighestBidder, nil
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// phase returns the phase of the auction at a block height
+func (sc *ContractCodeAuction) phase(height int) string {
+	switch {
+	case sc.Settled:
+		return "settled"
+	case height < sc.CommitEnd:
+		return "commit"
+	case height < sc.RevealEnd:
+		return "reveal"
+	}
+	return "ended"
+}
+
+// status reports the phase and the leading bid of the auction at a block height
+func (sc *ContractCodeAuction) status(height int) AuctionStatus {
+	status := AuctionStatus{
+		AuctionSpecification: sc.AuctionSpecification,
+		Seller:               sc.Seller,
+		Phase:                sc.phase(height),
+		Bidders:              len(sc.Bids),
+		HighestBidder:        sc.HighestBidder,
+	}
+	if sc.HighestBidder != "" {
+		status.HighestBid = sc.Bids[sc.HighestBidder].Bid
+	}
+	return status
+}
+
+// refund returns coins deposited by a bidder
+func (sc *ContractCodeAuction) refund(ctx ExecutionContext, bidder string, amount float64) error {
+	sc.Bids[bidder].Refunded = true
+	if amount <= 0 {
+		return nil
+	}
+	if err := ctx.Transfer(bidder, amount); err != nil {
+		return err
+	}
+	return ctx.Emit("Refunded", []string{bidder}, map[string]float64{"amount": amount})
+}
+
+// settle ends the auction once the reveal phase is over, anyone can settle it. The highest bid
+// is paid to the seller with the deposits of the unrevealed bids, and the rest of the winner's
+// deposit is refunded
+func (sc *ContractCodeAuction) settle(ctx ExecutionContext) (string, error) {
+	if phase := sc.phase(ctx.BlockHeight()); phase != "ended" {
+		return "", fmt.Errorf("auction is in the %s phase", phase)
+	}
+	sc.Settled = true
+
+	// Bidders are summed in order so every node computes the same forfeited amount
+	bidders := make([]string, 0, len(sc.Bids))
+	for bidder := range sc.Bids {
+		bidders = append(bidders, bidder)
+	}
+	sort.Strings(bidders)
+	forfeited := 0.0
+	for _, bidder := range bidders {
+		if bid := sc.Bids[bidder]; !bid.Revealed {
+			forfeited += bid.Deposit
+		}
+	}
+	proceeds := forfeited
+	winningBid := 0.0
+	if sc.HighestBidder != "" {
+		winner := sc.Bids[sc.HighestBidder]
+		winningBid = winner.Bid
+		proceeds += winner.Bid
+		if err := sc.refund(ctx, sc.HighestBidder, winner.Deposit-winner.Bid); err != nil {
+			return "", err
+		}
+	}
+	if proceeds > 0 {
+		if err := ctx.Transfer(sc.Seller, proceeds); err != nil {
+			return "", err
+		}
+	}
+	err := ctx.Emit("Settled", []string{sc.HighestBidder}, map[string]float64{
+		"bid":       winningBid,
+		"forfeited": forfeited,
+	})
+	return sc.HighestBidder, err
+}
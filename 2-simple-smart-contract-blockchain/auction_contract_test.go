@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestAuctionRevealsTheExactCommittedBid(t *testing.T) {
+	bc := newTestBlockchain()
+	seller, alice, bob := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{seller, alice, bob} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	commitEnd := len(bc.Chain) + 4
+	spec := fmt.Sprintf(`{ "item": "painting", "min_bid": 1, "deposit": 5, "commit_end": %d, "reveal_end": %d }`, commitEnd, commitEnd+3)
+	auction := deployContract(t, bc, seller, CONTRACT_AUCTION_TYPE, spec)
+
+	// Every bidder attaches the same deposit, whatever its bid
+	mustFail(t, executeContract(t, bc, alice, auction, "commit", map[string]any{"commitment": auctionCommitment("2.50", "alice-salt", alice.ID)}, 2.5))
+	mustSucceed(t, executeContract(t, bc, alice, auction, "commit", map[string]any{"commitment": auctionCommitment("2.50", "alice-salt", alice.ID)}, 5))
+	mustSucceed(t, executeContract(t, bc, bob, auction, "commit", map[string]any{"commitment": auctionCommitment("3", "bob-salt", bob.ID)}, 5))
+	for len(bc.Chain)-1 < commitEnd {
+		mineBlocks(t, bc, seller.ID, 1)
+	}
+
+	// The bid is checked against the string committed, not a reformatted number
+	mustFail(t, executeContract(t, bc, alice, auction, "reveal", map[string]any{"bid": "2.5", "salt": "alice-salt"}, 0))
+	if leader := mustSucceed(t, executeContract(t, bc, alice, auction, "reveal", map[string]any{"bid": "2.50", "salt": "alice-salt"}, 0)); leader != alice.ID {
+		t.Fatalf("leader is %s after the first reveal", leader)
+	}
+	for len(bc.Chain)-1 < commitEnd+3 {
+		mineBlocks(t, bc, seller.ID, 1)
+	}
+
+	before := bc.getBalance(seller.ID)
+	settled := executeContract(t, bc, alice, auction, "settle", nil, 0)
+	if mustSucceed(t, settled) != alice.ID {
+		t.Fatalf("auction settled to %s", settled.Result)
+	}
+	// The seller is paid the winning bid and the deposit of the unrevealed bid
+	if paid := bc.getBalance(seller.ID) - before; paid != 7.5 {
+		t.Fatalf("seller was paid %v", paid)
+	}
+}
+
+func TestAuctionCommitmentIsBoundToTheBidder(t *testing.T) {
+	bc := newTestBlockchain()
+	seller, alice, mallory := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{seller, alice, mallory} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	commitEnd := len(bc.Chain) + 4
+	spec := fmt.Sprintf(`{ "item": "painting", "min_bid": 1, "deposit": 5, "commit_end": %d, "reveal_end": %d }`, commitEnd, commitEnd+3)
+	auction := deployContract(t, bc, seller, CONTRACT_AUCTION_TYPE, spec)
+
+	// Mallory copies the commitment of Alice and waits for her reveal to mirror her bid
+	commitment := auctionCommitment("4", "alice-salt", alice.ID)
+	mustSucceed(t, executeContract(t, bc, alice, auction, "commit", map[string]any{"commitment": commitment}, 5))
+	mustSucceed(t, executeContract(t, bc, mallory, auction, "commit", map[string]any{"commitment": commitment}, 5))
+	for len(bc.Chain)-1 < commitEnd {
+		mineBlocks(t, bc, seller.ID, 1)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, auction, "reveal", map[string]any{"bid": "4", "salt": "alice-salt"}, 0))
+	mustFail(t, executeContract(t, bc, mallory, auction, "reveal", map[string]any{"bid": "4", "salt": "alice-salt"}, 0))
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+)
+
+const BEACON_ESCROW_WALLET string = "Beacon Escrow"
+const BEACON_ROUND_BLOCKS int = 10   // Blocks of a beacon round, its commit phase followed by its reveal phase
+const BEACON_COMMIT_BLOCKS int = 5   // Blocks of the commit phase at the start of each round
+const BEACON_MIN_DEPOSIT float64 = 1 // Minimum coins deposited with a commitment, forfeited when the secret is not revealed
+const BEACON_SECRET_SIZE int = 32    // Bytes of a secret
+
+// BeaconCommit represents the commitment of a participant to a secret for a beacon round
+type BeaconCommit struct {
+	Round       int     `json:"round"`
+	Participant string  `json:"participant"`
+	Commitment  string  `json:"commitment"` // Hex encoded SHA256 digest of secret|participant
+	Deposit     float64 `json:"deposit"`    // Coins held until the secret is revealed, at least BEACON_MIN_DEPOSIT
+	Signature   string  `json:"signature"`
+}
+
+// BeaconReveal represents the secret revealed by a participant for a beacon round
+type BeaconReveal struct {
+	Round       int    `json:"round"`
+	Participant string `json:"participant"`
+	Secret      string `json:"secret"` // Hex encoded
+}
+
+// BeaconRound represents the outcome of a closed beacon round, its random value is the XOR
+// of the revealed secrets and the deposits of the unrevealed ones are shared by the revealers in
+// proportion to their own deposits
+type BeaconRound struct {
+	Round     int     `json:"round"`
+	Value     string  `json:"value"` // Hex encoded, empty when no secret was revealed
+	Commits   int     `json:"commits"`
+	Reveals   int     `json:"reveals"`
+	Forfeited float64 `json:"forfeited"`
+}
+
+// BeaconStatus reports the phase and the participation of a beacon round
+type BeaconStatus struct {
+	Round       int          `json:"round"`
+	Phase       string       `json:"phase"` // commit, reveal, closed or upcoming
+	CommitStart int          `json:"commit_start"`
+	RevealStart int          `json:"reveal_start"`
+	End         int          `json:"end"`
+	Commits     []string     `json:"commits"`
+	Reveals     []string     `json:"reveals"`
+	Result      *BeaconRound `json:"result"`
+}
+
+// beaconRound returns the beacon round of a block height
+func beaconRound(height int) int {
+	return height / BEACON_ROUND_BLOCKS
+}
+
+// beaconCommitment calculates the commitment of a participant to a secret, binding the
+// participant so a commitment cannot be copied to cancel out the secret
+func beaconCommitment(secret string, participant string) string {
+	hash := sha256.Sum256([]byte(signingMessage(secret, participant)))
+	return hex.EncodeToString(hash[:])
+}
+
+// signingMessage returns the message the participant signs to authorise the commitment
+func (c BeaconCommit) signingMessage() string {
+	return signingMessage("beacon-commit", strconv.Itoa(c.Round), c.Commitment, strconv.FormatFloat(c.Deposit, 'f', -1, 64))
+}
+
+// beaconPhase returns the phase of a beacon round at a block height
+func beaconPhase(round int, height int) string {
+	start := round * BEACON_ROUND_BLOCKS
+	switch {
+	case height < start:
+		return "upcoming"
+	case height < start+BEACON_COMMIT_BLOCKS:
+		return "commit"
+	case height < start+BEACON_ROUND_BLOCKS:
+		return "reveal"
+	}
+	return "closed"
+}
+
+// findBeaconCommit returns the commitment of a participant to a round and whether it was revealed
+func (bc *Blockchain) findBeaconCommit(round int, participant string) (*BeaconCommit, bool) {
+	var commit *BeaconCommit
+	revealed := false
+	for i := range bc.Chain {
+		data := &bc.Chain[i].Data
+		for j := range data.BeaconCommits {
+			if data.BeaconCommits[j].Round == round && data.BeaconCommits[j].Participant == participant {
+				commit = &data.BeaconCommits[j]
+			}
+		}
+		for _, reveal := range data.BeaconReveals {
+			if reveal.Round == round && reveal.Participant == participant {
+				revealed = true
+			}
+		}
+	}
+	return commit, revealed
+}
+
+// findBeaconRound returns the outcome of a closed beacon round
+func (bc *Blockchain) findBeaconRound(round int) *BeaconRound {
+	for i := range bc.Chain {
+		data := &bc.Chain[i].Data
+		for j := range data.BeaconRounds {
+			if data.BeaconRounds[j].Round == round {
+				return &data.BeaconRounds[j]
+			}
+		}
+	}
+	return nil
+}
+
+// getRandomness returns the random value of a closed beacon round
+// The last participant to reveal knows the value before revealing and can withhold the secret to
+// choose between two values, losing only its deposit: what depends on a round should be worth
+// less than the deposits committed to it
+func (bc *Blockchain) getRandomness(round int) (string, error) {
+	result := bc.findBeaconRound(round)
+	if result == nil {
+		return "", fmt.Errorf("beacon round %d is not closed", round)
+	}
+	if result.Value == "" {
+		return "", fmt.Errorf("no secret was revealed in beacon round %d", round)
+	}
+	return result.Value, nil
+}
+
+// checkBeaconCommit checks a commitment can be stored in the current block
+func (bc *Blockchain) checkBeaconCommit(commit BeaconCommit) error {
+	if phase := beaconPhase(commit.Round, bc.height()); phase != "commit" {
+		return fmt.Errorf("beacon round %d is not in its commit phase", commit.Round)
+	}
+	if existing, _ := bc.findBeaconCommit(commit.Round, commit.Participant); existing != nil {
+		return fmt.Errorf("participant already committed to beacon round %d", commit.Round)
+	}
+	return nil
+}
+
+// addBeaconCommit adds a commitment to the beacon commit pool after validating it
+func (bc *Blockchain) addBeaconCommit(commit BeaconCommit) error {
+	if err := verifySignature(commit.Participant, commit.signingMessage(), commit.Signature); err != nil {
+		return fmt.Errorf("commit signature verification failed: %v", err)
+	}
+	if err := bc.checkBeaconCommit(commit); err != nil {
+		return err
+	}
+	if digest, err := hex.DecodeString(commit.Commitment); err != nil || len(digest) != sha256.Size {
+		return fmt.Errorf("commitment must be a hex encoded SHA256 digest")
+	}
+	for _, pending := range bc.BeaconCommitPool {
+		if pending.Round == commit.Round && pending.Participant == commit.Participant {
+			return fmt.Errorf("participant already committed to beacon round %d", commit.Round)
+		}
+	}
+	if commit.Deposit < BEACON_MIN_DEPOSIT {
+		return fmt.Errorf("deposit must be at least %v", BEACON_MIN_DEPOSIT)
+	}
+
+	// The deposit is reserved from the participant's balance until the commitment is mined
+	if bc.getBalance(commit.Participant) < commit.Deposit {
+		return fmt.Errorf("insufficient balance for the deposit of %v", commit.Deposit)
+	}
+
+	bc.BeaconCommitPool = append(bc.BeaconCommitPool, commit)
+	return nil
+}
+
+// mineBeaconCommit mines commitments from the beacon commit pool into the current block
+// The deposit chosen by the participant is transferred to the beacon escrow
+func (bc *Blockchain) mineBeaconCommit() (BeaconCommit, error) {
+	if len(bc.BeaconCommitPool) == 0 {
+		return BeaconCommit{}, fmt.Errorf("no beacon commitments to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first commitment in the pool (FIFO) and remove it from the pool,
+	// releasing the deposit reserved for it
+	commit := bc.BeaconCommitPool[0]
+	bc.BeaconCommitPool = bc.BeaconCommitPool[1:]
+
+	// The commit phase may have ended and the balance been spent since the commitment was added to the pool
+	if err := bc.checkBeaconCommit(commit); err != nil {
+		return BeaconCommit{}, fmt.Errorf("commitment of %s is no longer valid: %v", commit.Participant, err)
+	}
+	if bc.getBalance(commit.Participant) < commit.Deposit {
+		return BeaconCommit{}, fmt.Errorf("participant cannot afford the deposit of %v", commit.Deposit)
+	}
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   commit.Participant,
+		To:     BEACON_ESCROW_WALLET,
+		Amount: commit.Deposit,
+	})
+	lastBlock.Data.BeaconCommits = append(lastBlock.Data.BeaconCommits, commit)
+	return commit, nil
+}
+
+// checkBeaconReveal checks a revealed secret can be stored in the current block against the
+// commitment, which it returns
+func (bc *Blockchain) checkBeaconReveal(reveal BeaconReveal) (*BeaconCommit, error) {
+	if phase := beaconPhase(reveal.Round, bc.height()); phase != "reveal" {
+		return nil, fmt.Errorf("beacon round %d is not in its reveal phase", reveal.Round)
+	}
+	commit, revealed := bc.findBeaconCommit(reveal.Round, reveal.Participant)
+	if commit == nil {
+		return nil, fmt.Errorf("participant did not commit to beacon round %d", reveal.Round)
+	}
+	if revealed {
+		return nil, fmt.Errorf("secret already revealed")
+	}
+	if secret, err := hex.DecodeString(reveal.Secret); err != nil || len(secret) != BEACON_SECRET_SIZE {
+		return nil, fmt.Errorf("secret must be %d hex encoded bytes", BEACON_SECRET_SIZE)
+	}
+	if beaconCommitment(reveal.Secret, reveal.Participant) != commit.Commitment {
+		return nil, fmt.Errorf("secret does not match the commitment")
+	}
+	return commit, nil
+}
+
+// addBeaconReveal adds a revealed secret to the beacon reveal pool after checking it against the
+// commitment
+func (bc *Blockchain) addBeaconReveal(reveal BeaconReveal) error {
+	if _, err := bc.checkBeaconReveal(reveal); err != nil {
+		return err
+	}
+	for _, pending := range bc.BeaconRevealPool {
+		if pending.Round == reveal.Round && pending.Participant == reveal.Participant {
+			return fmt.Errorf("secret already revealed")
+		}
+	}
+
+	bc.BeaconRevealPool = append(bc.BeaconRevealPool, reveal)
+	return nil
+}
+
+// mineBeaconReveal mines revealed secrets from the beacon reveal pool into the current block
+// The deposit is refunded to the participant
+func (bc *Blockchain) mineBeaconReveal() (BeaconReveal, error) {
+	if len(bc.BeaconRevealPool) == 0 {
+		return BeaconReveal{}, fmt.Errorf("no beacon reveals to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first reveal in the pool (FIFO) and remove it from the pool
+	reveal := bc.BeaconRevealPool[0]
+	bc.BeaconRevealPool = bc.BeaconRevealPool[1:]
+
+	// The reveal phase may have ended since the reveal was added to the pool
+	commit, err := bc.checkBeaconReveal(reveal)
+	if err != nil {
+		return BeaconReveal{}, fmt.Errorf("reveal of %s is no longer valid: %v", reveal.Participant, err)
+	}
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   BEACON_ESCROW_WALLET,
+		To:     reveal.Participant,
+		Amount: commit.Deposit,
+	})
+	lastBlock.Data.BeaconReveals = append(lastBlock.Data.BeaconReveals, reveal)
+	return reveal, nil
+}
+
+// closeBeaconRound stores the outcome of the round ending at the current block. The deposits
+// of the unrevealed secrets are shared by the revealers in proportion to their deposits, or stay
+// in the escrow without any
+func (bc *Blockchain) closeBeaconRound() {
+	height := len(bc.Chain) - 1
+	if height == 0 || height%BEACON_ROUND_BLOCKS != 0 {
+		return
+	}
+	round := beaconRound(height) - 1
+	if bc.findBeaconRound(round) != nil {
+		return
+	}
+
+	result := BeaconRound{Round: round}
+	value := make([]byte, BEACON_SECRET_SIZE)
+	revealers := []string{}
+	deposits := make(map[string]float64)
+	committed, revealed := 0.0, 0.0
+	for _, block := range bc.Chain {
+		for _, commit := range block.Data.BeaconCommits {
+			if commit.Round == round {
+				result.Commits++
+				deposits[commit.Participant] = commit.Deposit
+				committed += commit.Deposit
+			}
+		}
+		for _, reveal := range block.Data.BeaconReveals {
+			if reveal.Round != round {
+				continue
+			}
+			secret, _ := hex.DecodeString(reveal.Secret)
+			for i := range value {
+				value[i] ^= secret[i]
+			}
+			revealers = append(revealers, reveal.Participant)
+			revealed += deposits[reveal.Participant]
+		}
+	}
+	result.Reveals = len(revealers)
+	result.Forfeited = committed - revealed
+	if result.Reveals > 0 {
+		result.Value = hex.EncodeToString(value)
+	}
+
+	lastBlock := bc.getLastBlock()
+	if result.Reveals > 0 && result.Forfeited > 0 {
+		for _, revealer := range revealers {
+			lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+				From:   BEACON_ESCROW_WALLET,
+				To:     revealer,
+				Amount: result.Forfeited * deposits[revealer] / revealed,
+			})
+		}
+	}
+	lastBlock.Data.BeaconRounds = append(lastBlock.Data.BeaconRounds, result)
+}
+
+// getBeaconStatus reports the phase and the participation of a beacon round
+func (bc *Blockchain) getBeaconStatus(round int) BeaconStatus {
+	start := round * BEACON_ROUND_BLOCKS
+	status := BeaconStatus{
+		Round:       round,
+		Phase:       beaconPhase(round, len(bc.Chain)-1),
+		CommitStart: start,
+		RevealStart: start + BEACON_COMMIT_BLOCKS,
+		End:         start + BEACON_ROUND_BLOCKS,
+		Commits:     []string{},
+		Reveals:     []string{},
+		Result:      bc.findBeaconRound(round),
+	}
+	for _, block := range bc.Chain {
+		for _, commit := range block.Data.BeaconCommits {
+			if commit.Round == round {
+				status.Commits = append(status.Commits, commit.Participant)
+			}
+		}
+		for _, reveal := range block.Data.BeaconReveals {
+			if reveal.Round == round {
+				status.Reveals = append(status.Reveals, reveal.Participant)
+			}
+		}
+	}
+	return status
+}
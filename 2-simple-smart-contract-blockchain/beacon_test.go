@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+// commitSecret commits the wallet to a secret for a beacon round with a deposit
+func commitSecret(t *testing.T, bc *Blockchain, w *testWallet, round int, secret string, deposit float64) error {
+	t.Helper()
+	commit := BeaconCommit{Round: round, Participant: w.ID, Commitment: beaconCommitment(secret, w.ID), Deposit: deposit}
+	commit.Signature = w.sign(t, commit.signingMessage())
+	if err := bc.addBeaconCommit(commit); err != nil {
+		return err
+	}
+	_, err := bc.mineBeaconCommit()
+	return err
+}
+
+// revealSecret adds a reveal to the beacon reveal pool and mines it
+func revealSecret(bc *Blockchain, reveal BeaconReveal) error {
+	if err := bc.addBeaconReveal(reveal); err != nil {
+		return err
+	}
+	_, err := bc.mineBeaconReveal()
+	return err
+}
+
+func TestBeaconCommitsOnlyDuringTheCommitPhase(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	secret := strings.Repeat("01", BEACON_SECRET_SIZE)
+
+	if err := commitSecret(t, bc, alice, 1, secret, BEACON_MIN_DEPOSIT); err == nil || !strings.Contains(err.Error(), "commit phase") {
+		t.Fatalf("commit to an upcoming round returned %v", err)
+	}
+	if err := commitSecret(t, bc, alice, 0, secret, BEACON_MIN_DEPOSIT/2); err == nil || !strings.Contains(err.Error(), "deposit") {
+		t.Fatalf("commit below the minimum deposit returned %v", err)
+	}
+	if err := commitSecret(t, bc, alice, 0, secret, 1000); err == nil || !strings.Contains(err.Error(), "insufficient balance") {
+		t.Fatalf("commit of a deposit above the balance returned %v", err)
+	}
+	forged := BeaconCommit{Round: 0, Participant: alice.ID, Commitment: beaconCommitment(secret, alice.ID), Deposit: 1}
+	forged.Signature = alice.sign(t, forged.signingMessage())
+	forged.Deposit = 5
+	if err := bc.addBeaconCommit(forged); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("commit with a deposit it was not signed for returned %v", err)
+	}
+
+	if err := commitSecret(t, bc, alice, 0, secret, 5); err != nil {
+		t.Fatal(err)
+	}
+	if err := commitSecret(t, bc, alice, 0, secret, 5); err == nil || !strings.Contains(err.Error(), "already committed") {
+		t.Fatalf("second commit returned %v", err)
+	}
+	if err := revealSecret(bc, BeaconReveal{Round: 0, Participant: alice.ID, Secret: secret}); err == nil || !strings.Contains(err.Error(), "reveal phase") {
+		t.Fatalf("reveal during the commit phase returned %v", err)
+	}
+
+	mineUntil(t, bc, miner.ID, BEACON_COMMIT_BLOCKS)
+	if err := commitSecret(t, bc, miner, 0, secret, BEACON_MIN_DEPOSIT); err == nil || !strings.Contains(err.Error(), "commit phase") {
+		t.Fatalf("commit during the reveal phase returned %v", err)
+	}
+}
+
+func TestBeaconRevealMustMatchTheCommitment(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, miner := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	secret := strings.Repeat("02", BEACON_SECRET_SIZE)
+	if err := commitSecret(t, bc, alice, 0, secret, BEACON_MIN_DEPOSIT); err != nil {
+		t.Fatal(err)
+	}
+	// Bob copies the commitment of Alice, bound to Alice it cannot be revealed by Bob
+	copied := BeaconCommit{Round: 0, Participant: bob.ID, Commitment: beaconCommitment(secret, alice.ID), Deposit: BEACON_MIN_DEPOSIT}
+	copied.Signature = bob.sign(t, copied.signingMessage())
+	if err := bc.addBeaconCommit(copied); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineBeaconCommit(); err != nil {
+		t.Fatal(err)
+	}
+	mineUntil(t, bc, miner.ID, BEACON_COMMIT_BLOCKS)
+
+	tests := []struct {
+		name   string
+		reveal BeaconReveal
+		reason string
+	}{
+		{"another secret", BeaconReveal{Round: 0, Participant: alice.ID, Secret: strings.Repeat("03", BEACON_SECRET_SIZE)}, "does not match"},
+		{"copied commitment", BeaconReveal{Round: 0, Participant: bob.ID, Secret: secret}, "does not match"},
+		{"short secret", BeaconReveal{Round: 0, Participant: alice.ID, Secret: "02"}, "hex encoded bytes"},
+		{"no commitment", BeaconReveal{Round: 0, Participant: miner.ID, Secret: secret}, "did not commit"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := revealSecret(bc, test.reveal); err == nil || !strings.Contains(err.Error(), test.reason) {
+				t.Fatalf("reveal returned %v, expected %q", err, test.reason)
+			}
+		})
+	}
+
+	if err := revealSecret(bc, BeaconReveal{Round: 0, Participant: alice.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	if err := revealSecret(bc, BeaconReveal{Round: 0, Participant: alice.ID, Secret: secret}); err == nil || !strings.Contains(err.Error(), "already revealed") {
+		t.Fatalf("second reveal returned %v", err)
+	}
+}
+
+func TestBeaconValueAndForfeitedDeposits(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol, miner := newTestWallet(t), newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	mineBlocks(t, bc, carol.ID, 1)
+	secrets := map[*testWallet]string{
+		alice: strings.Repeat("0f", BEACON_SECRET_SIZE),
+		bob:   strings.Repeat("f3", BEACON_SECRET_SIZE),
+		carol: strings.Repeat("55", BEACON_SECRET_SIZE),
+	}
+	deposits := map[*testWallet]float64{alice: 1, bob: 3, carol: 2}
+	before := map[*testWallet]float64{}
+	for _, w := range []*testWallet{alice, bob, carol} {
+		before[w] = bc.getBalance(w.ID)
+		if err := commitSecret(t, bc, w, 0, secrets[w], deposits[w]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Carol does not reveal, her deposit is shared by Alice and Bob in proportion to theirs
+	mineUntil(t, bc, miner.ID, BEACON_COMMIT_BLOCKS)
+	for _, w := range []*testWallet{alice, bob} {
+		if err := revealSecret(bc, BeaconReveal{Round: 0, Participant: w.ID, Secret: secrets[w]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := bc.getRandomness(0); err == nil {
+		t.Fatal("randomness of a round still in its reveal phase")
+	}
+	mineUntil(t, bc, miner.ID, BEACON_ROUND_BLOCKS)
+
+	value, err := bc.getRandomness(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := hex.EncodeToString(bytes.Repeat([]byte{0x0f ^ 0xf3}, BEACON_SECRET_SIZE)); value != expected {
+		t.Fatalf("round value is %s, expected the XOR of the revealed secrets %s", value, expected)
+	}
+	result := bc.findBeaconRound(0)
+	if result.Commits != 3 || result.Reveals != 2 || result.Forfeited != deposits[carol] {
+		t.Fatalf("round closed as %+v", result)
+	}
+	for w, share := range map[*testWallet]float64{alice: 0.5, bob: 1.5, carol: -2} {
+		if gained := bc.getBalance(w.ID) - before[w]; math.Abs(gained-share) > BALANCE_TOLERANCE {
+			t.Fatalf("wallet gained %v from the round, expected %v", gained, share)
+		}
+	}
+	if escrow := bc.getBalance(BEACON_ESCROW_WALLET); math.Abs(escrow) > BALANCE_TOLERANCE {
+		t.Fatalf("beacon escrow holds %v after the round", escrow)
+	}
+}
+
+func TestBeaconCommitAndRevealAreCheckedAgainWhenMined(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, miner := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	secret := strings.Repeat("04", BEACON_SECRET_SIZE)
+	if err := commitSecret(t, bc, alice, 0, secret, BEACON_MIN_DEPOSIT); err != nil {
+		t.Fatal(err)
+	}
+
+	// The deposit of a pending commitment is reserved, and the commitment expires with the commit phase
+	mineUntil(t, bc, miner.ID, BEACON_COMMIT_BLOCKS-1)
+	balance := bc.getBalance(bob.ID)
+	late := BeaconCommit{Round: 0, Participant: bob.ID, Commitment: beaconCommitment(secret, bob.ID), Deposit: balance}
+	late.Signature = bob.sign(t, late.signingMessage())
+	if err := bc.addBeaconCommit(late); err != nil {
+		t.Fatal(err)
+	}
+	if bc.getBalance(bob.ID) != 0 {
+		t.Fatalf("participant holds %v with a pending commitment", bc.getBalance(bob.ID))
+	}
+	mineUntil(t, bc, miner.ID, BEACON_COMMIT_BLOCKS)
+	if _, err := bc.mineBeaconCommit(); err == nil || !strings.Contains(err.Error(), "commit phase") {
+		t.Fatalf("commitment was mined after the commit phase: %v", err)
+	}
+	if bc.getBalance(bob.ID) != balance {
+		t.Fatalf("participant holds %v after the commitment was dropped", bc.getBalance(bob.ID))
+	}
+
+	// A reveal is pending once and expires with the reveal phase
+	reveal := BeaconReveal{Round: 0, Participant: alice.ID, Secret: secret}
+	mineUntil(t, bc, miner.ID, BEACON_ROUND_BLOCKS-1)
+	if err := bc.addBeaconReveal(reveal); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.addBeaconReveal(reveal); err == nil || !strings.Contains(err.Error(), "already revealed") {
+		t.Fatalf("second pending reveal returned %v", err)
+	}
+	mineUntil(t, bc, miner.ID, BEACON_ROUND_BLOCKS)
+	if _, err := bc.mineBeaconReveal(); err == nil || !strings.Contains(err.Error(), "reveal phase") {
+		t.Fatalf("reveal was mined after the round closed: %v", err)
+	}
+	if result := bc.findBeaconRound(0); result == nil || result.Reveals != 0 {
+		t.Fatalf("round closed with %+v", result)
+	}
+}
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
@@ -16,6 +20,8 @@ import (
 const BLOCK_REWARD_WALLET string = "Block Reward"
 const GAS_PRICE float64 = 0.1
 
+var GENESIS_TIMESTAMP = time.Date(2024, time.January, 1, 0, 0, 0, 0, time.UTC)
+
 // Block represents each 'item' in the blockchain
 type Block struct {
 	Data         BlockData   `json:"data"`
@@ -27,13 +33,27 @@ type Block struct {
 
 // Blockchain represents the entire chain
 type Blockchain struct {
-	GenesisBlock          Block
-	Chain                 []Block
-	TransactionPool       []Transaction
-	ContractExecutionPool []ContractExecution
-	Difficulty            int
-	RewardPerBlock        float64
-	MaxCoins              float64
+	GenesisBlock           Block
+	Chain                  []Block
+	TransactionPool        []Transaction
+	ContractExecutionPool  []ContractExecution
+	ContractDeploymentPool []SmartContract
+	ContractUpgradePool    []ContractUpgrade
+	SchedulePool           []ContractSchedule
+	HashLockPool           []HashLock
+	HashLockSettlementPool []HashLockSettlement
+	OracleFeedPool         []OracleFeed
+	OracleReportPool       []OracleReport
+	BeaconCommitPool       []BeaconCommit
+	BeaconRevealPool       []BeaconReveal
+	ContractStates         map[string]*ContractState // Live state of the deployed contracts, restored from the chain
+	EventBroker            *EventBroker
+	Difficulty             int
+	RewardPerBlock         float64
+	MaxCoins               float64
+	NameRegistry           string // Contract resolving names ending with NAME_SUFFIX, none when empty
+	NameRegistryAdmin      string // Wallet allowed to designate the name registry at runtime, none when empty
+	NameRegistryNonce      int64  // Nonce of the last name registry update signed by the admin
 }
 
 func (bc *Blockchain) appendNewEmptyBlock() {
@@ -49,16 +69,25 @@ func (bc Blockchain) getLastBlock() *Block {
 	return &bc.Chain[len(bc.Chain)-1]
 }
 
+// height returns the height of the block new operations are stored in, the genesis block being
+// at height 0. Here it is the open last block
+func (bc Blockchain) height() int {
+	return len(bc.Chain) - 1
+}
+
 // calculateHash calculates the hash of a block
 func (b Block) calculateHash() string {
 	data, _ := json.Marshal(b.Data)
-	blockData := b.PreviousHash + string(data) + b.Timestamp.String() + strconv.Itoa(b.Nonce)
+	// The timestamp is formatted in UTC so the hash survives the JSON round trip of the block
+	blockData := b.PreviousHash + string(data) + b.Timestamp.UTC().Format(time.RFC3339Nano) + strconv.Itoa(b.Nonce)
 	blockHash := sha256.Sum256([]byte(blockData))
 	return fmt.Sprintf("%x", blockHash)
 }
 
 // mine mines a block
 func (b *Block) mine(difficulty int) {
+	// The hash is recalculated first, the data of the open block changed since it was set
+	b.Hash = b.calculateHash()
 	for !strings.HasPrefix(b.Hash, strings.Repeat("0", difficulty)) {
 		b.Nonce++
 		b.Hash = b.calculateHash()
@@ -67,13 +96,16 @@ func (b *Block) mine(difficulty int) {
 
 // CreateBlockchain creates a new blockchain with a genesis block
 func CreateBlockchain(difficulty int, rewardPerBlock float64, maxCoins float64) Blockchain {
+	// The genesis block is the same on every node so their chains can replace each other
 	genesisBlock := Block{
-		Timestamp: time.Now(),
+		Timestamp: GENESIS_TIMESTAMP,
 	}
 	genesisBlock.Hash = genesisBlock.calculateHash() // Set initial hash without mining
 	return Blockchain{
 		GenesisBlock:   genesisBlock,
 		Chain:          []Block{genesisBlock},
+		ContractStates: make(map[string]*ContractState),
+		EventBroker:    NewEventBroker(),
 		Difficulty:     difficulty,
 		RewardPerBlock: rewardPerBlock,
 		MaxCoins:       maxCoins,
@@ -92,22 +124,239 @@ func (bc *Blockchain) findContractByID(contractID string) *SmartContract {
 	return nil
 }
 
-// addContract adds a smart contract directly to the current block
-func (bc *Blockchain) addContract(contract SmartContract) {
+// isContract checks if an address is the ID of a deployed contract
+func (bc *Blockchain) isContract(address string) bool {
+	for _, block := range bc.Chain {
+		for _, contract := range block.Data.Contracts {
+			if contract.ContractID == address {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// getContractState returns the live state of a deployed contract, restoring it from the
+// state recorded by the last mined execution modifying it, or from its deployment, with
+// the code of its current version
+func (bc *Blockchain) getContractState(contractID string) (*ContractState, error) {
+	if state, exists := bc.ContractStates[contractID]; exists {
+		return state, nil
+	}
+
+	contract := bc.findContractByID(contractID)
+	if contract == nil {
+		return nil, fmt.Errorf("contract %s not found", contractID)
+	}
+	version, err := bc.getContractVersion(contractID)
+	if err != nil {
+		return nil, err
+	}
+
+	data, _ := json.Marshal(ContractState{Code: contract.Code})
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			if executionState, exists := execution.States[contractID]; exists {
+				data = executionState
+			}
+		}
+	}
+
+	state, err := restoreContractState(version.Type, data)
+	if err != nil {
+		return nil, err
+	}
+	bc.ContractStates[contractID] = state
+	return state, nil
+}
+
+// replaceChain replaces the chain by a longer valid chain received from elsewhere
+// The live state of the contracts is restored from the new chain and the pools are revalidated
+func (bc *Blockchain) replaceChain(chain []Block) error {
+	if len(chain) <= len(bc.Chain) {
+		return fmt.Errorf("received chain is not longer than the current chain")
+	}
+	if err := bc.validateChain(chain); err != nil {
+		return fmt.Errorf("received chain is not valid: %v", err)
+	}
+
+	bc.Chain = chain
+	bc.ContractStates = make(map[string]*ContractState)
+	bc.revalidatePools()
+	return nil
+}
+
+// revalidatePools adds the pending operations again on top of the chain, dropping those it
+// already includes or no longer allows, and enqueues the scheduled executions it still owes
+func (bc *Blockchain) revalidatePools() {
+	transactions := bc.TransactionPool
+	executions := bc.ContractExecutionPool
+	deployments := bc.ContractDeploymentPool
+	upgrades := bc.ContractUpgradePool
+	schedules := bc.SchedulePool
+	locks := bc.HashLockPool
+	settlements := bc.HashLockSettlementPool
+	feeds := bc.OracleFeedPool
+	reports := bc.OracleReportPool
+	commits := bc.BeaconCommitPool
+	reveals := bc.BeaconRevealPool
+	bc.TransactionPool = nil
+	bc.ContractExecutionPool = nil
+	bc.ContractDeploymentPool = nil
+	bc.ContractUpgradePool = nil
+	bc.SchedulePool = nil
+	bc.HashLockPool = nil
+	bc.HashLockSettlementPool = nil
+	bc.OracleFeedPool = nil
+	bc.OracleReportPool = nil
+	bc.BeaconCommitPool = nil
+	bc.BeaconRevealPool = nil
+
+	for _, contract := range deployments {
+		// The code is initialized again from the specification
+		code, err := newCode(contract.Type)
+		if err != nil {
+			continue
+		}
+		contract.Code = code
+		bc.addContract(contract)
+	}
+	for _, upgrade := range upgrades {
+		bc.addContractUpgrade(upgrade)
+	}
+	for _, schedule := range schedules {
+		bc.addSchedule(schedule)
+	}
+	for _, lock := range locks {
+		bc.lockHash(lock)
+	}
+	for _, settlement := range settlements {
+		bc.settleHashLock(settlement)
+	}
+	for _, feed := range feeds {
+		bc.addOracleFeed(feed)
+	}
+	for _, report := range reports {
+		bc.addOracleReport(report, time.Now())
+	}
+	for _, commit := range commits {
+		bc.addBeaconCommit(commit)
+	}
+	for _, reveal := range reveals {
+		bc.addBeaconReveal(reveal)
+	}
+	for _, execution := range executions {
+		if execution.ScheduleID == "" {
+			bc.addContractExecution(execution)
+		}
+	}
+	bc.requeueScheduledExecutions()
+	for _, tx := range transactions {
+		if tx.Validate(bc) {
+			bc.TransactionPool = append(bc.TransactionPool, tx)
+		}
+	}
+}
+
+// addContract adds a smart contract to the deployment pool after validating it
+func (bc *Blockchain) addContract(contract SmartContract) error {
+	if err := verifySignature(contract.Wallet, contract.signingMessage(), contract.Signature); err != nil {
+		return fmt.Errorf("deployment signature verification failed: %v", err)
+	}
+	if bc.isDeploymentNonceUsed(contract.Wallet, contract.Nonce) {
+		return fmt.Errorf("deployment nonce %d already used", contract.Nonce)
+	}
+	if initializer, ok := contract.Code.(Initializer); ok {
+		if err := initializer.Initialize(contract.Wallet, contract.Specification); err != nil {
+			return fmt.Errorf("invalid specification: %v", err)
+		}
+	}
+	if !contract.Validate(bc) {
+		return fmt.Errorf("contract validation failed")
+	}
+
+	// The deployment fee and prepaid gas are reserved from the deployer's balance until the deployment is mined
+	if bc.getBalance(contract.Wallet) < contract.deploymentCost() {
+		return fmt.Errorf("insufficient balance to pay the deployment fee and prepaid gas")
+	}
+
+	bc.ContractDeploymentPool = append(bc.ContractDeploymentPool, contract)
+	return nil
+}
+
+// isDeploymentNonceUsed checks if a wallet already used a nonce in a pending or mined deployment
+func (bc *Blockchain) isDeploymentNonceUsed(wallet string, nonce int64) bool {
+	for _, contract := range bc.ContractDeploymentPool {
+		if contract.Wallet == wallet && contract.Nonce == nonce {
+			return true
+		}
+	}
+	for _, block := range bc.Chain {
+		for _, contract := range block.Data.Contracts {
+			if contract.Wallet == wallet && contract.Nonce == nonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mineContractDeployment mines contract deployments from the deployment pool into the current block
+// The deployment fee is transferred from the deployer to the miner, and the gas prepaid for the
+// schedules the contract creates to the schedule escrow
+func (bc *Blockchain) mineContractDeployment(miner string) (SmartContract, error) {
+	if len(bc.ContractDeploymentPool) == 0 {
+		return SmartContract{}, fmt.Errorf("no contract deployments to mine")
+	}
+
 	lastBlock := bc.getLastBlock()
+
+	// Process the first deployment in the pool (FIFO) and remove it from the pool,
+	// releasing the fee reserved for it
+	contract := bc.ContractDeploymentPool[0]
+	bc.ContractDeploymentPool = bc.ContractDeploymentPool[1:]
+
+	if !contract.Validate(bc) {
+		return SmartContract{}, fmt.Errorf("contract %s validation failed", contract.ContractID)
+	}
+	if bc.getBalance(contract.Wallet) < contract.deploymentCost() {
+		return SmartContract{}, fmt.Errorf("deployer cannot afford the fee for contract %s", contract.ContractID)
+	}
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   contract.Wallet,
+		To:     miner,
+		Amount: contract.deploymentFee(),
+	})
+	for _, schedule := range contract.deploymentSchedules() {
+		lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+			From:   contract.Wallet,
+			To:     SCHEDULE_ESCROW_WALLET,
+			Amount: schedule.PrepaidGas,
+		})
+		lastBlock.Data.Schedules = append(lastBlock.Data.Schedules, schedule)
+	}
 	lastBlock.Data.Contracts = append(lastBlock.Data.Contracts, contract)
+
+	return contract, nil
 }
 
 // addTransaction adds a transaction to the transaction pool after validating it
-func (bc *Blockchain) addTransaction(tx Transaction) error {
+func (bc *Blockchain) addTransaction(tx Transaction) (Transaction, error) {
+	// Resolve a registered name given as recipient to its wallet
+	tx, err := bc.resolveName(tx)
+	if err != nil {
+		return tx, err
+	}
+
 	// Validate the transaction
 	if !tx.Validate(bc) {
-		return fmt.Errorf("transaction validation failed: insufficient balance or invalid transaction")
+		return tx, fmt.Errorf("transaction validation failed: insufficient balance or invalid transaction")
 	}
 
 	// If valid, add the transaction to the pool
 	bc.TransactionPool = append(bc.TransactionPool, tx)
-	return nil
+	return tx, nil
 }
 
 // mineTransaction mines transactions from the transaction pool into the current block
@@ -118,37 +367,130 @@ func (bc *Blockchain) mineTransaction() error {
 
 	lastBlock := bc.getLastBlock()
 
-	// Process the first transaction in the pool (FIFO)
+	// Process the first transaction in the pool (FIFO) and remove it from the pool
 	transaction := bc.TransactionPool[0]
+	bc.TransactionPool = bc.TransactionPool[1:]
+
+	// The balance may have been spent since the transaction was added to the pool
+	if !transaction.Validate(bc) {
+		return fmt.Errorf("transaction from %s is no longer valid", transaction.From)
+	}
 	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, transaction)
 
-	// Remove the processed transaction from the pool
-	bc.TransactionPool = bc.TransactionPool[1:]
+	return nil
+}
+
+// addContractExecution adds a contract execution to the execution pool after validating it
+func (bc *Blockchain) addContractExecution(execution ContractExecution) error {
+	if execution.Caller == "" || execution.Caller == BLOCK_REWARD_WALLET {
+		return fmt.Errorf("invalid caller wallet")
+	}
+	if execution.GasLimit < GAS_PRICE {
+		return fmt.Errorf("gas limit must be at least %v", GAS_PRICE)
+	}
+	if execution.Value < 0 {
+		return fmt.Errorf("attached value must not be negative")
+	}
+	if bc.findContractByID(execution.ContractID) == nil {
+		return fmt.Errorf("contract not found")
+	}
+	if err := bc.validateContractCall(execution.ContractID, execution.Method, execution.Args, execution.Value); err != nil {
+		return err
+	}
+	// The caller is charged the gas and the attached value, so it must authorise the execution
+	if err := verifySignature(execution.Caller, execution.signingMessage(), execution.Signature); err != nil {
+		return fmt.Errorf("execution signature verification failed: %v", err)
+	}
+	if bc.isExecutionNonceUsed(execution.Caller, execution.Nonce) {
+		return fmt.Errorf("execution nonce %d already used", execution.Nonce)
+	}
+
+	// The gas limit and attached value are reserved from the caller's balance until the execution is mined
+	if bc.getBalance(execution.Caller) < execution.GasLimit+execution.Value {
+		return fmt.Errorf("insufficient balance to pay the gas limit and attached value")
+	}
 
+	bc.ContractExecutionPool = append(bc.ContractExecutionPool, execution)
 	return nil
 }
 
+// isExecutionNonceUsed checks if a wallet already used a nonce in a pending or mined signed execution
+func (bc *Blockchain) isExecutionNonceUsed(wallet string, nonce int64) bool {
+	for _, execution := range bc.ContractExecutionPool {
+		if execution.Signature != "" && execution.Caller == wallet && execution.Nonce == nonce {
+			return true
+		}
+	}
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			if execution.Signature != "" && execution.Caller == wallet && execution.Nonce == nonce {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 // mineContractExecution mines contract executions from the execution pool into the current block
-func (bc *Blockchain) mineContractExecution(miner string) float64 {
+// The consumed gas is transferred from the caller to the miner, and on success the attached value
+// and the transfers made by the contract are included in the block's transactions
+func (bc *Blockchain) mineContractExecution(miner string) (ContractExecution, error) {
+	if len(bc.ContractExecutionPool) == 0 {
+		return ContractExecution{}, fmt.Errorf("no contract executions to mine")
+	}
+
 	lastBlock := bc.getLastBlock()
 
-	if len(bc.ContractExecutionPool) > 0 {
-		// Process the first contract execution in the pool (FIFO)
-		execpool := bc.ContractExecutionPool[0]
+	// Process the first contract execution in the pool (FIFO) and remove it from the pool,
+	// releasing the gas reserved for it
+	execpool := bc.ContractExecutionPool[0]
+	bc.ContractExecutionPool = bc.ContractExecutionPool[1:]
+
+	if execpool.ScheduleID != "" {
+		schedule, _ := bc.findSchedule(execpool.ScheduleID)
+		if schedule == nil || bc.getScheduleRemainingGas(*schedule) < execpool.GasLimit {
+			return ContractExecution{}, fmt.Errorf("schedule %s cannot afford the gas limit for contract %s", execpool.ScheduleID, execpool.ContractID)
+		}
+	} else if bc.getBalance(execpool.Caller) < execpool.GasLimit+execpool.Value {
+		return ContractExecution{}, fmt.Errorf("caller cannot afford the gas limit and value for contract %s", execpool.ContractID)
+	}
 
-		// Execute the contract
-		contract := bc.findContractByID(execpool.ContractID)
-		if contract != nil {
-			contract.Execute(bc)
-			execpool.Miner = miner
-			lastBlock.Data.ContractExecutionHistory = append(lastBlock.Data.ContractExecutionHistory, execpool)
+	// Execute the contract on copies of the contract states, which are kept and recorded only on success
+	ctx, result, err := bc.runContract(execpool)
+	if errors.Is(err, errExecutionAborted) {
+		// Another node could not reproduce the outcome of an execution the node gave up on
+		return ContractExecution{}, fmt.Errorf("execution of contract %s dropped: %w", execpool.ContractID, err)
+	}
+	if err != nil {
+		execpool.Error = err.Error()
+	} else {
+		execpool.Result = result
+		execpool.States = make(map[string]json.RawMessage, len(ctx.states))
+		for contractID, state := range ctx.states {
+			execpool.States[contractID], _ = json.Marshal(state)
+			bc.ContractStates[contractID] = state
 		}
+		execpool.Events = ctx.events
+		execpool.Transfers = ctx.transfers
+	}
+	execpool.Calls = ctx.calls
+	execpool.Version = ctx.version
+	execpool.ConsumedGas = ctx.gasUsed
+	execpool.Miner = miner
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   execpool.gasPayer(),
+		To:     miner,
+		Amount: execpool.ConsumedGas,
+	})
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, execpool.Transfers...)
+	lastBlock.Data.ContractExecutionHistory = append(lastBlock.Data.ContractExecutionHistory, execpool)
 
-		// Remove the processed contract execution from the pool
-		bc.ContractExecutionPool = bc.ContractExecutionPool[1:]
-		return execpool.ConsumedGas
+	for _, event := range execpool.Events {
+		bc.EventBroker.Publish(event)
 	}
-	return 0
+
+	return execpool, nil
 }
 
 
@@ -165,6 +507,8 @@ func (bc *Blockchain) mineBlock(miner string) (Block, error) {
 
 	currentBlock.mine(bc.Difficulty)
 	bc.appendNewEmptyBlock()
+	bc.enqueueScheduledExecutions()
+	bc.closeBeaconRound()
 
 	// Return the mined block
 	return *currentBlock, nil
@@ -197,9 +541,46 @@ func (bc *Blockchain) getBalance(address string) float64 {
 		}
 	}
 
-	for _, history := range bc.ContractExecutionPool {
-		if history.ContractID == address {
-			balance -= history.ConsumedGas
+	// Gas limits and attached values of pending contract executions are reserved from the caller,
+	// scheduled executions are paid from the gas prepaid to the schedule escrow
+	for _, execution := range bc.ContractExecutionPool {
+		if execution.ScheduleID == "" && execution.Caller == address {
+			balance -= execution.GasLimit + execution.Value
+		}
+	}
+
+	// Fees and prepaid gas of pending contract deployments are reserved from the deployer
+	for _, contract := range bc.ContractDeploymentPool {
+		if contract.Wallet == address {
+			balance -= contract.deploymentCost()
+		}
+	}
+
+	// Fees of pending contract upgrades are reserved from the contract owner
+	for _, upgrade := range bc.ContractUpgradePool {
+		if contract := bc.findContractByID(upgrade.ContractID); contract != nil && contract.Wallet == address {
+			balance -= upgrade.Fee
+		}
+	}
+
+	// Gas prepaid for pending schedules is reserved from their owner
+	for _, schedule := range bc.SchedulePool {
+		if schedule.Owner == address {
+			balance -= schedule.PrepaidGas
+		}
+	}
+
+	// Amounts of pending hash locks are reserved from their sender
+	for _, lock := range bc.HashLockPool {
+		if lock.From == address {
+			balance -= lock.Amount
+		}
+	}
+
+	// Deposits of pending beacon commitments are reserved from their participant
+	for _, commit := range bc.BeaconCommitPool {
+		if commit.Participant == address {
+			balance -= commit.Deposit
 		}
 	}
 
@@ -230,13 +611,21 @@ func generateRandomID() (string, error) {
 
 // main sets up the server and routes
 func main() {
-	app := fiber.New()
+	// Immutable keeps values such as the miner wallet valid after the request returns
+	app := fiber.New(fiber.Config{Immutable: true})
 
 	// Initialize the blockchain with a difficulty of 2, reward of 10 coins per block, and a maximum of 1000 coins
 	blockchain := CreateBlockchain(2, 10, 1000)
+	blockchain.NameRegistry = os.Getenv("NAME_REGISTRY")
+	blockchain.NameRegistryAdmin = os.Getenv("NAME_REGISTRY_ADMIN")
 
 	// Middleware to set blockchain in context
+	// The handlers share the blockchain, the node handles one request at a time. Event streams
+	// outlive their request and only use the event broker, which has its own lock
+	var lock sync.Mutex
 	app.Use(func(c *fiber.Ctx) error {
+		lock.Lock()
+		defer lock.Unlock()
 		c.Locals("blockchain", &blockchain)
 		return c.Next()
 	})
@@ -280,152 +669,872 @@ func main() {
 		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	// Mine contract executions
-	app.Get("/mine/contract", func(c *fiber.Ctx) error {
+	// Mine contract deployments
+	app.Get("/mine/deployment", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
 		miner := c.Query("wallet")
 		if miner == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("Missing miner wallet")
 		}
 
-		// Mine and process the contract executions
-		gas := blockchain.mineContractExecution(miner)
+		if len(blockchain.ContractDeploymentPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No contract deployments to mine" })
+		}
 
-		if gas != 0 {
-			response := fiber.Map{
-				"message": "Contract Executed Successfully",
-				"gas":     gas,
-			}
-			return c.Status(fiber.StatusOK).JSON(response)
-		} else {
-			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No contracts to mine" })
+		contract, err := blockchain.mineContractDeployment(miner)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
 		}
-	})
 
-	// Add new block data (transaction)
-	app.Post("/transaction/new", func(c *fiber.Ctx) error {
-		var tx Transaction
-		if err := c.BodyParser(&tx); err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		response := fiber.Map{
+			"message":    "Contract Deployed Successfully",
+			"contractID": contract.ContractID,
+			"fee":        contract.deploymentFee(),
 		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
 
+	// Mine contract upgrades
+	app.Get("/mine/upgrade", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
-		blockchain.addTransaction(tx)
+		miner := c.Query("wallet")
+		if miner == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Missing miner wallet")
+		}
 
-		response := fiber.Map{"message": "Transaction added to the pool"}
-		return c.Status(fiber.StatusCreated).JSON(response)
-	})
+		if len(blockchain.ContractUpgradePool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No contract upgrades to mine" })
+		}
 
-	// Add new smart contract
-	app.Post("/contract/new", func(c *fiber.Ctx) error {
-		var request struct {
-			Specification string `json:"specification"`
-			Wallet string `json:"wallet"`
+		upgrade, err := blockchain.mineContractUpgrade(miner)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
 		}
 
-		if err := c.BodyParser(&request); err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		response := fiber.Map{
+			"message":    "Contract Upgraded Successfully",
+			"contractID": upgrade.ContractID,
+			"version":    upgrade.Version,
+			"fee":        upgrade.Fee,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Mine schedules
+	app.Get("/mine/schedule", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.SchedulePool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No schedules to mine" })
 		}
 
-		contractID, err := generateRandomID()
+		schedule, err := blockchain.mineSchedule()
 		if err != nil {
-			return c.Status(fiber.StatusInternalServerError).SendString("Could not generate contract ID")
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
 		}
 
-		smartContract := SmartContract{
-			ContractID:    contractID,
-			Wallet:        request.Wallet,
-			Type:          "contract_example",
-			Specification: request.Specification,
-			Code:          &ContractCodeExample{},
+		response := fiber.Map{
+			"message":    "Schedule mined successfully",
+			"scheduleID": schedule.ScheduleID,
 		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
 
+	// Mine hash locks
+	app.Get("/mine/hashlock", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
-		blockchain.addContract(smartContract)
+		if len(blockchain.HashLockPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No hash locks to mine" })
+		}
+
+		lock, err := blockchain.mineHashLock()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
+		}
 
 		response := fiber.Map{
-			"message":    "Smart contract added to the current block",
-			"contractID": contractID,
-			"wallet":     smartContract.Wallet,
+			"message":  "Hash lock mined successfully",
+			"hashlock": lock.Hashlock,
 		}
-		return c.Status(fiber.StatusCreated).JSON(response)
+		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	// Execute a smart contract (add to execution pool)
-	app.Post("/contract/execute", func(c *fiber.Ctx) error {
-		// Define a struct to parse the request body
-		var request struct {
-			ContractID string `json:"contract_id"`
+	// Mine hash lock claims and refunds
+	app.Get("/mine/settlement", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.HashLockSettlementPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No hash lock settlements to mine" })
 		}
 
-		// Parse the request body
-		if err := c.BodyParser(&request); err != nil {
-			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		lock, err := blockchain.mineHashLockSettlement()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
 		}
 
-		// Validate the contract ID
-		if request.ContractID == "" {
-			return c.Status(fiber.StatusBadRequest).SendString("Missing contract ID")
+		response := fiber.Map{
+			"message":  "Hash lock settlement mined successfully",
+			"hashlock": lock.Hashlock,
 		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
 
-		fmt.Printf("Received request to execute contract ID: %s\n", request.ContractID)
-
+	// Mine oracle feeds
+	app.Get("/mine/feed", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.OracleFeedPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No oracle feeds to mine" })
+		}
 
-		// Find the contract in the blockchain
-		contract := blockchain.findContractByID(request.ContractID)
-		if contract == nil {
-			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		feed, err := blockchain.mineOracleFeed()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
 		}
 
-		// Add the contract execution request to the ContractExecutionPool
-		execution := ContractExecution{
-			ContractID:  request.ContractID,
-			ConsumedGas: GAS_PRICE, // Fixed gas fee
-			Result:      "",  // Result will be set when mined
-			Miner:       "",  // Miner will be set when mined
-			Timestamp:   time.Now(),
+		response := fiber.Map{
+			"message": "Oracle feed mined successfully",
+			"feed":    feed.Feed,
+			"version": feed.Version,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Mine oracle reports
+	app.Get("/mine/report", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.OracleReportPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No oracle reports to mine" })
 		}
 
-		blockchain.ContractExecutionPool = append(blockchain.ContractExecutionPool, execution)
+		report, err := blockchain.mineOracleReport()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
+		}
 
 		response := fiber.Map{
-			"message": "Contract execution added to the pool",
+			"message": "Oracle report mined successfully",
+			"feed":    report.Feed,
+			"key":     report.Key,
 		}
-		return c.Status(fiber.StatusCreated).JSON(response)
+		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	// Get the full blockchain
-	app.Get("/chain", func(c *fiber.Ctx) error {
+	// Mine beacon commitments
+	app.Get("/mine/commit", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.BeaconCommitPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No beacon commitments to mine" })
+		}
+
+		commit, err := blockchain.mineBeaconCommit()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
+		}
+
 		response := fiber.Map{
-			"chain":      blockchain.Chain,
-			"length":     len(blockchain.Chain),
-			"isValid":    blockchain.isValid(),
-			"minedCoins": blockchain.getMinedCoins(),
+			"message":     "Beacon commitment mined successfully",
+			"round":       commit.Round,
+			"participant": commit.Participant,
 		}
 		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	// Get data from the transaction pool
-	app.Get("/memorypool", func(c *fiber.Ctx) error {
+	// Mine beacon reveals
+	app.Get("/mine/reveal", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
+		if len(blockchain.BeaconRevealPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No beacon reveals to mine" })
+		}
+
+		reveal, err := blockchain.mineBeaconReveal()
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
+		}
+
 		response := fiber.Map{
-			"transactionpool":       blockchain.TransactionPool,
-			"contractexecutionpool": blockchain.ContractExecutionPool,
+			"message":     "Beacon reveal mined successfully, the deposit is refunded",
+			"round":       reveal.Round,
+			"participant": reveal.Participant,
 		}
 		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	// Get information of a wallet
-	app.Get("/info", func(c *fiber.Ctx) error {
+	// Mine contract executions
+	app.Get("/mine/contract", func(c *fiber.Ctx) error {
 		blockchain := c.Locals("blockchain").(*Blockchain)
-		wallet := c.Query("wallet")
+		miner := c.Query("wallet")
+		if miner == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Missing miner wallet")
+		}
+
+		if len(blockchain.ContractExecutionPool) == 0 {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{ "message": "No contracts to mine" })
+		}
+
+		// Mine and process the contract executions
+		execution, err := blockchain.mineContractExecution(miner)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{ "message": err.Error() })
+		}
+
+		message := "Contract Executed Successfully"
+		if execution.Error != "" {
+			message = "Contract Execution Failed"
+		}
 		response := fiber.Map{
-			"balance": blockchain.getBalance(wallet),
+			"message":   message,
+			"gas":       execution.ConsumedGas,
+			"execution": execution,
 		}
 		return c.Status(fiber.StatusOK).JSON(response)
 	})
 
-	app.Listen(":7000")
+	// Add new block data (transaction)
+	app.Post("/transaction/new", func(c *fiber.Ctx) error {
+		var tx Transaction
+		if err := c.BodyParser(&tx); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		tx, err := blockchain.addTransaction(tx)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{"message": "Transaction added to the pool", "to": tx.To, "registry": tx.Registry}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Designate the name registry resolving transactions to names, signed by the admin
+	app.Post("/name-registry", func(c *fiber.Ctx) error {
+		var update NameRegistryUpdate
+		if err := c.BodyParser(&update); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.setNameRegistry(update); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{"message": "Name registry updated", "contract_id": blockchain.NameRegistry}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	app.Get("/name-registry", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		return c.Status(fiber.StatusOK).JSON(fiber.Map{"contract_id": blockchain.NameRegistry})
+	})
+
+	// Add new smart contract (add to deployment pool)
+	app.Post("/contract/new", func(c *fiber.Ctx) error {
+		var request struct {
+			Specification string `json:"specification"`
+			Wallet string `json:"wallet"`
+			Type string `json:"type"`
+			Nonce int64 `json:"nonce"`
+			Signature string `json:"signature"`
+		}
+
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		if request.Type == "" {
+			request.Type = CONTRACT_EXAMPLE_TYPE
+		}
+		code, err := newCode(request.Type)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+
+		contractID, err := generateRandomID()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not generate contract ID")
+		}
+
+		smartContract := SmartContract{
+			ContractID:    contractID,
+			Wallet:        request.Wallet,
+			Type:          request.Type,
+			Specification: request.Specification,
+			Nonce:         request.Nonce,
+			Signature:     request.Signature,
+			Code:          code,
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addContract(smartContract); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message":    "Smart contract added to the deployment pool",
+			"contractID": contractID,
+			"wallet":     smartContract.Wallet,
+			"fee":        smartContract.deploymentFee(),
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Execute a smart contract (add to execution pool)
+	app.Post("/contract/execute", func(c *fiber.Ctx) error {
+		// Define a struct to parse the request body
+		var request struct {
+			ContractID string                     `json:"contract_id"`
+			Caller     string                     `json:"caller"`
+			Method     string                     `json:"method"`
+			Args       map[string]json.RawMessage `json:"args"`
+			Value      float64                    `json:"value"`
+			GasLimit   float64                    `json:"gas_limit"`
+			Nonce      int64                      `json:"nonce"`
+			Signature  string                     `json:"signature"`
+		}
+
+		// Parse the request body
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		// Validate the contract ID
+		if request.ContractID == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Missing contract ID")
+		}
+
+		fmt.Printf("Received request to execute contract ID: %s\n", request.ContractID)
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+
+		// Find the contract in the blockchain
+		contract := blockchain.findContractByID(request.ContractID)
+		if contract == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		}
+
+		if request.GasLimit == 0 {
+			request.GasLimit = DEFAULT_GAS_LIMIT
+		}
+
+		// Add the contract execution request to the ContractExecutionPool
+		execution := ContractExecution{
+			ContractID:  request.ContractID,
+			Caller:      request.Caller,
+			Method:      request.Method,
+			Args:        request.Args,
+			Value:       request.Value,
+			GasLimit:    request.GasLimit,
+			Nonce:       request.Nonce,
+			Signature:   request.Signature,
+			ConsumedGas: 0,   // Gas will be charged when mined
+			Result:      "",  // Result will be set when mined
+			Miner:       "",  // Miner will be set when mined
+			Timestamp:   time.Now(),
+		}
+
+		if err := blockchain.addContractExecution(execution); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Contract execution added to the pool",
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Publish a new code version of a contract, authorised by its owner
+	app.Post("/contract/upgrade", func(c *fiber.Ctx) error {
+		var upgrade ContractUpgrade
+		if err := c.BodyParser(&upgrade); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		upgrade, err := blockchain.addContractUpgrade(upgrade)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Contract upgrade added to the upgrade pool",
+			"version": upgrade.Version,
+			"type":    upgrade.Type,
+			"fee":     upgrade.Fee,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Get a contract as deployed, the body is the exact JSON its digest is calculated from
+	app.Get("/contract/:id", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		contract := blockchain.findContractByID(c.Params("id"))
+		if contract == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		}
+		data, err := json.Marshal(contract)
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		c.Set("X-Contract-Digest", contract.calculateDigest())
+		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+		return c.Status(fiber.StatusOK).Send(data)
+	})
+
+	// Get the code version history of a contract
+	app.Get("/contract/:id/versions", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		versions := blockchain.getContractVersions(c.Params("id"))
+		if len(versions) == 0 {
+			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		}
+		response := fiber.Map{
+			"versions": versions,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	app.Get("/contract/:id/abi", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if blockchain.findContractByID(c.Params("id")) == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		}
+		abi, declared, err := blockchain.getContractABI(c.Params("id"))
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		if !declared {
+			return c.Status(fiber.StatusNotFound).SendString("Contract does not declare an ABI")
+		}
+		return c.Status(fiber.StatusOK).JSON(abi)
+	})
+
+	// Call a contract method against a snapshot of the current state, nothing is persisted
+	app.Get("/contract/:id/call", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		execution := ContractExecution{
+			ContractID: c.Params("id"),
+			Caller:     c.Query("caller"),
+			Method:     c.Query("method"),
+			Value:      c.QueryFloat("value", 0),
+			GasLimit:   c.QueryFloat("gas_limit", VIEW_GAS_LIMIT),
+		}
+		if args := c.Query("args"); args != "" {
+			if err := json.Unmarshal([]byte(args), &execution.Args); err != nil {
+				return c.Status(fiber.StatusBadRequest).SendString("Invalid args")
+			}
+		}
+
+		if blockchain.findContractByID(execution.ContractID) == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Contract not found")
+		}
+
+		receipt, err := blockchain.callContract(execution)
+		if err != nil {
+			return c.Status(fiber.StatusUnprocessableEntity).JSON(fiber.Map{
+				"error": err.Error(),
+				"gas":   receipt.ConsumedGas,
+			})
+		}
+
+		response := fiber.Map{
+			"result":    receipt.Result,
+			"gas":       receipt.ConsumedGas,
+			"events":    receipt.Events,
+			"transfers": receipt.Transfers,
+			"calls":     receipt.Calls,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Query the mined events of a contract by topic and block range
+	app.Get("/contract/:id/events", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		filter := EventFilter{
+			ContractID: c.Params("id"),
+			Name:       c.Query("name"),
+			Topic:      c.Query("topic"),
+			FromBlock:  c.QueryInt("from", 0),
+			ToBlock:    c.QueryInt("to", -1),
+		}
+		response := fiber.Map{
+			"events": blockchain.getContractEvents(filter),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Stream mined contract events as Server-Sent Events
+	app.Get("/events/stream", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		filter := EventFilter{
+			ContractID: c.Query("contract_id"),
+			Name:       c.Query("name"),
+			Topic:      c.Query("topic"),
+			ToBlock:    -1,
+		}
+
+		c.Set("Content-Type", "text/event-stream")
+		c.Set("Cache-Control", "no-cache")
+		c.Set("Connection", "keep-alive")
+
+		// The stream is written after the request returns, without holding the node's lock
+		broker := blockchain.EventBroker
+		events := broker.Subscribe(filter)
+		c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+			defer broker.Unsubscribe(events)
+
+			// Comments keep the connection alive and detect disconnected clients
+			keepAlive := time.NewTicker(15 * time.Second)
+			defer keepAlive.Stop()
+
+			fmt.Fprint(w, ": subscribed\n\n")
+			if err := w.Flush(); err != nil {
+				return
+			}
+			for {
+				select {
+				case event := <-events:
+					data, _ := json.Marshal(event)
+					fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Name, data)
+				case <-keepAlive.C:
+					fmt.Fprint(w, ": keep-alive\n\n")
+				}
+				if err := w.Flush(); err != nil {
+					return
+				}
+			}
+		})
+		return nil
+	})
+
+	// Schedule executions of a contract method, paid from prepaid gas
+	app.Post("/schedule/new", func(c *fiber.Ctx) error {
+		var schedule ContractSchedule
+		if err := c.BodyParser(&schedule); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		scheduleID, err := generateRandomID()
+		if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString("Could not generate schedule ID")
+		}
+		schedule.ScheduleID = scheduleID
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addSchedule(schedule); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message":    "Schedule added to the schedule pool",
+			"scheduleID": scheduleID,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Lock coins for a recipient under a SHA256 hashlock until a refund height
+	app.Post("/htlc/new", func(c *fiber.Ctx) error {
+		var lock HashLock
+		if err := c.BodyParser(&lock); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, err := blockchain.lockHash(lock)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message":  "Hash lock added to the hash lock pool",
+			"hashlock": lock.Hashlock,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Claim locked coins for the recipient by revealing the preimage before the refund height
+	app.Post("/htlc/claim", func(c *fiber.Ctx) error {
+		var settlement HashLockSettlement
+		if err := c.BodyParser(&settlement); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+		settlement.Refund = false
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, err := blockchain.settleHashLock(settlement)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Hash lock claim added to the settlement pool",
+			"to":      lock.To,
+			"amount":  lock.Amount,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Refund locked coins to the sender from the refund height
+	app.Post("/htlc/refund", func(c *fiber.Ctx) error {
+		var settlement HashLockSettlement
+		if err := c.BodyParser(&settlement); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+		settlement.Refund = true
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, err := blockchain.settleHashLock(settlement)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Hash lock refund added to the settlement pool",
+			"to":      lock.From,
+			"amount":  lock.Amount,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	app.Get("/htlc/:hashlock", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		lock, _ := blockchain.findHashLock(c.Params("hashlock"))
+		if lock == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Hash lock not found")
+		}
+		response := fiber.Map{
+			"lock":   blockchain.getHashLockStatus(*lock),
+			"height": blockchain.height(),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Cancel a schedule, refunding the prepaid gas left to its owner
+	app.Post("/schedule/cancel", func(c *fiber.Ctx) error {
+		var request struct {
+			ScheduleID string `json:"schedule_id"`
+			Signature  string `json:"signature"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		cancellation, err := blockchain.cancelSchedule(request.ScheduleID, request.Signature)
+		if err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Schedule cancelled",
+			"refund":  cancellation.Refund,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get the schedules, optionally filtered by owner and contract
+	app.Get("/schedules", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		response := fiber.Map{
+			"schedules": blockchain.getSchedules(c.Query("owner"), c.Query("contract_id")),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get a schedule
+	app.Get("/schedule/:id", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		schedule, _ := blockchain.findSchedule(c.Params("id"))
+		if schedule == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Schedule not found")
+		}
+		return c.Status(fiber.StatusOK).JSON(blockchain.getScheduleStatus(*schedule))
+	})
+
+	// Register an oracle feed, or replace its reporters with a new version
+	app.Post("/oracle/feed", func(c *fiber.Ctx) error {
+		var feed OracleFeed
+		if err := c.BodyParser(&feed); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addOracleFeed(feed); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Oracle feed added to the oracle feed pool",
+			"feed":    feed.Feed,
+			"version": feed.Version,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Report a signed observation of an oracle feed
+	app.Post("/oracle/report", func(c *fiber.Ctx) error {
+		var report OracleReport
+		if err := c.BodyParser(&report); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addOracleReport(report, time.Now()); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Oracle report added to the oracle report pool",
+			"feed":    report.Feed,
+			"key":     report.Key,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Get an oracle feed and the latest observation of each of its keys
+	app.Get("/oracle/:feed", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		feed := blockchain.findOracleFeed(c.Params("feed"))
+		if feed == nil {
+			return c.Status(fiber.StatusNotFound).SendString("Oracle feed not found")
+		}
+		response := fiber.Map{
+			"feed":         feed,
+			"observations": blockchain.getOracleObservations(feed.Feed),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get the latest observation of a key of an oracle feed
+	// The key is a query parameter as keys such as wallets may contain slashes
+	app.Get("/oracle/:feed/observation", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		observation, exists := blockchain.findOracleObservation(c.Params("feed"), c.Query("key"), len(blockchain.Chain))
+		if !exists {
+			return c.Status(fiber.StatusNotFound).SendString("Oracle observation not found")
+		}
+		return c.Status(fiber.StatusOK).JSON(observation)
+	})
+
+	// Commit to a secret for the current beacon round, with a deposit refunded when it is revealed
+	app.Post("/beacon/commit", func(c *fiber.Ctx) error {
+		var commit BeaconCommit
+		if err := c.BodyParser(&commit); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addBeaconCommit(commit); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Beacon commitment added to the beacon commit pool",
+			"round":   commit.Round,
+			"deposit": commit.Deposit,
+		}
+		return c.Status(fiber.StatusCreated).JSON(response)
+	})
+
+	// Reveal the secret committed to for the current beacon round
+	app.Post("/beacon/reveal", func(c *fiber.Ctx) error {
+		var reveal BeaconReveal
+		if err := c.BodyParser(&reveal); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.addBeaconReveal(reveal); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Beacon secret added to the beacon reveal pool",
+			"round":   reveal.Round,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get the current beacon round
+	app.Get("/beacon", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		return c.Status(fiber.StatusOK).JSON(blockchain.getBeaconStatus(beaconRound(len(blockchain.Chain) - 1)))
+	})
+
+	// Get a beacon round and its random value once closed
+	app.Get("/beacon/:round", func(c *fiber.Ctx) error {
+		round, err := strconv.Atoi(c.Params("round"))
+		if err != nil || round < 0 {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid round")
+		}
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		return c.Status(fiber.StatusOK).JSON(blockchain.getBeaconStatus(round))
+	})
+
+	// Get the full blockchain
+	app.Get("/chain", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		response := fiber.Map{
+			"chain":      blockchain.Chain,
+			"length":     len(blockchain.Chain),
+			"height":     blockchain.height(),
+			"isValid":    blockchain.isValid(),
+			"minedCoins": blockchain.getMinedCoins(),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Replace the chain by a longer valid chain received from another node
+	app.Post("/chain", func(c *fiber.Ctx) error {
+		var request struct {
+			Chain []Block `json:"chain"`
+		}
+		if err := c.BodyParser(&request); err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
+		}
+
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		if err := blockchain.replaceChain(request.Chain); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
+
+		response := fiber.Map{
+			"message": "Chain replaced",
+			"length":  len(blockchain.Chain),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get data from the transaction pool
+	app.Get("/memorypool", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		response := fiber.Map{
+			"transactionpool":        blockchain.TransactionPool,
+			"contractexecutionpool":  blockchain.ContractExecutionPool,
+			"contractdeploymentpool": blockchain.ContractDeploymentPool,
+			"contractupgradepool":    blockchain.ContractUpgradePool,
+			"schedulepool":           blockchain.SchedulePool,
+			"hashlockpool":           blockchain.HashLockPool,
+			"hashlocksettlementpool": blockchain.HashLockSettlementPool,
+			"oraclefeedpool":         blockchain.OracleFeedPool,
+			"oraclereportpool":       blockchain.OracleReportPool,
+			"beaconcommitpool":       blockchain.BeaconCommitPool,
+			"beaconrevealpool":       blockchain.BeaconRevealPool,
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Get information of a wallet
+	app.Get("/info", func(c *fiber.Ctx) error {
+		blockchain := c.Locals("blockchain").(*Blockchain)
+		wallet := c.Query("wallet")
+		response := fiber.Map{
+			"balance":  blockchain.getBalance(wallet),
+			"contract": blockchain.isContract(wallet),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	port := "7000"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+	app.Listen(":" + port)
 }
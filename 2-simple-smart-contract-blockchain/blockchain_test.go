@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+const TEST_RELAY_TYPE string = "test_relay"
+
+func init() {
+	registerCode(TEST_RELAY_TYPE, func() Code { return &testRelayCode{} })
+}
+
+// testRelayCode counts its executions and relays the call to the next contracts of a chain
+type testRelayCode struct{}
+
+func (sc *testRelayCode) Execute(ctx ExecutionContext) (string, error) {
+	var count int
+	if _, err := ctx.GetStorage("count", &count); err != nil {
+		return "", err
+	}
+	if ctx.Method() == "count" {
+		return strconv.Itoa(count), nil
+	}
+
+	// next lists the contracts the call is relayed through, the optional arguments are ignored
+	// when missing: value attached to the next call, the last contract paying pay_last to its
+	// caller then failing with fail_last, and tolerate keeping a failed call from failing
+	var next []string
+	var value, payLast float64
+	var failLast, tolerate bool
+	if err := ctx.Arg("next", &next); err != nil {
+		return "", err
+	}
+	ctx.Arg("value", &value)
+	ctx.Arg("pay_last", &payLast)
+	ctx.Arg("fail_last", &failLast)
+	ctx.Arg("tolerate", &tolerate)
+	if err := ctx.SetStorage("count", count+1); err != nil {
+		return "", err
+	}
+	if err := ctx.Emit("Relayed", []string{ctx.ContractID()}, len(next)); err != nil {
+		return "", err
+	}
+
+	if len(next) == 0 {
+		if payLast > 0 {
+			if err := ctx.Transfer(ctx.Caller(), payLast); err != nil {
+				return "", err
+			}
+		}
+		if failLast {
+			return "", fmt.Errorf("last contract failed")
+		}
+		return "0", nil
+	}
+	args := map[string]any{"next": next[1:], "pay_last": payLast, "fail_last": failLast}
+	result, err := ctx.Call(next[0], "relay", args, value)
+	if err != nil {
+		if tolerate {
+			return "tolerated", nil
+		}
+		return "", err
+	}
+	hops, _ := strconv.Atoi(result)
+	return strconv.Itoa(hops + 1), nil
+}
+
+func (sc *testRelayCode) Validate(chain ChainReader) bool {
+	return true
+}
+
+// deployRelays deploys n relay contracts
+func deployRelays(t *testing.T, bc *Blockchain, w *testWallet, n int) []string {
+	t.Helper()
+	relays := make([]string, n)
+	for i := range relays {
+		relays[i] = deployContract(t, bc, w, TEST_RELAY_TYPE, "")
+	}
+	return relays
+}
+
+func TestExecutionRequiresCallerSignature(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+
+	unsigned := signedExecution(t, alice, contractID, "increment", nil, 0)
+	unsigned.Signature = ""
+	if err := bc.addContractExecution(unsigned); err == nil {
+		t.Fatal("unsigned execution charging the caller was accepted")
+	}
+
+	// Mallory cannot make Alice pay for an execution
+	forged := signedExecution(t, mallory, contractID, "increment", nil, 0)
+	forged.Caller = alice.ID
+	if err := bc.addContractExecution(forged); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("execution signed by another wallet was accepted: %v", err)
+	}
+
+	// Changing a signed field invalidates the signature
+	tampered := signedExecution(t, alice, contractID, "increment", nil, 0)
+	tampered.GasLimit = 20
+	if err := bc.addContractExecution(tampered); err == nil {
+		t.Fatal("execution with a tampered gas limit was accepted")
+	}
+
+	if err := bc.addContractExecution(signedExecution(t, alice, contractID, "increment", nil, 0)); err != nil {
+		t.Fatalf("signed execution was rejected: %v", err)
+	}
+}
+
+func TestExecutionNonceCannotBeReplayed(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+
+	execution := signedExecution(t, alice, contractID, "increment", nil, 0)
+	if err := bc.addContractExecution(execution); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.addContractExecution(execution); err == nil {
+		t.Fatal("pending execution was replayed")
+	}
+
+	if _, err := bc.mineContractExecution(alice.ID); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, alice.ID, 1)
+	if err := bc.addContractExecution(execution); err == nil {
+		t.Fatal("mined execution was replayed")
+	}
+
+	// Another execution signed with the same nonce is rejected as well
+	reused := signedExecution(t, alice, contractID, "increment", nil, 0)
+	reused.Nonce = execution.Nonce
+	reused.Signature = alice.sign(t, reused.signingMessage())
+	if err := bc.addContractExecution(reused); err == nil {
+		t.Fatal("execution reusing a nonce was accepted")
+	}
+}
+
+func TestExecutionChargesConsumedGasToCaller(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	before := bc.getBalance(alice.ID)
+
+	if err := bc.addContractExecution(signedExecution(t, alice, contractID, "increment", nil, 0)); err != nil {
+		t.Fatal(err)
+	}
+	if reserved := before - bc.getBalance(alice.ID); reserved != 10 {
+		t.Fatalf("reserved %v, expected the gas limit 10", reserved)
+	}
+	execution, err := bc.mineContractExecution(miner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustSucceed(t, execution)
+	if paid := bc.getBalance(miner.ID); paid != execution.ConsumedGas || paid <= 0 {
+		t.Fatalf("miner received %v, expected the consumed gas %v", paid, execution.ConsumedGas)
+	}
+	if after := bc.getBalance(alice.ID); after != before-execution.ConsumedGas {
+		t.Fatalf("caller balance %v, expected %v", after, before-execution.ConsumedGas)
+	}
+}
+
+func TestExecutionThatCannotStartIsChargedAndRecorded(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	before := bc.getBalance(alice.ID)
+
+	// The call stands for one that matched the contract when it was added to the pool
+	bc.ContractExecutionPool = append(bc.ContractExecutionPool, signedExecution(t, alice, contractID, "unknown", nil, 0))
+	execution, err := bc.mineContractExecution(miner.ID)
+	if err != nil {
+		t.Fatalf("execution that cannot start was dropped: %v", err)
+	}
+	mustFail(t, execution)
+	if execution.ConsumedGas != GAS_PRICE || bc.getBalance(miner.ID) != GAS_PRICE || bc.getBalance(alice.ID) != before-GAS_PRICE {
+		t.Fatalf("execution consumed %v, expected the fixed gas fee %v paid by the caller", execution.ConsumedGas, GAS_PRICE)
+	}
+	history := bc.getLastBlock().Data.ContractExecutionHistory
+	if len(history) != 1 || history[0].Error != execution.Error {
+		t.Fatal("failed execution is missing from the block")
+	}
+
+	mineBlocks(t, bc, miner.ID, 1)
+	if err := bc.validateChain(bc.Chain); err != nil {
+		t.Fatalf("chain with the failed execution does not replay: %v", err)
+	}
+}
+
+func TestCallsAreLimitedInDepth(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	relays := deployRelays(t, bc, alice, MAX_CALL_DEPTH+2)
+
+	deepest := executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": relays[1 : MAX_CALL_DEPTH+1]}, 0)
+	if hops := mustSucceed(t, deepest); hops != strconv.Itoa(MAX_CALL_DEPTH) {
+		t.Fatalf("relayed through %s calls", hops)
+	}
+	tooDeep := executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": relays[1:]}, 0)
+	if mustFail(t, tooDeep); !strings.Contains(tooDeep.Error, fmt.Sprintf("maximum call depth of %d", MAX_CALL_DEPTH)) {
+		t.Fatalf("call beyond the maximum depth failed with %s", tooDeep.Error)
+	}
+	if count := callView(t, bc, relays[0], "count", nil); count != "1" {
+		t.Fatalf("failed execution was kept, the first relay counts %s", count)
+	}
+}
+
+func TestCallsCannotReenterAContract(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	relays := deployRelays(t, bc, alice, 2)
+
+	reentrant := executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": []string{relays[1], relays[0]}}, 0)
+	if mustFail(t, reentrant); !strings.Contains(reentrant.Error, "reentrant call to contract "+relays[0]) {
+		t.Fatalf("reentrant call failed with %s", reentrant.Error)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": []string{relays[1]}}, 0))
+	mustSucceed(t, executeContract(t, bc, alice, relays[1], "relay", map[string]any{"next": []string{relays[0]}}, 0))
+}
+
+func TestFailedCallsAreRevertedButCharged(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	relays := deployRelays(t, bc, alice, 2)
+	mustSucceed(t, executeContract(t, bc, alice, relays[1], "relay", map[string]any{"next": []string{}}, 5))
+
+	// The second relay counts, emits and pays before failing, the first one tolerates the failure
+	args := map[string]any{"next": []string{relays[1]}, "pay_last": 1, "fail_last": true, "tolerate": true}
+	execution := executeContract(t, bc, alice, relays[0], "relay", args, 0)
+	if result := mustSucceed(t, execution); result != "tolerated" {
+		t.Fatalf("relay returned %s", result)
+	}
+	if len(execution.Calls) != 1 || execution.Calls[0].Error == "" || execution.Calls[0].GasUsed <= 0 {
+		t.Fatalf("failed call recorded as %+v", execution.Calls)
+	}
+	if execution.ConsumedGas <= execution.Calls[0].GasUsed {
+		t.Fatalf("execution consumed %v, not charging the %v of the failed call on top of its own", execution.ConsumedGas, execution.Calls[0].GasUsed)
+	}
+	if _, changed := execution.States[relays[1]]; changed || len(execution.Transfers) != 0 || len(execution.Events) != 1 {
+		t.Fatalf("failed call kept its effects: %d transfers, %d events", len(execution.Transfers), len(execution.Events))
+	}
+	if count := callView(t, bc, relays[1], "count", nil); count != "1" {
+		t.Fatalf("second relay counts %s after its failed call", count)
+	}
+	if balance := bc.getBalance(relays[1]); balance != 5 {
+		t.Fatalf("second relay holds %v after its failed call", balance)
+	}
+
+	// Without tolerating it, the failure of the call fails the whole execution
+	delete(args, "tolerate")
+	mustFail(t, executeContract(t, bc, alice, relays[0], "relay", args, 0))
+	if count := callView(t, bc, relays[0], "count", nil); count != "1" {
+		t.Fatalf("first relay counts %s after its failed execution", count)
+	}
+}
+
+func TestCallsTransferTheAttachedValue(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	relays := deployRelays(t, bc, alice, 2)
+
+	execution := executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": []string{relays[1]}, "value": 3}, 5)
+	mustSucceed(t, execution)
+	if len(execution.Calls) != 1 || execution.Calls[0].Value != 3 {
+		t.Fatalf("call recorded as %+v", execution.Calls)
+	}
+	if first, second := bc.getBalance(relays[0]), bc.getBalance(relays[1]); first != 2 || second != 3 {
+		t.Fatalf("relays hold %v and %v, expected 2 and 3", first, second)
+	}
+
+	// A contract cannot attach more than it holds
+	mustFail(t, executeContract(t, bc, alice, relays[0], "relay", map[string]any{"next": []string{relays[1]}, "value": 10}, 0))
+	if first, second := bc.getBalance(relays[0]), bc.getBalance(relays[1]); first != 2 || second != 3 {
+		t.Fatalf("relays hold %v and %v after the rejected call", first, second)
+	}
+}
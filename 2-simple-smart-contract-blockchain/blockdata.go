@@ -2,16 +2,28 @@ package main
 
 // BlockData contains all types of data that can be part of a block
 type BlockData struct {
-	ContractExecutionHistory []ContractExecution `json:"contract_execution_history"`
-	Contracts                []SmartContract     `json:"contracts"`
-	Transactions             []Transaction       `json:"transactions"`
+	ContractExecutionHistory []ContractExecution    `json:"contract_execution_history"`
+	Contracts                []SmartContract        `json:"contracts"`
+	ContractUpgrades         []ContractUpgrade      `json:"contract_upgrades"`
+	Transactions             []Transaction          `json:"transactions"`
+	Schedules                []ContractSchedule     `json:"schedules"`
+	ScheduleCancellations    []ScheduleCancellation `json:"schedule_cancellations"`
+	HashLocks                []HashLock             `json:"hash_locks"`
+	HashLockSettlements      []HashLockSettlement   `json:"hash_lock_settlements"`
+	OracleFeeds              []OracleFeed           `json:"oracle_feeds"`
+	OracleReports            []OracleReport         `json:"oracle_reports"`
+	BeaconCommits            []BeaconCommit         `json:"beacon_commits"`
+	BeaconReveals            []BeaconReveal         `json:"beacon_reveals"`
+	BeaconRounds             []BeaconRound          `json:"beacon_rounds"`
 }
 
 // Transaction represents a blockchain transaction
 type Transaction struct {
-	From   string  `json:"from"`
-	To     string  `json:"to"`
-	Amount float64 `json:"amount"`
+	From     string  `json:"from"`
+	To       string  `json:"to"`
+	Amount   float64 `json:"amount"`
+	Name     string  `json:"name,omitempty"`     // Registered name the recipient was resolved from
+	Registry string  `json:"registry,omitempty"` // Name registry the name was resolved through
 }
 
 // Validate checks if the transaction is valid
@@ -20,8 +32,17 @@ func (t Transaction) Validate(blockchain *Blockchain) bool {
 		return false
 	} else if  t.From == BLOCK_REWARD_WALLET || t.To == BLOCK_REWARD_WALLET {
 		return false
+	} else if t.From == SCHEDULE_ESCROW_WALLET || t.To == SCHEDULE_ESCROW_WALLET {
+		return false
+	} else if t.From == HTLC_ESCROW_WALLET || t.To == HTLC_ESCROW_WALLET {
+		return false
+	} else if t.From == BEACON_ESCROW_WALLET || t.To == BEACON_ESCROW_WALLET {
+		return false
 	} else if t.Amount <= 0 {
 		return false
+	} else if blockchain.isContract(t.From) {
+		// Contract balances can only be spent by the contract code
+		return false
 	} else {
 		balance := blockchain.getBalance(t.From)
 		return balance >= t.Amount
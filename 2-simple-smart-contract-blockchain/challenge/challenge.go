@@ -1,44 +1,215 @@
 package main
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"blockchain/signing"
+
 	"github.com/gofiber/fiber/v2"
 )
 
-var dataStore = make(map[string]string)
+const DEFAULT_NODE_URL string = "http://localhost:7000"
+const ACME_MAX_AGE time.Duration = 5 * time.Minute           // Submissions signed longer ago are rejected
+const ACME_MAX_CLOCK_DRIFT time.Duration = 30 * time.Second // Submissions signed further in the future are rejected
+
+// Submission is the digest of a contract submitted by the wallet that deployed it
+// Passed records whether the digest and the ownership of the contract were verified
+type Submission struct {
+	Wallet     string    `json:"wallet"`
+	ContractID string    `json:"contract_id"`
+	Digest     string    `json:"digest"`
+	SignedAt   int64     `json:"signed_at"` // Unix time of the signature
+	Signature  string    `json:"signature"` // Signed by the wallet over acme|contract_id|digest|signed_at
+	Passed     bool      `json:"passed"`
+	Reason     string    `json:"reason"` // Why the verification failed
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// deployedContract holds the fields of a contract needed to verify a submission
+type deployedContract struct {
+	ContractID string `json:"contract_id"`
+	Wallet     string `json:"wallet"`
+}
+
+// ErrInvalidSignature is returned when a submission is not signed by its wallet
+var ErrInvalidSignature = errors.New("submission is not signed by the wallet")
+
+var nodeURL = DEFAULT_NODE_URL
+
+var httpClient = &http.Client{Timeout: 5 * time.Second}
+
+// fetchContract gets a contract from the node and its digest, the SHA256 digest of the contract
+// JSON as calculated by the node. found is false when the node has no such contract
+func fetchContract(contractID string) (contract deployedContract, digest string, found bool, err error) {
+	response, err := httpClient.Get(nodeURL + "/contract/" + url.PathEscape(contractID))
+	if err != nil {
+		return contract, "", false, fmt.Errorf("node unreachable: %v", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return contract, "", false, nil
+	}
+	if response.StatusCode != http.StatusOK {
+		return contract, "", false, fmt.Errorf("node answered with status %d", response.StatusCode)
+	}
+	data, err := io.ReadAll(response.Body)
+	if err != nil {
+		return contract, "", false, fmt.Errorf("reading the contract failed: %v", err)
+	}
+	if err := json.Unmarshal(data, &contract); err != nil {
+		return contract, "", false, fmt.Errorf("node returned an invalid contract: %v", err)
+	}
+	hash := sha256.Sum256(data)
+	return contract, hex.EncodeToString(hash[:]), true, nil
+}
+
+// checkSignature checks the submission is signed by its wallet, before anything is done on its behalf
+func (s *Submission) checkSignature() error {
+	message := signing.Message("acme", s.ContractID, s.Digest, strconv.FormatInt(s.SignedAt, 10))
+	if err := signing.Verify(s.Wallet, message, s.Signature); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSignature, err)
+	}
+	return nil
+}
+
+// checkFreshness rejects a submission signed too long ago, in the future or not after the last
+// submission of its wallet, so a signed submission cannot be replayed
+func (s *Submission) checkFreshness(store *SubmissionStore, now time.Time) error {
+	signed := time.Unix(s.SignedAt, 0)
+	if signed.After(now.Add(ACME_MAX_CLOCK_DRIFT)) {
+		return fmt.Errorf("submission is signed in the future")
+	}
+	if now.Sub(signed) > ACME_MAX_AGE {
+		return fmt.Errorf("submission was signed more than %v ago", ACME_MAX_AGE)
+	}
+	if latest, exists := store.Latest(s.Wallet); exists && s.SignedAt <= latest.SignedAt {
+		return ErrStaleSubmission
+	}
+	return nil
+}
+
+// verify checks the submission, whose signature was checked, against the contract deployed on the node
+func (s *Submission) verify() error {
+	contract, digest, found, err := fetchContract(s.ContractID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case !found:
+		s.Reason = "contract not found"
+	case contract.Wallet != s.Wallet:
+		s.Reason = "contract was not deployed by the wallet"
+	case digest != s.Digest:
+		s.Reason = "digest does not match the contract"
+	default:
+		s.Passed = true
+	}
+	return nil
+}
 
 func main() {
-	app := fiber.New()
+	if node := os.Getenv("NODE_URL"); node != "" {
+		nodeURL = node
+	}
+	storePath := DEFAULT_STORE_PATH
+	if path := os.Getenv("STORE_PATH"); path != "" {
+		storePath = path
+	}
+	dataStore, err := OpenSubmissionStore(storePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer dataStore.Close()
+
+	app := fiber.New(fiber.Config{Immutable: true})
 
 	// Endpoint to submit data
 
 	app.Post("/acme", func(c *fiber.Ctx) error {
-		var data struct {
-			Wallet string `json:"wallet"`
-			Digest string `json:"digest"`
-		}
+		var data Submission
 		if err := c.BodyParser(&data); err != nil {
 			return c.Status(fiber.StatusBadRequest).SendString("Invalid input")
 		}
+		data.Passed = false
+		data.Reason = ""
+		data.Timestamp = time.Now().UTC()
+		// Unsigned submissions are neither checked against the node nor recorded, they would
+		// otherwise block the wallet through checkFreshness
+		if err := data.checkSignature(); err != nil {
+			return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		}
+		if err := data.checkFreshness(dataStore, data.Timestamp); err != nil {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		}
 
-		dataStore[data.Wallet] = data.Digest
-		return c.Status(fiber.StatusOK).SendString("Data received")
+		// Failures of the node are not held against the submitter
+		if err := data.verify(); err != nil {
+			return c.Status(fiber.StatusBadGateway).SendString(err.Error())
+		}
+
+		if err := dataStore.Record(data); errors.Is(err, ErrInvalidSignature) {
+			return c.Status(fiber.StatusUnauthorized).SendString(err.Error())
+		} else if errors.Is(err, ErrStaleSubmission) {
+			return c.Status(fiber.StatusForbidden).SendString(err.Error())
+		} else if err != nil {
+			return c.Status(fiber.StatusInternalServerError).SendString(err.Error())
+		}
+		return c.Status(fiber.StatusOK).JSON(data)
 	})
 
 
-	// Endpoint to get data
+	// Endpoint to get the digest of the last passed submission of a wallet, as plain text
 	app.Get("/acme", func(c *fiber.Ctx) error {
 		wallet := c.Query("wallet")
 		if wallet == "" {
 			return c.Status(fiber.StatusBadRequest).SendString("Missing wallet ID")
 		}
 
-		value, exists := dataStore[wallet]
+		value, exists := dataStore.LatestPassed(wallet)
 		if !exists {
 			return c.Status(fiber.StatusNotFound).SendString("No data found")
 		}
 
-		return c.Status(fiber.StatusOK).SendString(value)
+		return c.Status(fiber.StatusOK).SendString(value.Digest)
+	})
+
+	// Endpoint to list the submissions of a wallet, oldest first
+	app.Get("/acme/history", func(c *fiber.Ctx) error {
+		wallet := c.Query("wallet")
+		if wallet == "" {
+			return c.Status(fiber.StatusBadRequest).SendString("Missing wallet ID")
+		}
+
+		response := fiber.Map{
+			"wallet":      wallet,
+			"submissions": dataStore.History(wallet),
+		}
+		return c.Status(fiber.StatusOK).JSON(response)
+	})
+
+	// Endpoint to export the submissions as JSON lines, optionally of a single wallet
+	app.Get("/acme/export", func(c *fiber.Ctx) error {
+		c.Set(fiber.HeaderContentType, "application/x-ndjson")
+		c.Set(fiber.HeaderContentDisposition, `attachment; filename="submissions.jsonl"`)
+		return dataStore.Export(c.Status(fiber.StatusOK), c.Query("wallet"))
 	})
 
-	app.Listen(":3000")
+	port := "3000"
+	if p := os.Getenv("PORT"); p != "" {
+		port = p
+	}
+	app.Listen(":" + port)
 }
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+const DEFAULT_STORE_PATH string = "submissions.jsonl"
+
+// ErrStaleSubmission is returned when a submission is not signed after the last one of its wallet
+var ErrStaleSubmission = errors.New("submission is not signed after the last submission of the wallet")
+
+// SubmissionStore keeps the history of the submissions of each wallet, persisted as JSON lines
+// appended to a file so it survives restarts. It is safe for concurrent use
+type SubmissionStore struct {
+	mu          sync.RWMutex
+	file        *os.File
+	submissions []Submission            // All submissions in the order they were recorded
+	history     map[string][]Submission // Submissions of each wallet, oldest first
+}
+
+// OpenSubmissionStore loads the submissions recorded in the file at path, creating it if needed
+// Lines holding only whitespace are skipped and a final line torn by a crash is dropped, any
+// other line that is not a submission is an error
+func OpenSubmissionStore(path string) (*SubmissionStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("opening the store failed: %v", err)
+	}
+
+	store := &SubmissionStore{
+		file:        file,
+		submissions: []Submission{},
+		history:     make(map[string][]Submission),
+	}
+	reader := bufio.NewReader(file)
+	var offset int64
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			file.Close()
+			return nil, fmt.Errorf("reading the store failed: %v", err)
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			var submission Submission
+			if jsonErr := json.Unmarshal(data, &submission); jsonErr != nil {
+				if err == nil {
+					file.Close()
+					return nil, fmt.Errorf("line %d of the store is not a submission: %v", line, jsonErr)
+				}
+				// A final line without a newline was torn by a crash while it was written
+				if err := store.truncate(offset); err != nil {
+					file.Close()
+					return nil, err
+				}
+				break
+			}
+			store.add(submission)
+			if err != nil {
+				// The last submission was written without its newline, the next one starts a new line
+				if _, err := file.Write([]byte{'\n'}); err != nil {
+					file.Close()
+					return nil, fmt.Errorf("repairing the store failed: %v", err)
+				}
+			}
+		}
+		offset += int64(len(data))
+		if err != nil {
+			break
+		}
+	}
+	return store, nil
+}
+
+// truncate drops the content of the file from offset
+func (s *SubmissionStore) truncate(offset int64) error {
+	if err := s.file.Truncate(offset); err != nil {
+		return fmt.Errorf("truncating the torn end of the store failed: %v", err)
+	}
+	return s.file.Sync()
+}
+
+// add indexes a submission in memory
+func (s *SubmissionStore) add(submission Submission) {
+	s.submissions = append(s.submissions, submission)
+	s.history[submission.Wallet] = append(s.history[submission.Wallet], submission)
+}
+
+// Record appends a submission to the file and then to the history of its wallet, it returns
+// ErrInvalidSignature when the submission is not signed by its wallet and ErrStaleSubmission
+// when it is not signed after the last one of the wallet
+func (s *SubmissionStore) Record(submission Submission) error {
+	if err := submission.checkSignature(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(submission)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Checked again under the lock, concurrent submissions may have passed checkFreshness together
+	if history := s.history[submission.Wallet]; len(history) > 0 && submission.SignedAt <= history[len(history)-1].SignedAt {
+		return ErrStaleSubmission
+	}
+	if _, err := s.file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("writing the store failed: %v", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("syncing the store failed: %v", err)
+	}
+	s.add(submission)
+	return nil
+}
+
+// Latest returns the last submission of a wallet
+func (s *SubmissionStore) Latest(wallet string) (Submission, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.history[wallet]
+	if len(history) == 0 {
+		return Submission{}, false
+	}
+	return history[len(history)-1], true
+}
+
+// LatestPassed returns the last submission of a wallet whose verification passed
+func (s *SubmissionStore) LatestPassed(wallet string) (Submission, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	history := s.history[wallet]
+	for i := len(history) - 1; i >= 0; i-- {
+		if history[i].Passed {
+			return history[i], true
+		}
+	}
+	return Submission{}, false
+}
+
+// History returns the submissions of a wallet, oldest first
+func (s *SubmissionStore) History(wallet string) []Submission {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]Submission{}, s.history[wallet]...)
+}
+
+// Export writes the submissions as JSON lines in the order they were recorded, only those of
+// wallet when it is not empty
+func (s *SubmissionStore) Export(w io.Writer, wallet string) error {
+	s.mu.RLock()
+	submissions := s.submissions
+	if wallet != "" {
+		submissions = s.history[wallet]
+	}
+	submissions = append([]Submission{}, submissions...)
+	s.mu.RUnlock()
+
+	encoder := json.NewEncoder(w)
+	for _, submission := range submissions {
+		if err := encoder.Encode(submission); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the file of the store
+func (s *SubmissionStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file.Close()
+}
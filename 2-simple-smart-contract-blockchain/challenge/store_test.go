@@ -0,0 +1,268 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"blockchain/signing"
+)
+
+// testKeys holds the key of each wallet the tests name
+var testKeys = map[string]*rsa.PrivateKey{}
+
+// walletID returns the ID of the wallet named name, generating its key on first use
+func walletID(t *testing.T, name string) string {
+	t.Helper()
+	key, exists := testKeys[name]
+	if !exists {
+		var err error
+		if key, err = rsa.GenerateKey(rand.Reader, 1024); err != nil {
+			t.Fatal(err)
+		}
+		testKeys[name] = key
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// signed returns a submission of the wallet named name signed by it
+func signed(t *testing.T, name string, submission Submission) Submission {
+	t.Helper()
+	submission.Wallet = walletID(t, name)
+	message := signing.Message("acme", submission.ContractID, submission.Digest, strconv.FormatInt(submission.SignedAt, 10))
+	hash := sha256.Sum256([]byte(message))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, testKeys[name], crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	submission.Signature = base64.StdEncoding.EncodeToString(sig)
+	return submission
+}
+
+// openStore opens the store at path, failing the test on error
+func openStore(t *testing.T, path string) *SubmissionStore {
+	t.Helper()
+	store, err := OpenSubmissionStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// record records a passed submission of the wallet named name with a digest, signed after its last submission
+func record(t *testing.T, store *SubmissionStore, name string, digest string) {
+	t.Helper()
+	signedAt := int64(len(store.History(walletID(t, name))) + 1)
+	submission := signed(t, name, Submission{ContractID: "0x1", Digest: digest, SignedAt: signedAt, Passed: true, Timestamp: time.Now().UTC()})
+	if err := store.Record(submission); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// digests returns the digests of the history of the wallet named name
+func digests(t *testing.T, store *SubmissionStore, name string) string {
+	t.Helper()
+	var digests []string
+	for _, submission := range store.History(walletID(t, name)) {
+		digests = append(digests, submission.Digest)
+	}
+	return strings.Join(digests, ",")
+}
+
+func TestStoreReloadsTheHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.jsonl")
+	store := openStore(t, path)
+	record(t, store, "alice", "a1")
+	record(t, store, "bob", "b1")
+	record(t, store, "alice", "a2")
+	store.Close()
+
+	reloaded := openStore(t, path)
+	if history := digests(t, reloaded, "alice"); history != "a1,a2" {
+		t.Fatalf("alice's history reloaded as %s", history)
+	}
+	if latest, exists := reloaded.Latest(walletID(t, "bob")); !exists || latest.Digest != "b1" {
+		t.Fatalf("bob's latest submission reloaded as %+v", latest)
+	}
+	var export strings.Builder
+	if err := reloaded.Export(&export, ""); err != nil {
+		t.Fatal(err)
+	}
+	if lines := strings.Count(export.String(), "\n"); lines != 3 {
+		t.Fatalf("exported %d submissions", lines)
+	}
+}
+
+func TestStoreDropsATornFinalLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.jsonl")
+	store := openStore(t, path)
+	record(t, store, "alice", "a1")
+	store.Close()
+
+	// A crash while writing leaves a partial line without a newline
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString(`{"wallet":"alice","dig`)
+	file.Close()
+
+	reloaded := openStore(t, path)
+	if history := digests(t, reloaded, "alice"); history != "a1" {
+		t.Fatalf("alice's history reloaded as %s", history)
+	}
+	record(t, reloaded, "alice", "a2")
+	reloaded.Close()
+	if history := digests(t, openStore(t, path), "alice"); history != "a1,a2" {
+		t.Fatalf("alice's history reloaded as %s after the torn line", history)
+	}
+}
+
+func TestStoreCompletesAFinalLineWithoutNewline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.jsonl")
+	store := openStore(t, path)
+	record(t, store, "alice", "a1")
+	store.Close()
+
+	// The submission was written but its newline was not
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(strings.TrimSuffix(string(data), "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded := openStore(t, path)
+	record(t, reloaded, "alice", "a2")
+	reloaded.Close()
+	if history := digests(t, openStore(t, path), "alice"); history != "a1,a2" {
+		t.Fatalf("alice's history reloaded as %s", history)
+	}
+}
+
+func TestStoreFailsOnACorruptedLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.jsonl")
+	store := openStore(t, path)
+	record(t, store, "alice", "a1")
+	store.Close()
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("not a submission\n")
+	file.WriteString(`{"wallet":"alice","digest":"a2"}` + "\n")
+	file.Close()
+
+	if _, err := OpenSubmissionStore(path); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("opening a store corrupted before its end returned %v", err)
+	}
+}
+
+func TestStoreRejectsStaleSubmissions(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "submissions.jsonl"))
+
+	// Submissions signed at the same time race for the store, only one is recorded
+	var wg sync.WaitGroup
+	errs := make(chan error, 10)
+	for i := 0; i < 10; i++ {
+		submission := signed(t, "alice", Submission{Digest: fmt.Sprintf("a%d", i), SignedAt: 100, Passed: true})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- store.Record(submission)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	recorded := 0
+	for err := range errs {
+		if err == nil {
+			recorded++
+		} else if !errors.Is(err, ErrStaleSubmission) {
+			t.Fatal(err)
+		}
+	}
+	if recorded != 1 || len(store.History(walletID(t, "alice"))) != 1 {
+		t.Fatalf("%d submissions signed at the same time were recorded", recorded)
+	}
+
+	if err := store.Record(signed(t, "alice", Submission{SignedAt: 99})); !errors.Is(err, ErrStaleSubmission) {
+		t.Fatalf("submission signed before the last one returned %v", err)
+	}
+	if err := store.Record(signed(t, "bob", Submission{SignedAt: 99})); err != nil {
+		t.Fatalf("submission of another wallet returned %v", err)
+	}
+}
+
+func TestStoreReturnsTheLatestPassedSubmission(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "submissions.jsonl"))
+	if _, exists := store.LatestPassed(walletID(t, "alice")); exists {
+		t.Fatal("wallet without submissions has a passed one")
+	}
+	record(t, store, "alice", "a1")
+	if err := store.Record(signed(t, "alice", Submission{Digest: "a2", SignedAt: 2, Reason: "digest does not match the contract"})); err != nil {
+		t.Fatal(err)
+	}
+
+	if latest, _ := store.Latest(walletID(t, "alice")); latest.Digest != "a2" {
+		t.Fatalf("latest submission is %+v", latest)
+	}
+	if passed, exists := store.LatestPassed(walletID(t, "alice")); !exists || passed.Digest != "a1" {
+		t.Fatalf("latest passed submission is %+v", passed)
+	}
+}
+
+func TestStoreRecordsOnlySignedSubmissions(t *testing.T) {
+	store := openStore(t, filepath.Join(t.TempDir(), "submissions.jsonl"))
+	forged := signed(t, "mallory", Submission{ContractID: "0x1", Digest: "a1", SignedAt: 100})
+	forged.Wallet = walletID(t, "alice")
+	if err := store.Record(forged); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("submission signed by another wallet returned %v", err)
+	}
+	if _, exists := store.Latest(walletID(t, "alice")); exists {
+		t.Fatal("submission signed by another wallet was recorded")
+	}
+	record(t, store, "alice", "a1")
+}
+
+func TestStoreSkipsBlankLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "submissions.jsonl")
+	store := openStore(t, path)
+	record(t, store, "alice", "a1")
+	store.Close()
+
+	// Lines holding only whitespace, such as those left by editing the file, are not submissions
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.WriteString("\n  \t\n\r\n")
+	file.Close()
+
+	reloaded := openStore(t, path)
+	record(t, reloaded, "alice", "a2")
+	reloaded.Close()
+	if history := digests(t, openStore(t, path), "alice"); history != "a1,a2" {
+		t.Fatalf("alice's history reloaded as %s", history)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+const CONTRACT_ESCROW_TYPE string = "escrow"
+
+func init() {
+	registerCode(CONTRACT_ESCROW_TYPE, func() Code { return &ContractCodeEscrow{} })
+}
+
+// EscrowSpecification is the specification of an escrow, given as JSON at deployment
+// The deposit is refunded to the buyer from the Timeout block height if neither party acted
+type EscrowSpecification struct {
+	Buyer   string  `json:"buyer"`
+	Seller  string  `json:"seller"`
+	Arbiter string  `json:"arbiter"`
+	Amount  float64 `json:"amount"`
+	Timeout int     `json:"timeout"`
+}
+
+// ContractCodeEscrow implements a payment held until the buyer releases it to the seller, the
+// arbiter resolves a dispute or the timeout refunds the buyer
+type ContractCodeEscrow struct {
+	EscrowSpecification
+	Status string `json:"status"` // created, funded, disputed, released or refunded
+}
+
+// Initialize configures the parties, amount and timeout of the escrow
+func (sc *ContractCodeEscrow) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.EscrowSpecification); err != nil {
+		return fmt.Errorf("escrow specification must be JSON: %v", err)
+	}
+	sc.Status = "created"
+	return nil
+}
+
+func (sc *ContractCodeEscrow) Validate(chain ChainReader) bool {
+	parties := map[string]bool{sc.Buyer: true, sc.Seller: true, sc.Arbiter: true}
+	return len(parties) == 3 && !parties[""] && sc.Amount > 0 && sc.Timeout > chain.BlockHeight()
+}
+
+// DeploymentSchedules refunds a funded escrow to the buyer at the timeout without anyone asking
+func (sc *ContractCodeEscrow) DeploymentSchedules() []ContractSchedule {
+	return []ContractSchedule{{Method: "refund", StartHeight: sc.Timeout, GasLimit: DEFAULT_GAS_LIMIT, PrepaidGas: DEFAULT_GAS_LIMIT}}
+}
+
+func (sc *ContractCodeEscrow) ABI() ContractABI {
+	amount := ParamABI{Name: "amount", Type: "number"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "status", Params: []ParamABI{}, Returns: "object", ReadOnly: true},
+			{Name: "deposit", Params: []ParamABI{}, Returns: "string", Payable: true},
+			{Name: "release", Params: []ParamABI{}, Returns: "string"},
+			{Name: "dispute", Params: []ParamABI{}, Returns: "string"},
+			{Name: "resolve", Params: []ParamABI{{Name: "to", Type: "address"}}, Returns: "string"},
+			{Name: "refund", Params: []ParamABI{}, Returns: "string"},
+		},
+		Events: []EventABI{
+			{Name: "Deposited", Topics: []string{"buyer"}, Payload: []ParamABI{amount}},
+			{Name: "Disputed", Topics: []string{"party"}, Payload: []ParamABI{}},
+			{Name: "Released", Topics: []string{"seller"}, Payload: []ParamABI{amount}},
+			{Name: "Refunded", Topics: []string{"buyer"}, Payload: []ParamABI{amount}},
+		},
+	}
+}
+
+func (sc *ContractCodeEscrow) Execute(ctx ExecutionContext) (string, error) {
+	if ctx.Method() == "status" {
+		return marshalResult(sc)
+	}
+	if ctx.Method() == "refund" && sc.Status == "funded" && ctx.BlockHeight() >= sc.Timeout {
+		// Anyone can trigger the refund once the timeout is reached
+		return sc.settle(ctx, sc.Buyer)
+	}
+
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	caller := ctx.Caller()
+	switch ctx.Method() {
+	case "deposit":
+		if caller != sc.Buyer {
+			return "", fmt.Errorf("only the buyer can deposit")
+		}
+		if sc.Status != "created" {
+			return "", fmt.Errorf("escrow is %s", sc.Status)
+		}
+		if ctx.Value() != sc.Amount {
+			return "", fmt.Errorf("deposit must attach exactly %v", sc.Amount)
+		}
+		if ctx.BlockHeight() >= sc.Timeout {
+			return "", fmt.Errorf("escrow timed out at block %d", sc.Timeout)
+		}
+		sc.Status = "funded"
+		return sc.Status, ctx.Emit("Deposited", []string{caller}, map[string]float64{"amount": sc.Amount})
+	case "release":
+		if caller != sc.Buyer {
+			return "", fmt.Errorf("only the buyer can release")
+		}
+		if sc.Status != "funded" && sc.Status != "disputed" {
+			return "", fmt.Errorf("escrow is %s", sc.Status)
+		}
+		return sc.settle(ctx, sc.Seller)
+	case "dispute":
+		if caller != sc.Buyer && caller != sc.Seller {
+			return "", fmt.Errorf("only the buyer or the seller can dispute")
+		}
+		if sc.Status != "funded" {
+			return "", fmt.Errorf("escrow is %s", sc.Status)
+		}
+		sc.Status = "disputed"
+		return sc.Status, ctx.Emit("Disputed", []string{caller}, nil)
+	case "resolve":
+		if caller != sc.Arbiter {
+			return "", fmt.Errorf("only the arbiter can resolve")
+		}
+		if sc.Status != "funded" && sc.Status != "disputed" {
+			return "", fmt.Errorf("escrow is %s", sc.Status)
+		}
+		var to string
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		if to != sc.Buyer && to != sc.Seller {
+			return "", fmt.Errorf("escrow can only be resolved to the buyer or the seller")
+		}
+		return sc.settle(ctx, to)
+	case "refund":
+		// Before the timeout, or while disputed, only the seller can refund the buyer
+		if caller != sc.Seller {
+			return "", fmt.Errorf("only the seller can refund before the timeout at block %d", sc.Timeout)
+		}
+		if sc.Status != "funded" && sc.Status != "disputed" {
+			return "", fmt.Errorf("escrow is %s", sc.Status)
+		}
+		return sc.settle(ctx, sc.Buyer)
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// settle pays the deposit to the seller or refunds it to the buyer
+func (sc *ContractCodeEscrow) settle(ctx ExecutionContext, to string) (string, error) {
+	if err := ctx.Transfer(to, sc.Amount); err != nil {
+		return "", err
+	}
+	event := "Released"
+	sc.Status = "released"
+	if to == sc.Buyer {
+		event = "Refunded"
+		sc.Status = "refunded"
+	}
+	return sc.Status, ctx.Emit(event, []string{to}, map[string]float64{"amount": sc.Amount})
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// deployEscrow deploys an escrow of 3 coins from the buyer to the seller timing out at a height
+func deployEscrow(t *testing.T, bc *Blockchain, buyer *testWallet, seller *testWallet, timeout int) string {
+	t.Helper()
+	spec := fmt.Sprintf(`{ "buyer": %q, "seller": %q, "arbiter": %q, "amount": 3, "timeout": %d }`, buyer.ID, seller.ID, newTestWallet(t).ID, timeout)
+	return deployContract(t, bc, seller, CONTRACT_ESCROW_TYPE, spec)
+}
+
+func TestEscrowSchedulesItsRefundAtDeployment(t *testing.T) {
+	bc := newTestBlockchain()
+	buyer, seller := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, buyer.ID, 1)
+	mineBlocks(t, bc, seller.ID, 1)
+
+	before := bc.getBalance(SCHEDULE_ESCROW_WALLET)
+	timeout := len(bc.Chain) + 2
+	escrow := deployEscrow(t, bc, buyer, seller, timeout)
+	schedules := bc.getSchedules(SCHEDULE_ESCROW_WALLET, escrow)
+	if len(schedules) != 1 || schedules[0].Method != "refund" || schedules[0].StartHeight != timeout {
+		t.Fatalf("escrow created the schedules %+v", schedules)
+	}
+	if prepaid := bc.getBalance(SCHEDULE_ESCROW_WALLET) - before; prepaid != DEFAULT_GAS_LIMIT {
+		t.Fatalf("deployer prepaid %v gas, expected %v", prepaid, DEFAULT_GAS_LIMIT)
+	}
+
+	mustSucceed(t, executeContract(t, bc, buyer, escrow, "deposit", nil, 3))
+	deposited := bc.getBalance(buyer.ID)
+	for len(bc.Chain)-1 < timeout {
+		mineBlocks(t, bc, seller.ID, 1)
+	}
+	refund := mineScheduled(t, bc, seller.ID)
+	if refund.Caller != SCHEDULE_ESCROW_WALLET {
+		t.Fatalf("refund executed by %s", refund.Caller)
+	}
+	mustSucceed(t, refund)
+	if refunded := bc.getBalance(buyer.ID) - deposited; refunded != 3 {
+		t.Fatalf("buyer was refunded %v", refunded)
+	}
+}
+
+func TestEscrowScheduledRefundCannotSettleAsAParty(t *testing.T) {
+	bc := newTestBlockchain()
+	buyer, seller := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, buyer.ID, 1)
+	mineBlocks(t, bc, seller.ID, 1)
+
+	timeout := len(bc.Chain) + 2
+	escrow := deployEscrow(t, bc, buyer, seller, timeout)
+	mustSucceed(t, executeContract(t, bc, buyer, escrow, "deposit", nil, 3))
+	mustSucceed(t, executeContract(t, bc, buyer, escrow, "dispute", nil, 0))
+	for len(bc.Chain)-1 < timeout {
+		mineBlocks(t, bc, seller.ID, 1)
+	}
+	mustFail(t, mineScheduled(t, bc, seller.ID))
+	if status := callView(t, bc, escrow, "status", nil); !strings.Contains(status, `"status":"disputed"`) {
+		t.Fatalf("disputed escrow became %s", status)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+const GAS_PER_EVENT float64 = 0.01
+
+// ContractEvent represents a structured event emitted by a contract during an execution
+type ContractEvent struct {
+	ContractID string          `json:"contract_id"`
+	Name       string          `json:"name"`
+	Topics     []string        `json:"topics"`
+	Payload    json.RawMessage `json:"payload"`
+	Block      int             `json:"block"`
+}
+
+// EventFilter selects contract events by contract, name, topic and block range
+// Empty fields match every event and a negative ToBlock has no upper bound
+type EventFilter struct {
+	ContractID string
+	Name       string
+	Topic      string
+	FromBlock  int
+	ToBlock    int
+}
+
+// Match checks if an event is selected by the filter
+func (f EventFilter) Match(event ContractEvent) bool {
+	if f.ContractID != "" && event.ContractID != f.ContractID {
+		return false
+	}
+	if f.Name != "" && event.Name != f.Name {
+		return false
+	}
+	if event.Block < f.FromBlock || (f.ToBlock >= 0 && event.Block > f.ToBlock) {
+		return false
+	}
+	if f.Topic == "" {
+		return true
+	}
+	for _, topic := range event.Topics {
+		if topic == f.Topic {
+			return true
+		}
+	}
+	return false
+}
+
+// EventBroker delivers mined contract events to live subscribers
+type EventBroker struct {
+	mu          sync.Mutex
+	subscribers map[chan ContractEvent]EventFilter
+}
+
+// NewEventBroker creates an event broker without subscribers
+func NewEventBroker() *EventBroker {
+	return &EventBroker{subscribers: make(map[chan ContractEvent]EventFilter)}
+}
+
+// Subscribe registers a subscriber receiving the events selected by filter
+func (b *EventBroker) Subscribe(filter EventFilter) chan ContractEvent {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	events := make(chan ContractEvent, 64)
+	b.subscribers[events] = filter
+	return events
+}
+
+// Unsubscribe removes a subscriber and closes its channel
+func (b *EventBroker) Unsubscribe(events chan ContractEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, exists := b.subscribers[events]; exists {
+		delete(b.subscribers, events)
+		close(events)
+	}
+}
+
+// Publish sends an event to the matching subscribers, slow subscribers miss events
+// instead of blocking the miner
+func (b *EventBroker) Publish(event ContractEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for events, filter := range b.subscribers {
+		if !filter.Match(event) {
+			continue
+		}
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// getContractEvents returns the mined events selected by filter, in chain order
+func (bc *Blockchain) getContractEvents(filter EventFilter) []ContractEvent {
+	events := []ContractEvent{}
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			for _, event := range execution.Events {
+				if filter.Match(event) {
+					events = append(events, event)
+				}
+			}
+		}
+	}
+	return events
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestEventFilterMatch(t *testing.T) {
+	event := ContractEvent{ContractID: "counter", Name: "Incremented", Topics: []string{"alice", "bob"}, Block: 5}
+	tests := []struct {
+		name   string
+		filter EventFilter
+		match  bool
+	}{
+		{"empty filter", EventFilter{ToBlock: -1}, true},
+		{"contract", EventFilter{ContractID: "counter", ToBlock: -1}, true},
+		{"other contract", EventFilter{ContractID: "token", ToBlock: -1}, false},
+		{"name", EventFilter{Name: "Incremented", ToBlock: -1}, true},
+		{"other name", EventFilter{Name: "Reset", ToBlock: -1}, false},
+		{"first topic", EventFilter{Topic: "alice", ToBlock: -1}, true},
+		{"second topic", EventFilter{Topic: "bob", ToBlock: -1}, true},
+		{"other topic", EventFilter{Topic: "carol", ToBlock: -1}, false},
+		{"range around the block", EventFilter{FromBlock: 4, ToBlock: 6}, true},
+		{"range starting at the block", EventFilter{FromBlock: 5, ToBlock: 6}, true},
+		{"range ending at the block", EventFilter{FromBlock: 0, ToBlock: 5}, true},
+		{"range after the block", EventFilter{FromBlock: 6, ToBlock: -1}, false},
+		{"range before the block", EventFilter{FromBlock: 0, ToBlock: 4}, false},
+		{"every field", EventFilter{ContractID: "counter", Name: "Incremented", Topic: "bob", FromBlock: 5, ToBlock: 5}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if match := test.filter.Match(event); match != test.match {
+				t.Fatalf("filter %+v matched %t", test.filter, match)
+			}
+		})
+	}
+}
+
+func TestContractEventsAreQueriedFromTheChain(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	other := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "other")
+
+	first := executeContract(t, bc, alice, counter, "increment", nil, 0)
+	mustSucceed(t, first)
+	mineBlocks(t, bc, alice.ID, 1)
+	mustSucceed(t, executeContract(t, bc, bob, counter, "increment", nil, 0))
+	mustSucceed(t, executeContract(t, bc, alice, other, "increment", nil, 0))
+	// Calls run against a snapshot and do not log their events
+	if _, err := bc.callContract(ContractExecution{ContractID: counter, Caller: alice.ID, Method: "increment", GasLimit: VIEW_GAS_LIMIT}); err != nil {
+		t.Fatal(err)
+	}
+
+	events := bc.getContractEvents(EventFilter{ContractID: counter, ToBlock: -1})
+	if len(events) != 2 || events[0].Topics[0] != alice.ID || events[1].Topics[0] != bob.ID {
+		t.Fatalf("counter logged the events %+v", events)
+	}
+	var payload map[string]int
+	if err := json.Unmarshal(events[1].Payload, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload["number_of_executions"] != 2 {
+		t.Fatalf("second event has the payload %v", payload)
+	}
+
+	tests := []struct {
+		name   string
+		filter EventFilter
+		count  int
+	}{
+		{"every contract", EventFilter{ToBlock: -1}, 3},
+		{"topic", EventFilter{Topic: alice.ID, ToBlock: -1}, 2},
+		{"topic of a contract", EventFilter{ContractID: counter, Topic: alice.ID, ToBlock: -1}, 1},
+		{"block of the first execution", EventFilter{FromBlock: first.Events[0].Block, ToBlock: first.Events[0].Block}, 1},
+		{"blocks after the first execution", EventFilter{FromBlock: first.Events[0].Block + 1, ToBlock: -1}, 2},
+		{"other name", EventFilter{Name: "Reset", ToBlock: -1}, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if events := bc.getContractEvents(test.filter); len(events) != test.count {
+				t.Fatalf("filter selected %d events, expected %d", len(events), test.count)
+			}
+		})
+	}
+}
+
+func TestEventBrokerDeliversMatchingEvents(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	other := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "other")
+
+	subscribed := bc.EventBroker.Subscribe(EventFilter{ContractID: counter, ToBlock: -1})
+	everything := bc.EventBroker.Subscribe(EventFilter{ToBlock: -1})
+	mustSucceed(t, executeContract(t, bc, alice, other, "increment", nil, 0))
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+
+	if len(subscribed) != 1 || len(everything) != 2 {
+		t.Fatalf("subscribers received %d and %d events", len(subscribed), len(everything))
+	}
+	if event := <-subscribed; event.ContractID != counter || event.Name != "Incremented" {
+		t.Fatalf("subscriber received %+v", event)
+	}
+
+	// A full subscriber misses events instead of blocking the miner
+	for i := 0; i < cap(everything); i++ {
+		bc.EventBroker.Publish(ContractEvent{ContractID: other, Block: len(bc.Chain)})
+	}
+	if len(everything) != cap(everything) {
+		t.Fatalf("subscriber holds %d events", len(everything))
+	}
+
+	bc.EventBroker.Unsubscribe(subscribed)
+	if _, open := <-subscribed; open {
+		t.Fatal("channel of the unsubscribed subscriber is still open")
+	}
+	bc.EventBroker.Unsubscribe(subscribed)
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+}
@@ -2,23 +2,96 @@ package main
 
 import (
 	"fmt"
+	"strconv"
 )
 
+const CONTRACT_EXAMPLE_TYPE string = "contract_example"
+const CONTRACT_EXAMPLE_V2_TYPE string = "contract_example_v2"
+
+func init() {
+	registerCode(CONTRACT_EXAMPLE_TYPE, func() Code { return &ContractCodeExample{} })
+	registerCode(CONTRACT_EXAMPLE_V2_TYPE, func() Code { return &ContractCodeExampleV2{} })
+}
+
 // ContractCodeExample implements the Code interface for smart contract of type ContractCodeExamples
 type ContractCodeExample struct {
-	NumberOfExecutions int `json:"number_of_executions"`
+	Owner              string `json:"owner"`
+	NumberOfExecutions int    `json:"number_of_executions"`
 }
 
-func (sc *ContractCodeExample) Execute(blockchain *Blockchain) error {
-	// Add logic to process the smart contract of type ContractCodeExample
-	sc.NumberOfExecutions ++
-	fmt.Println("Executing smart contract of type ContractCodeExample...")
-	fmt.Printf("Current number of Executions: %d\n", sc.NumberOfExecutions)
+// Initialize records the deployer as the owner of the contract, the specification is free text
+func (sc *ContractCodeExample) Initialize(owner string, specification string) error {
+	sc.Owner = owner
 	return nil
 }
 
-func (sc *ContractCodeExample) Validate(blockchain *Blockchain) bool {
+func (sc *ContractCodeExample) Execute(ctx ExecutionContext) (string, error) {
+	// Add logic to process the smart contract of type ContractCodeExample
+	switch ctx.Method() {
+	case "", "increment":
+		sc.NumberOfExecutions ++
+		err := ctx.Emit("Incremented", []string{ctx.Caller()}, map[string]int{
+			"number_of_executions": sc.NumberOfExecutions,
+		})
+		if err != nil {
+			return "", err
+		}
+	case "count":
+	default:
+		return "", fmt.Errorf("unknown method %s", ctx.Method())
+	}
+	return strconv.Itoa(sc.NumberOfExecutions), nil
+}
+
+func (sc *ContractCodeExample) ABI() ContractABI {
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "", Params: []ParamABI{}, Returns: "integer", Payable: true}, // Increments the counter
+			{Name: "increment", Params: []ParamABI{}, Returns: "integer", Payable: true},
+			{Name: "count", Params: []ParamABI{}, Returns: "integer", ReadOnly: true},
+		},
+		Events: []EventABI{
+			{Name: "Incremented", Topics: []string{"caller"}, Payload: []ParamABI{{Name: "number_of_executions", Type: "integer"}}},
+		},
+	}
+}
+
+// Successors allows the owner to upgrade the contract to ContractCodeExampleV2
+func (sc *ContractCodeExample) Successors() []string {
+	return []string{CONTRACT_EXAMPLE_V2_TYPE}
+}
+
+func (sc *ContractCodeExample) Validate(chain ChainReader) bool {
 	// Add validation logic for the smart contract of type ContractCodeExample
 	fmt.Println("Validating smart contract of type ContractCodeExample...")
 	return true
 }
+
+// ContractCodeExampleV2 is an upgrade of ContractCodeExample keeping its state and adding a reset
+// method restricted to the owner
+type ContractCodeExampleV2 struct {
+	ContractCodeExample
+}
+
+func (sc *ContractCodeExampleV2) Execute(ctx ExecutionContext) (string, error) {
+	if ctx.Method() == "reset" {
+		if !ctx.Authenticated() || ctx.Caller() != sc.Owner {
+			return "", fmt.Errorf("only the contract owner can reset")
+		}
+		sc.NumberOfExecutions = 0
+		return "0", ctx.Emit("Reset", []string{ctx.Caller()}, nil)
+	}
+	return sc.ContractCodeExample.Execute(ctx)
+}
+
+func (sc *ContractCodeExampleV2) ABI() ContractABI {
+	abi := sc.ContractCodeExample.ABI()
+	abi.Methods = append(abi.Methods, MethodABI{Name: "reset", Params: []ParamABI{}, Returns: "integer"})
+	abi.Events = append(abi.Events, EventABI{Name: "Reset", Topics: []string{"caller"}, Payload: []ParamABI{}})
+	return abi
+}
+
+// Successors publishes no further version, ContractCodeExampleV2 is only upgraded to itself
+func (sc *ContractCodeExampleV2) Successors() []string {
+	return []string{}
+}
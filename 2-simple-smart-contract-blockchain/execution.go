@@ -0,0 +1,567 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const DEFAULT_GAS_LIMIT float64 = 1
+const VIEW_GAS_LIMIT float64 = 10
+const GAS_PER_TRANSFER float64 = 0.02
+const GAS_PER_STORAGE_WRITE float64 = 0.01
+const GAS_PER_BYTE float64 = 0.0001 // Gas per byte stored or emitted, on top of the write or event
+const MAX_CALL_DEPTH int = 8
+const EXECUTION_TIMEOUT time.Duration = 2 * time.Second // Local safety net, executions exceeding it are not mined
+const MAX_EXECUTION_MEMORY uint64 = 64 << 20            // Local safety net on the bytes allocated while an execution runs
+const MAX_EXECUTION_OUTPUT int = 4 << 20                // Bytes an execution stores or emits, including its calls
+const MAX_CONTRACT_STATE_SIZE int = 1 << 20             // Bytes of the serialized state of a contract
+
+// ChainReader gives contracts read-only access to the chain
+type ChainReader interface {
+	Balance(address string) float64
+	IsContract(address string) bool
+	ContractType(address string) (string, bool)
+	BlockHeight() int
+	BlockTime() time.Time
+	Oracle(feed string, key string) (OracleObservation, error)
+	Randomness(round int) (string, error)
+}
+
+// ExecutionContext is the only access the Code of a contract has to the blockchain while one
+// of its methods executes. Calls to other contracts run in child contexts whose effects are
+// kept only when they succeed
+type ExecutionContext interface {
+	ChainReader
+	ContractID() string
+	Caller() string
+	Authenticated() bool
+	Method() string
+	Arg(name string, v any) error
+	Value() float64
+	UseGas(amount float64) error
+	GasLeft() float64
+	GetStorage(key string, v any) (bool, error)
+	SetStorage(key string, v any) error
+	Transfer(to string, amount float64) error
+	Emit(name string, topics []string, payload any) error
+	Call(contractID string, method string, args map[string]any, value float64) (string, error)
+}
+
+// chainReader implements ChainReader on top of the blockchain
+type chainReader struct {
+	blockchain *Blockchain
+}
+
+// Balance returns the balance of an address
+func (r chainReader) Balance(address string) float64 {
+	return r.blockchain.getBalance(address)
+}
+
+// IsContract checks if an address is a deployed contract
+func (r chainReader) IsContract(address string) bool {
+	return r.blockchain.isContract(address)
+}
+
+// ContractType returns the type of the current code version of a contract, false when the
+// address is not a contract
+func (r chainReader) ContractType(address string) (string, bool) {
+	version, err := r.blockchain.getContractVersion(address)
+	if err != nil {
+		return "", false
+	}
+	return version.Type, true
+}
+
+// BlockHeight returns the index of the block being built
+func (r chainReader) BlockHeight() int {
+	return len(r.blockchain.Chain) - 1
+}
+
+// BlockTime returns the timestamp of the block being built
+func (r chainReader) BlockTime() time.Time {
+	return r.blockchain.getLastBlock().Timestamp
+}
+
+// Oracle returns the latest observation of a key of an oracle feed reported in a mined block, so
+// executions read the same observation when a received chain is replayed. Its timestamp tells
+// contracts how fresh it is
+func (r chainReader) Oracle(feed string, key string) (OracleObservation, error) {
+	observation, exists := r.blockchain.findOracleObservation(feed, key, len(r.blockchain.Chain)-1)
+	if !exists {
+		return OracleObservation{}, fmt.Errorf("no observation of %s in oracle feed %s", key, feed)
+	}
+	return observation, nil
+}
+
+// Randomness returns the hex encoded random value of a closed beacon round, contracts should
+// use a round that was not closed yet when their outcome was committed to
+func (r chainReader) Randomness(round int) (string, error) {
+	return r.blockchain.getRandomness(round)
+}
+
+// executionContext implements ExecutionContext for one execution of a contract method
+type executionContext struct {
+	guardedReader
+	contractID    string
+	version       int
+	caller        string
+	authenticated bool // The caller proved its identity
+	method        string
+	args          map[string]json.RawMessage
+	value         float64 // Coins attached by the caller, already credited to the contract
+	gasLimit      float64
+	gasUsed       float64
+	depth         int
+	events        []ContractEvent
+	transfers     []Transaction  // Transfers of the execution, applied to the chain only on success
+	calls         []ContractCall // Calls made to other contracts, including the reverted ones
+	parent        *executionContext
+	states        map[string]*ContractState // States of the contracts modified by this context and its successful calls
+}
+
+// ContractCall records a call made by a contract to another contract during an execution
+type ContractCall struct {
+	Caller     string                     `json:"caller"`
+	ContractID string                     `json:"contract_id"`
+	Version    int                        `json:"version"`
+	Method     string                     `json:"method"`
+	Args       map[string]json.RawMessage `json:"args"`
+	Value      float64                    `json:"value"`
+	GasUsed    float64                    `json:"gas_used"`
+	Result     string                     `json:"result"`
+	Error      string                     `json:"error"`
+	Calls      []ContractCall             `json:"calls"`
+}
+
+// ContractID returns the ID of the executing contract
+func (ctx *executionContext) ContractID() string {
+	return ctx.contractID
+}
+
+// Caller returns the wallet or contract calling the method
+func (ctx *executionContext) Caller() string {
+	return ctx.caller
+}
+
+// Authenticated checks if the caller proved its identity, by signing the execution or its
+// schedule, or by being the contract making the call
+func (ctx *executionContext) Authenticated() bool {
+	return ctx.authenticated
+}
+
+// Method returns the name of the executing method
+func (ctx *executionContext) Method() string {
+	return ctx.method
+}
+
+// Value returns the coins attached by the caller
+func (ctx *executionContext) Value() float64 {
+	return ctx.value
+}
+
+// UseGas consumes gas from the execution, failing when the gas limit is exceeded
+func (ctx *executionContext) UseGas(amount float64) error {
+	if err := ctx.sandbox.enter(); err != nil {
+		return err
+	}
+	defer ctx.sandbox.leave()
+	return ctx.useGas(amount)
+}
+
+// useGas consumes gas while holding the sandbox
+func (ctx *executionContext) useGas(amount float64) error {
+	if ctx.gasUsed+amount > ctx.gasLimit {
+		ctx.gasUsed = ctx.gasLimit
+		return fmt.Errorf("out of gas")
+	}
+	ctx.gasUsed += amount
+	return nil
+}
+
+// output consumes the gas of bytes stored or emitted and accounts for them in the output limit
+func (ctx *executionContext) output(gas float64, bytes int) error {
+	if err := ctx.useGas(gas + float64(bytes)*GAS_PER_BYTE); err != nil {
+		return err
+	}
+	return ctx.sandbox.write(bytes)
+}
+
+// GasLeft returns the gas the execution can still consume
+func (ctx *executionContext) GasLeft() float64 {
+	return ctx.gasLimit - ctx.gasUsed
+}
+
+// Arg decodes the argument called name into v
+func (ctx *executionContext) Arg(name string, v any) error {
+	raw, exists := ctx.args[name]
+	if !exists {
+		return fmt.Errorf("missing argument %s", name)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return fmt.Errorf("invalid argument %s: %v", name, err)
+	}
+	return nil
+}
+
+// GetStorage decodes the value stored under key in the storage of the contract into v
+func (ctx *executionContext) GetStorage(key string, v any) (bool, error) {
+	if err := ctx.sandbox.enter(); err != nil {
+		return false, err
+	}
+	defer ctx.sandbox.leave()
+	raw, exists := ctx.states[ctx.contractID].Storage[key]
+	if !exists {
+		return false, nil
+	}
+	return true, json.Unmarshal(raw, v)
+}
+
+// SetStorage stores v under key in the storage of the contract, a nil v deletes the key
+func (ctx *executionContext) SetStorage(key string, v any) error {
+	if err := ctx.sandbox.enter(); err != nil {
+		return err
+	}
+	defer ctx.sandbox.leave()
+	storage := ctx.states[ctx.contractID].Storage
+	if v == nil {
+		if err := ctx.useGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return err
+		}
+		delete(storage, key)
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("invalid storage value for %s: %v", key, err)
+	}
+	if err := ctx.output(GAS_PER_STORAGE_WRITE, len(key)+len(data)); err != nil {
+		return err
+	}
+	storage[key] = data
+	return nil
+}
+
+// Emit records an event with indexed topics and a JSON payload in the receipt of the execution
+func (ctx *executionContext) Emit(name string, topics []string, payload any) error {
+	if err := ctx.sandbox.enter(); err != nil {
+		return err
+	}
+	defer ctx.sandbox.leave()
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("invalid payload of event %s: %v", name, err)
+	}
+	size := len(name) + len(data)
+	for _, topic := range topics {
+		size += len(topic)
+	}
+	if err := ctx.output(GAS_PER_EVENT, size); err != nil {
+		return err
+	}
+	ctx.events = append(ctx.events, ContractEvent{
+		ContractID: ctx.contractID,
+		Name:       name,
+		Topics:     topics,
+		Payload:    data,
+		Block:      ctx.chainReader.BlockHeight(), // Events belong to the block being built
+	})
+	return nil
+}
+
+// Balance returns the balance of an address including the pending transfers of the execution
+func (ctx *executionContext) Balance(address string) float64 {
+	if ctx.sandbox.enter() != nil {
+		return 0
+	}
+	defer ctx.sandbox.leave()
+	return ctx.balance(address)
+}
+
+// balance returns the balance of an address while holding the sandbox
+func (ctx *executionContext) balance(address string) float64 {
+	balance := ctx.blockchain.getBalance(address)
+	for frame := ctx; frame != nil; frame = frame.parent {
+		for _, tx := range frame.transfers {
+			if tx.From == address {
+				balance -= tx.Amount
+			} else if tx.To == address {
+				balance += tx.Amount
+			}
+		}
+	}
+	return balance
+}
+
+// Transfer sends coins from the balance of the contract to another address
+func (ctx *executionContext) Transfer(to string, amount float64) error {
+	if err := ctx.sandbox.enter(); err != nil {
+		return err
+	}
+	defer ctx.sandbox.leave()
+	if err := ctx.useGas(GAS_PER_TRANSFER); err != nil {
+		return err
+	}
+	if amount <= 0 {
+		return fmt.Errorf("transfer amount must be positive")
+	}
+	if to == "" || to == ctx.contractID || to == BLOCK_REWARD_WALLET {
+		return fmt.Errorf("invalid transfer recipient")
+	}
+	if ctx.balance(ctx.contractID) < amount {
+		return fmt.Errorf("insufficient contract balance")
+	}
+	ctx.transfers = append(ctx.transfers, Transaction{
+		From:   ctx.contractID,
+		To:     to,
+		Amount: amount,
+	})
+	return nil
+}
+
+// Call executes a method of another contract with the contract as caller, attaching value
+// coins from the contract balance. The call shares the remaining gas of the execution and
+// all its effects are reverted when it fails
+func (ctx *executionContext) Call(contractID string, method string, args map[string]any, value float64) (string, error) {
+	record := ContractCall{
+		Caller:     ctx.contractID,
+		ContractID: contractID,
+		Method:     method,
+		Args:       make(map[string]json.RawMessage, len(args)),
+		Value:      value,
+	}
+	for name, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			return "", fmt.Errorf("invalid argument %s: %v", name, err)
+		}
+		record.Args[name] = data
+	}
+
+	child, code, err := ctx.newCall(ContractExecution{
+		ContractID: contractID,
+		Caller:     ctx.contractID,
+		Method:     method,
+		Args:       record.Args,
+		Value:      value,
+		GasLimit:   ctx.GasLeft(),
+	})
+	if err != nil {
+		record.Error = err.Error()
+		ctx.calls = append(ctx.calls, record)
+		return "", err
+	}
+
+	record.Version = child.version
+	result, err := child.run(code)
+	ctx.gasUsed += child.gasUsed
+	record.GasUsed = child.gasUsed
+	record.Calls = child.calls
+	if err != nil {
+		record.Error = err.Error()
+		ctx.calls = append(ctx.calls, record)
+		return "", fmt.Errorf("call to contract %s failed: %v", contractID, err)
+	}
+	record.Result = result
+	ctx.calls = append(ctx.calls, record)
+
+	// Keep the effects of the successful call
+	for id, state := range child.states {
+		ctx.states[id] = state
+	}
+	ctx.events = append(ctx.events, child.events...)
+	ctx.transfers = append(ctx.transfers, child.transfers...)
+	return result, nil
+}
+
+// newCall creates the context of a call made by the contract while holding the sandbox, the
+// child context runs in the same sandbox
+func (ctx *executionContext) newCall(execution ContractExecution) (*executionContext, Code, error) {
+	if err := ctx.sandbox.enter(); err != nil {
+		return nil, nil, err
+	}
+	defer ctx.sandbox.leave()
+	return ctx.blockchain.newExecutionContext(ctx, execution)
+}
+
+// contractState returns the state of a contract as seen by the execution
+func (ctx *executionContext) contractState(contractID string) (*ContractState, error) {
+	for frame := ctx; frame != nil; frame = frame.parent {
+		if state, exists := frame.states[contractID]; exists {
+			return state, nil
+		}
+	}
+	return ctx.blockchain.getContractState(contractID)
+}
+
+// isExecuting checks if a contract is already executing in the call stack
+func (ctx *executionContext) isExecuting(contractID string) bool {
+	for frame := ctx; frame != nil; frame = frame.parent {
+		if frame.contractID == contractID {
+			return true
+		}
+	}
+	return false
+}
+
+// run charges the fixed gas fee, credits the attached value and executes the code
+func (ctx *executionContext) run(code Code) (string, error) {
+	if err := ctx.UseGas(GAS_PRICE); err != nil {
+		return "", err
+	}
+
+	// The attached value is credited to the contract before its code runs
+	if ctx.value < 0 {
+		return "", fmt.Errorf("attached value must not be negative")
+	}
+	if ctx.value > 0 {
+		if ctx.Balance(ctx.caller) < ctx.value {
+			return "", fmt.Errorf("insufficient balance to attach value")
+		}
+		ctx.transfers = append(ctx.transfers, Transaction{
+			From:   ctx.caller,
+			To:     ctx.contractID,
+			Amount: ctx.value,
+		})
+	}
+
+	return code.Execute(ctx)
+}
+
+// cloneContractState copies the state of a contract through its serialization
+func cloneContractState(contractType string, state *ContractState) (*ContractState, error) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	return restoreContractState(contractType, data)
+}
+
+// snapshot returns a copy of the blockchain that can be modified without affecting it
+func (bc *Blockchain) snapshot() *Blockchain {
+	snapshot := *bc
+	snapshot.Chain = append([]Block(nil), bc.Chain...)
+	snapshot.TransactionPool = append([]Transaction(nil), bc.TransactionPool...)
+	snapshot.ContractExecutionPool = append([]ContractExecution(nil), bc.ContractExecutionPool...)
+	snapshot.ContractDeploymentPool = append([]SmartContract(nil), bc.ContractDeploymentPool...)
+	snapshot.ContractUpgradePool = append([]ContractUpgrade(nil), bc.ContractUpgradePool...)
+	snapshot.SchedulePool = append([]ContractSchedule(nil), bc.SchedulePool...)
+	snapshot.HashLockPool = append([]HashLock(nil), bc.HashLockPool...)
+	snapshot.HashLockSettlementPool = append([]HashLockSettlement(nil), bc.HashLockSettlementPool...)
+	snapshot.OracleFeedPool = append([]OracleFeed(nil), bc.OracleFeedPool...)
+	snapshot.OracleReportPool = append([]OracleReport(nil), bc.OracleReportPool...)
+	snapshot.BeaconCommitPool = append([]BeaconCommit(nil), bc.BeaconCommitPool...)
+	snapshot.BeaconRevealPool = append([]BeaconReveal(nil), bc.BeaconRevealPool...)
+	snapshot.ContractStates = make(map[string]*ContractState, len(bc.ContractStates))
+	for contractID, state := range bc.ContractStates {
+		snapshot.ContractStates[contractID] = state
+	}
+	return &snapshot
+}
+
+// newExecutionContext creates the context of an execution on top of parent, which is nil for
+// executions mined from the pool, with a copy of the contract state the code runs on
+func (bc *Blockchain) newExecutionContext(parent *executionContext, execution ContractExecution) (*executionContext, Code, error) {
+	version, err := bc.getContractVersion(execution.ContractID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ctx := &executionContext{
+		guardedReader: guardedReader{chainReader{bc}, new(sandbox)},
+		contractID:    execution.ContractID,
+		version:       version.Version,
+		caller:        execution.Caller,
+		authenticated: execution.isAuthenticated(),
+		method:        execution.Method,
+		args:          execution.Args,
+		value:         execution.Value,
+		gasLimit:      execution.GasLimit,
+		parent:        parent,
+		states:        make(map[string]*ContractState),
+	}
+
+	var live *ContractState
+	if parent != nil {
+		if parent.depth+1 > MAX_CALL_DEPTH {
+			return nil, nil, fmt.Errorf("maximum call depth of %d exceeded", MAX_CALL_DEPTH)
+		}
+		if parent.isExecuting(execution.ContractID) {
+			return nil, nil, fmt.Errorf("reentrant call to contract %s", execution.ContractID)
+		}
+		ctx.depth = parent.depth + 1
+		ctx.authenticated = true // Calls are made by the executing contract itself
+		ctx.sandbox = parent.sandbox
+		live, err = parent.contractState(execution.ContractID)
+	} else {
+		live, err = bc.getContractState(execution.ContractID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := cloneContractState(version.Type, live)
+	if err != nil {
+		return nil, nil, err
+	}
+	if describer, ok := state.Code.(Describer); ok {
+		// Executions on the chain were checked when added, contracts may read each other's views
+		if err := describer.ABI().ValidateCall(execution.Method, execution.Args, execution.Value, false); err != nil {
+			return nil, nil, err
+		}
+	}
+	ctx.states[execution.ContractID] = state
+	return ctx, state.Code, nil
+}
+
+// runContract executes a method of a contract on copies of the contract states, within the
+// gas limit and output limit of the chain rules. The context is always returned, also when the
+// execution fails, so the caller charges its gas and decides whether its effects are kept. An
+// execution the node gave up on fails with errExecutionAborted, its outcome is not decided by
+// the chain rules
+func (bc *Blockchain) runContract(execution ContractExecution) (*executionContext, string, error) {
+	ctx, code, err := bc.newExecutionContext(nil, execution)
+	if err != nil {
+		// The execution cannot start, such as when its call no longer matches the ABI of an
+		// upgraded contract, it fails consuming the fixed gas fee so failing executions are not free
+		failed := &executionContext{contractID: execution.ContractID, gasUsed: GAS_PRICE}
+		if version, versionErr := bc.getContractVersion(execution.ContractID); versionErr == nil {
+			failed.version = version.Version
+		}
+		return failed, "", err
+	}
+
+	result, err := runSandboxed(ctx.sandbox, func() (string, error) {
+		return ctx.run(code)
+	})
+	if ctx.sandbox.isAborted() {
+		// The abandoned execution may still be using its context, an empty one is returned
+		return &executionContext{contractID: ctx.contractID, version: ctx.version, gasUsed: ctx.gasLimit}, "", err
+	}
+	if err != nil {
+		return ctx, result, err
+	}
+
+	for contractID, state := range ctx.states {
+		if data, _ := json.Marshal(state); len(data) > MAX_CONTRACT_STATE_SIZE {
+			return ctx, "", fmt.Errorf("state of contract %s exceeds %d bytes", contractID, MAX_CONTRACT_STATE_SIZE)
+		}
+	}
+	return ctx, result, nil
+}
+
+// callContract executes a method of a contract against a snapshot of the current state
+// and returns its receipt with the result and the gas it would consume, nothing is persisted
+func (bc *Blockchain) callContract(execution ContractExecution) (ContractExecution, error) {
+	ctx, result, err := bc.snapshot().runContract(execution)
+	execution.ConsumedGas = ctx.gasUsed
+	execution.Calls = ctx.calls
+	if err != nil {
+		execution.Error = err.Error()
+		return execution, err
+	}
+	execution.Result = result
+	execution.Events = ctx.events
+	execution.Transfers = ctx.transfers
+	return execution, nil
+}
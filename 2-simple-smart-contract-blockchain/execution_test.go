@@ -0,0 +1,159 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+const TEST_VAULT_TYPE string = "test_vault"
+
+func init() {
+	registerCode(TEST_VAULT_TYPE, func() Code { return &testVaultCode{} })
+}
+
+// testVaultCode keeps the value attached to its executions and pays it out on withdraw
+type testVaultCode struct{}
+
+func (sc *testVaultCode) Execute(ctx ExecutionContext) (string, error) {
+	if ctx.Method() != "withdraw" {
+		return "", nil
+	}
+	var to string
+	var amount float64
+	if err := ctx.Arg("to", &to); err != nil {
+		return "", err
+	}
+	if err := ctx.Arg("amount", &amount); err != nil {
+		return "", err
+	}
+	if err := ctx.Transfer(to, amount); err != nil {
+		return "", err
+	}
+	// Withdrawals above 5 coins fail after their transfer
+	if amount > 5 {
+		return "", fmt.Errorf("withdrawal above 5 coins")
+	}
+	return "", nil
+}
+
+func (sc *testVaultCode) Validate(chain ChainReader) bool {
+	return true
+}
+
+func TestContractsHoldAndTransferCoins(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	vault := deployContract(t, bc, alice, TEST_VAULT_TYPE, "")
+	if !bc.isContract(vault) || bc.isContract(alice.ID) {
+		t.Fatal("vault is not reported as a contract account")
+	}
+
+	before := bc.getBalance(alice.ID)
+	deposit := executeContract(t, bc, alice, vault, "deposit", nil, 8)
+	mustSucceed(t, deposit)
+	if balance := bc.getBalance(vault); balance != 8 {
+		t.Fatalf("vault holds %v after the deposit", balance)
+	}
+	if spent := before - bc.getBalance(alice.ID); math.Abs(spent-8-deposit.ConsumedGas) > BALANCE_TOLERANCE {
+		t.Fatalf("alice spent %v on a deposit of 8 and %v gas", spent, deposit.ConsumedGas)
+	}
+
+	// The transfers of the contract are included in the block
+	withdraw := executeContract(t, bc, alice, vault, "withdraw", map[string]any{"to": bob.ID, "amount": 3}, 0)
+	mustSucceed(t, withdraw)
+	included := false
+	for _, tx := range bc.getLastBlock().Data.Transactions {
+		included = included || tx == Transaction{From: vault, To: bob.ID, Amount: 3}
+	}
+	if !included {
+		t.Fatalf("block does not include the transfer %+v", withdraw.Transfers)
+	}
+	if bc.getBalance(vault) != 5 || bc.getBalance(bob.ID) != 3 {
+		t.Fatalf("vault holds %v and bob %v after the withdrawal", bc.getBalance(vault), bc.getBalance(bob.ID))
+	}
+
+	// Transfers of failed executions and above the balance of the contract are not made
+	mustFail(t, executeContract(t, bc, alice, vault, "withdraw", map[string]any{"to": bob.ID, "amount": 6}, 1))
+	mustFail(t, executeContract(t, bc, alice, vault, "withdraw", map[string]any{"to": vault, "amount": 1}, 0))
+	mustFail(t, executeContract(t, bc, alice, vault, "withdraw", map[string]any{"to": bob.ID, "amount": 5.5}, 0))
+	if bc.getBalance(vault) != 5 || bc.getBalance(bob.ID) != 3 {
+		t.Fatalf("vault holds %v and bob %v after failed withdrawals", bc.getBalance(vault), bc.getBalance(bob.ID))
+	}
+}
+
+func TestCallsRunAgainstASnapshot(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+	blocks, executions := len(bc.Chain), len(bc.Chain[len(bc.Chain)-1].Data.ContractExecutionHistory)
+	balance := bc.getBalance(alice.ID)
+
+	call, err := bc.callContract(ContractExecution{ContractID: counter, Caller: alice.ID, Method: "increment", GasLimit: VIEW_GAS_LIMIT})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if call.Result != "2" || len(call.Events) != 1 || call.ConsumedGas <= 0 {
+		t.Fatalf("call returned %+v", call)
+	}
+	// The call is answered as if mined, nothing of it is kept or charged
+	if count := callView(t, bc, counter, "count", nil); count != "1" {
+		t.Fatalf("counter is %s after the call", count)
+	}
+	if len(bc.Chain) != blocks || len(bc.Chain[len(bc.Chain)-1].Data.ContractExecutionHistory) != executions {
+		t.Fatal("call was recorded on the chain")
+	}
+	if bc.getBalance(alice.ID) != balance {
+		t.Fatal("call was charged to the caller")
+	}
+
+	if _, err := bc.callContract(ContractExecution{ContractID: counter, Method: "increment", GasLimit: GAS_PER_EVENT / 2}); err == nil || !strings.Contains(err.Error(), "out of gas") {
+		t.Fatalf("call over its gas limit returned %v", err)
+	}
+	if _, err := bc.callContract(ContractExecution{ContractID: "unknown", Method: "count", GasLimit: VIEW_GAS_LIMIT}); err == nil {
+		t.Fatal("call of an unknown contract succeeded")
+	}
+}
+
+func TestCallRouteAnswersFromTheCurrentState(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the node")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the node")
+	}
+	node := startServer(t, ".")
+	alice := newTestWallet(t)
+	request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+
+	contract := SmartContract{Type: CONTRACT_EXAMPLE_TYPE, Specification: "counter", Nonce: alice.nextNonce()}
+	deployed := request(t, http.MethodPost, node+"/contract/new", map[string]any{
+		"wallet": alice.ID, "type": contract.Type, "specification": contract.Specification,
+		"nonce": contract.Nonce, "signature": alice.sign(t, contract.signingMessage()),
+	}, http.StatusCreated)
+	contractID := deployed["contractID"].(string)
+	request(t, http.MethodGet, node+"/mine/deployment?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+
+	call := node + "/contract/" + contractID + "/call"
+	incremented := request(t, http.MethodGet, call+"?method=increment&caller="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	if incremented["result"] != "1" || len(incremented["events"].([]any)) != 1 {
+		t.Fatalf("increment call answered %v", incremented)
+	}
+	if counted := request(t, http.MethodGet, call+"?method=count", nil, http.StatusOK); counted["result"] != "0" {
+		t.Fatalf("count call answered %v after the increment call", counted)
+	}
+
+	failed := request(t, http.MethodGet, call+"?method=reset", nil, http.StatusUnprocessableEntity)
+	if !strings.Contains(failed["error"].(string), "unknown method") {
+		t.Fatalf("call of an unknown method answered %v", failed)
+	}
+	request(t, http.MethodGet, call+"?method=count&args=invalid", nil, http.StatusBadRequest)
+	request(t, http.MethodGet, node+"/contract/unknown/call?method=count", nil, http.StatusNotFound)
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testWallet is a wallet with its private key, signing operations with increasing nonces
+type testWallet struct {
+	ID    string
+	key   *rsa.PrivateKey
+	nonce int64
+}
+
+// newTestWallet generates a wallet with a fresh RSA key
+func newTestWallet(t *testing.T) *testWallet {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemKey := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	return &testWallet{ID: base64.StdEncoding.EncodeToString(pemKey), key: key}
+}
+
+// sign signs message as the openssl commands of the README do
+func (w *testWallet) sign(t *testing.T, message string) string {
+	t.Helper()
+	hash := sha256.Sum256([]byte(message))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, w.key, crypto.SHA256, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// nextNonce returns a nonce the wallet has not used yet
+func (w *testWallet) nextNonce() int64 {
+	w.nonce++
+	return w.nonce
+}
+
+// newTestBlockchain creates a blockchain that mines quickly and rewards generously
+func newTestBlockchain() *Blockchain {
+	bc := CreateBlockchain(1, 100, 1000000)
+	return &bc
+}
+
+// mineBlocks mines n blocks rewarding miner
+func mineBlocks(t *testing.T, bc *Blockchain, miner string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if _, err := bc.mineBlock(miner); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// mineUntil mines blocks until the open block is at height
+func mineUntil(t *testing.T, bc *Blockchain, miner string, height int) {
+	t.Helper()
+	for len(bc.Chain)-1 < height {
+		mineBlocks(t, bc, miner, 1)
+	}
+}
+
+// marshalArgs encodes the arguments of a contract method
+func marshalArgs(t *testing.T, args map[string]any) map[string]json.RawMessage {
+	t.Helper()
+	raw := make(map[string]json.RawMessage, len(args))
+	for name, arg := range args {
+		data, err := json.Marshal(arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw[name] = data
+	}
+	return raw
+}
+
+// deployContract deploys a contract signed by the wallet and mines the deployment
+func deployContract(t *testing.T, bc *Blockchain, w *testWallet, contractType string, specification string) string {
+	t.Helper()
+	code, err := newCode(contractType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contractID, err := generateRandomID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := SmartContract{
+		ContractID:    contractID,
+		Wallet:        w.ID,
+		Type:          contractType,
+		Specification: specification,
+		Nonce:         w.nextNonce(),
+		Code:          code,
+	}
+	contract.Signature = w.sign(t, contract.signingMessage())
+	if err := bc.addContract(contract); err != nil {
+		t.Fatalf("deploying %s failed: %v", contractType, err)
+	}
+	if _, err := bc.mineContractDeployment(w.ID); err != nil {
+		t.Fatalf("mining the deployment of %s failed: %v", contractType, err)
+	}
+	return contractID
+}
+
+// signedExecution builds an execution of a contract method signed by the wallet
+func signedExecution(t *testing.T, w *testWallet, contractID string, method string, args map[string]any, value float64) ContractExecution {
+	t.Helper()
+	execution := ContractExecution{
+		ContractID: contractID,
+		Caller:     w.ID,
+		Method:     method,
+		Args:       marshalArgs(t, args),
+		Value:      value,
+		GasLimit:   10,
+		Nonce:      w.nextNonce(),
+	}
+	execution.Signature = w.sign(t, execution.signingMessage())
+	return execution
+}
+
+// executeContract submits a signed execution and mines it, returning its receipt
+func executeContract(t *testing.T, bc *Blockchain, w *testWallet, contractID string, method string, args map[string]any, value float64) ContractExecution {
+	t.Helper()
+	if err := bc.addContractExecution(signedExecution(t, w, contractID, method, args, value)); err != nil {
+		t.Fatalf("submitting %s failed: %v", method, err)
+	}
+	execution, err := bc.mineContractExecution(w.ID)
+	if err != nil {
+		t.Fatalf("mining %s failed: %v", method, err)
+	}
+	return execution
+}
+
+// mustSucceed fails the test when a mined execution failed
+func mustSucceed(t *testing.T, execution ContractExecution) string {
+	t.Helper()
+	if execution.Error != "" {
+		t.Fatalf("%s failed: %s", execution.Method, execution.Error)
+	}
+	return execution.Result
+}
+
+// mustFail fails the test when a mined execution succeeded
+func mustFail(t *testing.T, execution ContractExecution) {
+	t.Helper()
+	if execution.Error == "" {
+		t.Fatalf("%s succeeded with %s, expected a failure", execution.Method, execution.Result)
+	}
+}
+
+// callView calls a method against the current state and returns its result
+func callView(t *testing.T, bc *Blockchain, contractID string, method string, args map[string]any) string {
+	t.Helper()
+	call, err := bc.callContract(ContractExecution{ContractID: contractID, Method: method, Args: marshalArgs(t, args), GasLimit: VIEW_GAS_LIMIT})
+	if err != nil {
+		t.Fatalf("calling %s failed: %v", method, err)
+	}
+	return call.Result
+}
+
+// startServer builds the program in dir and runs it on a free port with the environment
+// variables env, returning its URL
+func startServer(t *testing.T, dir string, env ...string) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "server")
+	build := exec.Command("go", "build", "-o", binary, ".")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOFLAGS=-mod=readonly")
+	if output, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("building %s failed: %v\n%s", dir, err, output)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	server := exec.Command(binary)
+	server.Env = append(append(os.Environ(), env...), fmt.Sprintf("PORT=%d", port))
+	if err := server.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		server.Process.Kill()
+		server.Wait()
+	})
+
+	serverURL := fmt.Sprintf("http://127.0.0.1:%d", port)
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+		if response, err := http.Get(serverURL); err == nil {
+			response.Body.Close()
+			return serverURL
+		}
+	}
+	t.Fatalf("server in %s did not start", dir)
+	return ""
+}
+
+// request sends a JSON body, or none when body is nil, and decodes the response expecting status
+func request(t *testing.T, method string, requestURL string, body any, status int) map[string]any {
+	t.Helper()
+	var payload bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&payload).Encode(body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	req, err := http.NewRequest(method, requestURL, &payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	response, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer response.Body.Close()
+	var buf bytes.Buffer
+	buf.ReadFrom(response.Body)
+	if response.StatusCode != status {
+		t.Fatalf("%s %s answered %d: %s", method, requestURL, response.StatusCode, buf.String())
+	}
+	decoded := make(map[string]any)
+	json.Unmarshal(buf.Bytes(), &decoded)
+	return decoded
+}
+
+// balanceOn returns the balance of a wallet on a node
+func balanceOn(t *testing.T, nodeURL string, wallet string) float64 {
+	t.Helper()
+	info := request(t, http.MethodGet, nodeURL+"/info?wallet="+url.QueryEscape(wallet), nil, http.StatusOK)
+	return info["balance"].(float64)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const HTLC_ESCROW_WALLET string = "HTLC Escrow"
+
+// HashLock represents coins locked for a recipient until the preimage of Hashlock is revealed
+// The coins can be refunded to the sender from RefundHeight. The hashlock identifies the lock
+type HashLock struct {
+	Hashlock     string  `json:"hashlock"` // Hex encoded SHA256 digest of the preimage
+	From         string  `json:"from"`
+	To           string  `json:"to"`
+	Amount       float64 `json:"amount"`
+	RefundHeight int     `json:"refund_height"`
+	Signature    string  `json:"signature"`
+}
+
+// HashLockSettlement represents the claim of a lock by revealing its preimage, or its refund
+type HashLockSettlement struct {
+	Hashlock string `json:"hashlock"`
+	Preimage string `json:"preimage"` // Hex encoded, empty for a refund
+	Refund   bool   `json:"refund"`
+}
+
+// HashLockStatus reports the state of a lock
+type HashLockStatus struct {
+	HashLock
+	Status   string `json:"status"` // locked, claimed or refunded
+	Preimage string `json:"preimage"`
+}
+
+// signingMessage returns the message the sender signs to authorise the lock
+func (l HashLock) signingMessage() string {
+	return signingMessage("htlc", l.To, strconv.FormatFloat(l.Amount, 'f', -1, 64), l.Hashlock, strconv.Itoa(l.RefundHeight))
+}
+
+// findHashLock returns a lock stored on the chain and its settlement, if any
+func (bc *Blockchain) findHashLock(hashlock string) (*HashLock, *HashLockSettlement) {
+	hashlock = strings.ToLower(hashlock)
+	var lock *HashLock
+	var settlement *HashLockSettlement
+	for i := range bc.Chain {
+		data := &bc.Chain[i].Data
+		for j := range data.HashLocks {
+			if data.HashLocks[j].Hashlock == hashlock {
+				lock = &data.HashLocks[j]
+			}
+		}
+		for j := range data.HashLockSettlements {
+			if data.HashLockSettlements[j].Hashlock == hashlock {
+				settlement = &data.HashLockSettlements[j]
+			}
+		}
+	}
+	return lock, settlement
+}
+
+// checkHashLock checks a lock can be stored in the current block
+func (bc *Blockchain) checkHashLock(lock HashLock) error {
+	if existing, _ := bc.findHashLock(lock.Hashlock); existing != nil {
+		return fmt.Errorf("hashlock already used")
+	}
+	if lock.To == "" || lock.To == lock.From || lock.To == HTLC_ESCROW_WALLET || lock.To == BLOCK_REWARD_WALLET {
+		return fmt.Errorf("invalid recipient")
+	}
+	if lock.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	if lock.RefundHeight <= bc.height() {
+		return fmt.Errorf("refund height must be after the current block %d", bc.height())
+	}
+	return nil
+}
+
+// lockHash adds a lock to the hash lock pool after validating it
+func (bc *Blockchain) lockHash(lock HashLock) (HashLock, error) {
+	if digest, err := hex.DecodeString(lock.Hashlock); err != nil || len(digest) != sha256.Size {
+		return lock, fmt.Errorf("hashlock must be a hex encoded SHA256 digest")
+	}
+	// The hashlock is stored, signed and compared to claimed digests in lowercase
+	lock.Hashlock = strings.ToLower(lock.Hashlock)
+	if err := verifySignature(lock.From, lock.signingMessage(), lock.Signature); err != nil {
+		return lock, fmt.Errorf("lock signature verification failed: %v", err)
+	}
+	if err := bc.checkHashLock(lock); err != nil {
+		return lock, err
+	}
+	for _, pending := range bc.HashLockPool {
+		if pending.Hashlock == lock.Hashlock {
+			return lock, fmt.Errorf("hashlock already used")
+		}
+	}
+
+	// The amount is reserved from the sender's balance until the lock is mined
+	if bc.getBalance(lock.From) < lock.Amount {
+		return lock, fmt.Errorf("insufficient balance to lock the amount")
+	}
+
+	bc.HashLockPool = append(bc.HashLockPool, lock)
+	return lock, nil
+}
+
+// mineHashLock mines locks from the hash lock pool into the current block
+// The amount is transferred from the sender to the HTLC escrow
+func (bc *Blockchain) mineHashLock() (HashLock, error) {
+	if len(bc.HashLockPool) == 0 {
+		return HashLock{}, fmt.Errorf("no hash locks to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first lock in the pool (FIFO) and remove it from the pool,
+	// releasing the amount reserved for it
+	lock := bc.HashLockPool[0]
+	bc.HashLockPool = bc.HashLockPool[1:]
+
+	// The refund height may have been reached and the balance spent since the lock was added to the pool
+	if err := bc.checkHashLock(lock); err != nil {
+		return HashLock{}, fmt.Errorf("hash lock %s is no longer valid: %v", lock.Hashlock, err)
+	}
+	if bc.getBalance(lock.From) < lock.Amount {
+		return HashLock{}, fmt.Errorf("sender cannot afford hash lock %s", lock.Hashlock)
+	}
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   lock.From,
+		To:     HTLC_ESCROW_WALLET,
+		Amount: lock.Amount,
+	})
+	lastBlock.Data.HashLocks = append(lastBlock.Data.HashLocks, lock)
+	return lock, nil
+}
+
+// checkHashLockSettlement checks a settlement can be stored in the current block: a claim
+// with a preimage before the refund height, or a refund from the refund height
+// It returns the settled lock and the settlement as it is stored
+func (bc *Blockchain) checkHashLockSettlement(settlement HashLockSettlement) (*HashLock, HashLockSettlement, error) {
+	lock, settled := bc.findHashLock(settlement.Hashlock)
+	if lock == nil {
+		return nil, settlement, fmt.Errorf("hash lock not found")
+	}
+	if settled != nil {
+		return lock, settlement, fmt.Errorf("hash lock already settled")
+	}
+	settlement.Hashlock = lock.Hashlock
+
+	height := bc.height()
+	if settlement.Refund {
+		if height < lock.RefundHeight {
+			return lock, settlement, fmt.Errorf("hash lock can only be refunded from block %d", lock.RefundHeight)
+		}
+		settlement.Preimage = ""
+		return lock, settlement, nil
+	}
+	if height >= lock.RefundHeight {
+		return lock, settlement, fmt.Errorf("hash lock expired at block %d", lock.RefundHeight)
+	}
+	preimage, err := hex.DecodeString(settlement.Preimage)
+	if err != nil {
+		return lock, settlement, fmt.Errorf("preimage must be hex encoded")
+	}
+	digest := sha256.Sum256(preimage)
+	if hex.EncodeToString(digest[:]) != lock.Hashlock {
+		return lock, settlement, fmt.Errorf("preimage does not match the hashlock")
+	}
+	return lock, settlement, nil
+}
+
+// settleHashLock adds the claim or the refund of a lock to the settlement pool after validating it
+func (bc *Blockchain) settleHashLock(settlement HashLockSettlement) (HashLock, error) {
+	lock, settlement, err := bc.checkHashLockSettlement(settlement)
+	if err != nil {
+		if lock == nil {
+			return HashLock{}, err
+		}
+		return *lock, err
+	}
+	// A lock is settled once, the first settlement added to the pool wins
+	for _, pending := range bc.HashLockSettlementPool {
+		if pending.Hashlock == settlement.Hashlock {
+			return *lock, fmt.Errorf("hash lock already being settled")
+		}
+	}
+
+	bc.HashLockSettlementPool = append(bc.HashLockSettlementPool, settlement)
+	return *lock, nil
+}
+
+// mineHashLockSettlement mines settlements from the settlement pool into the current block
+// The amount is transferred from the HTLC escrow to the recipient, or back to the sender
+func (bc *Blockchain) mineHashLockSettlement() (HashLock, error) {
+	if len(bc.HashLockSettlementPool) == 0 {
+		return HashLock{}, fmt.Errorf("no hash lock settlements to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first settlement in the pool (FIFO) and remove it from the pool
+	settlement := bc.HashLockSettlementPool[0]
+	bc.HashLockSettlementPool = bc.HashLockSettlementPool[1:]
+
+	// A claim may have reached the refund height since it was added to the pool
+	lock, settlement, err := bc.checkHashLockSettlement(settlement)
+	if err != nil {
+		return HashLock{}, fmt.Errorf("settlement of hash lock %s is no longer valid: %v", settlement.Hashlock, err)
+	}
+
+	to := lock.To
+	if settlement.Refund {
+		to = lock.From
+	}
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   HTLC_ESCROW_WALLET,
+		To:     to,
+		Amount: lock.Amount,
+	})
+	lastBlock.Data.HashLockSettlements = append(lastBlock.Data.HashLockSettlements, settlement)
+	return *lock, nil
+}
+
+// getHashLockStatus reports the state of a lock
+func (bc *Blockchain) getHashLockStatus(lock HashLock) HashLockStatus {
+	status := HashLockStatus{HashLock: lock, Status: "locked"}
+	if _, settlement := bc.findHashLock(lock.Hashlock); settlement != nil {
+		status.Status = "claimed"
+		status.Preimage = settlement.Preimage
+		if settlement.Refund {
+			status.Status = "refunded"
+		}
+	}
+	return status
+}
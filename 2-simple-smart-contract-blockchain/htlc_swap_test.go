@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAtomicSwapBetweenTheTwoNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs both nodes")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the nodes")
+	}
+	first := startServer(t, filepath.Join("..", "1-simple-transactional-blockchain"))
+	second := startServer(t, ".")
+
+	// Lucas holds coins on the first node and Filipe on the second
+	lucas, filipe := newTestWallet(t), newTestWallet(t)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	request(t, http.MethodGet, second+"/mine/block?wallet="+url.QueryEscape(filipe.ID), nil, http.StatusOK)
+	if balanceOn(t, second, filipe.ID) < 5 {
+		t.Fatalf("Filipe holds %v on the second node", balanceOn(t, second, filipe.ID))
+	}
+
+	// Lucas locks 10 coins for Filipe on the first node, with the hashlock in uppercase
+	preimage := hex.EncodeToString([]byte("atomic swap"))
+	digest := sha256.Sum256([]byte("atomic swap"))
+	hashlock := hex.EncodeToString(digest[:])
+	request(t, http.MethodPost, first+"/htlc/new", map[string]any{
+		"from": lucas.ID, "to": filipe.ID, "amount": 10, "hashlock": strings.ToUpper(hashlock), "refund_height": 20,
+	}, http.StatusCreated)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+
+	// Filipe counter-locks 5 coins for Lucas on the second node with a nearer refund height
+	status := request(t, http.MethodGet, first+"/htlc/"+hashlock, nil, http.StatusOK)
+	if status["status"] != "locked" {
+		t.Fatalf("first node reports the lock %v", status)
+	}
+	counter := HashLock{Hashlock: hashlock, From: filipe.ID, To: lucas.ID, Amount: 5, RefundHeight: 10}
+	counter.Signature = filipe.sign(t, counter.signingMessage())
+	request(t, http.MethodPost, second+"/htlc/new", counter, http.StatusCreated)
+	request(t, http.MethodGet, second+"/mine/hashlock", nil, http.StatusOK)
+	request(t, http.MethodGet, second+"/mine/block?wallet="+url.QueryEscape(filipe.ID), nil, http.StatusOK)
+
+	// Lucas claims on the second node, revealing the preimage
+	request(t, http.MethodPost, second+"/htlc/claim", HashLockSettlement{Hashlock: hashlock, Preimage: preimage}, http.StatusOK)
+	request(t, http.MethodGet, second+"/mine/settlement", nil, http.StatusOK)
+	if balance := balanceOn(t, second, lucas.ID); balance != 5 {
+		t.Fatalf("Lucas holds %v on the second node", balance)
+	}
+
+	// Filipe reads the preimage from the second node and claims on the first
+	status = request(t, http.MethodGet, second+"/htlc/"+hashlock, nil, http.StatusOK)
+	revealed := status["lock"].(map[string]any)["preimage"].(string)
+	if revealed != preimage {
+		t.Fatalf("second node revealed %q", revealed)
+	}
+	request(t, http.MethodPost, first+"/htlc/claim", map[string]any{"hashlock": hashlock, "preimage": revealed}, http.StatusCreated)
+	request(t, http.MethodPost, first+"/htlc/refund", map[string]any{"hashlock": hashlock}, http.StatusForbidden)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	status = request(t, http.MethodGet, first+"/htlc/"+hashlock, nil, http.StatusOK)
+	if status["status"] != "claimed" {
+		t.Fatalf("first node reports the lock %v", status)
+	}
+	if balance := balanceOn(t, first, filipe.ID); balance != 10 {
+		t.Fatalf("Filipe holds %v on the first node", balance)
+	}
+}
+
+func TestAtomicSwapAtTheRefundHeightOfBothNodes(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs both nodes")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the nodes")
+	}
+	first := startServer(t, filepath.Join("..", "1-simple-transactional-blockchain"))
+	second := startServer(t, ".")
+	lucas, filipe := newTestWallet(t), newTestWallet(t)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	request(t, http.MethodGet, second+"/mine/block?wallet="+url.QueryEscape(filipe.ID), nil, http.StatusOK)
+
+	height := func(node string) int {
+		return int(request(t, http.MethodGet, node+"/chain", nil, http.StatusOK)["height"].(float64))
+	}
+	hashlock := func(preimage string) string {
+		digest := sha256.Sum256([]byte(preimage))
+		return hex.EncodeToString(digest[:])
+	}
+	claimed, expired := hashlock("claimed"), hashlock("expired")
+
+	// Each node holds a lock claimed in the block before its refund height and one refunded at it
+	firstRefund := height(first) + 2
+	for _, h := range []string{claimed, expired} {
+		request(t, http.MethodPost, first+"/htlc/new", map[string]any{
+			"from": lucas.ID, "to": filipe.ID, "amount": 2, "hashlock": h, "refund_height": firstRefund,
+		}, http.StatusCreated)
+	}
+	request(t, http.MethodGet, first+"/mine?wallet="+url.QueryEscape(lucas.ID), nil, http.StatusOK)
+	secondRefund := height(second) + 2
+	for _, h := range []string{claimed, expired} {
+		lock := HashLock{Hashlock: h, From: filipe.ID, To: lucas.ID, Amount: 2, RefundHeight: secondRefund}
+		lock.Signature = filipe.sign(t, lock.signingMessage())
+		request(t, http.MethodPost, second+"/htlc/new", lock, http.StatusCreated)
+		request(t, http.MethodGet, second+"/mine/hashlock", nil, http.StatusOK)
+	}
+	request(t, http.MethodGet, second+"/mine/block?wallet="+url.QueryEscape(filipe.ID), nil, http.StatusOK)
+
+	nodes := []struct {
+		name    string
+		url     string
+		refund  int
+		settled int      // Status answered to an accepted claim or refund
+		mine    []string // Routes mining the settlements into a block and opening the next one
+		status  func(lock map[string]any) any
+	}{
+		{"first", first, firstRefund, http.StatusCreated, []string{"/mine?wallet=" + url.QueryEscape(lucas.ID)},
+			func(lock map[string]any) any { return lock["status"] }},
+		{"second", second, secondRefund, http.StatusOK, []string{"/mine/settlement", "/mine/block?wallet=" + url.QueryEscape(filipe.ID)},
+			func(lock map[string]any) any { return lock["lock"].(map[string]any)["status"] }},
+	}
+	for _, node := range nodes {
+		mine := func() {
+			for _, route := range node.mine {
+				request(t, http.MethodGet, node.url+route, nil, http.StatusOK)
+			}
+		}
+
+		if got := int(request(t, http.MethodGet, node.url+"/htlc/"+claimed, nil, http.StatusOK)["height"].(float64)); got != node.refund-1 {
+			t.Fatalf("%s node is at height %d, expected %d", node.name, got, node.refund-1)
+		}
+		request(t, http.MethodPost, node.url+"/htlc/refund", map[string]any{"hashlock": expired}, http.StatusForbidden)
+		request(t, http.MethodPost, node.url+"/htlc/claim", map[string]any{"hashlock": claimed, "preimage": hex.EncodeToString([]byte("claimed"))}, node.settled)
+		mine()
+
+		if height(node.url) != node.refund {
+			t.Fatalf("%s node is at height %d, expected %d", node.name, height(node.url), node.refund)
+		}
+		request(t, http.MethodPost, node.url+"/htlc/claim", map[string]any{"hashlock": expired, "preimage": hex.EncodeToString([]byte("expired"))}, http.StatusForbidden)
+		request(t, http.MethodPost, node.url+"/htlc/refund", map[string]any{"hashlock": expired}, node.settled)
+		mine()
+
+		for h, want := range map[string]string{claimed: "claimed", expired: "refunded"} {
+			if got := node.status(request(t, http.MethodGet, node.url+"/htlc/"+h, nil, http.StatusOK)); got != want {
+				t.Fatalf("%s node reports the lock %v, expected %s", node.name, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// lockCoins locks 2 coins from the sender for the recipient under the SHA256 of preimage
+func lockCoins(t *testing.T, bc *Blockchain, from *testWallet, to *testWallet, preimage string, refundHeight int) HashLock {
+	t.Helper()
+	digest := sha256.Sum256([]byte(preimage))
+	lock := HashLock{Hashlock: hex.EncodeToString(digest[:]), From: from.ID, To: to.ID, Amount: 2, RefundHeight: refundHeight}
+	lock.Signature = from.sign(t, lock.signingMessage())
+	lock, err := bc.lockHash(lock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineHashLock(); err != nil {
+		t.Fatal(err)
+	}
+	return lock
+}
+
+// settleCoins adds a settlement to the settlement pool and mines it
+func settleCoins(bc *Blockchain, settlement HashLockSettlement) (HashLock, error) {
+	if _, err := bc.settleHashLock(settlement); err != nil {
+		return HashLock{}, err
+	}
+	return bc.mineHashLockSettlement()
+}
+
+func TestHashLockIsClaimedBeforeTheRefundHeight(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	refundHeight := len(bc.Chain) + 2
+	lock := lockCoins(t, bc, alice, bob, "swap", refundHeight)
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Refund: true}); err == nil {
+		t.Fatal("lock refunded before its refund height")
+	}
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Preimage: hex.EncodeToString([]byte("other"))}); err == nil {
+		t.Fatal("lock claimed with the wrong preimage")
+	}
+
+	mineUntil(t, bc, alice.ID, refundHeight-1)
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Preimage: hex.EncodeToString([]byte("swap"))}); err != nil {
+		t.Fatalf("claim in the last block before the refund height failed: %v", err)
+	}
+	if bc.getBalance(bob.ID) != 2 {
+		t.Fatalf("recipient holds %v", bc.getBalance(bob.ID))
+	}
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Refund: true}); err == nil {
+		t.Fatal("claimed lock refunded")
+	}
+	mineUntil(t, bc, alice.ID, refundHeight)
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Refund: true}); err == nil {
+		t.Fatal("claimed lock refunded at its refund height")
+	}
+	if status := bc.getHashLockStatus(lock); status.Status != "claimed" || status.Preimage != hex.EncodeToString([]byte("swap")) {
+		t.Fatalf("lock status %+v", status)
+	}
+}
+
+func TestHashLockIsRefundedFromTheRefundHeight(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	refundHeight := len(bc.Chain) + 1
+	lock := lockCoins(t, bc, alice, bob, "swap", refundHeight)
+	mineUntil(t, bc, alice.ID, refundHeight)
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Preimage: hex.EncodeToString([]byte("swap"))}); err == nil {
+		t.Fatal("lock claimed at its refund height")
+	}
+
+	before := bc.getBalance(alice.ID)
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Refund: true}); err != nil {
+		t.Fatalf("refund at the refund height failed: %v", err)
+	}
+	if refunded := bc.getBalance(alice.ID) - before; refunded != 2 {
+		t.Fatalf("sender was refunded %v", refunded)
+	}
+	if bc.getBalance(bob.ID) != 0 {
+		t.Fatalf("recipient holds %v", bc.getBalance(bob.ID))
+	}
+	if status := bc.getHashLockStatus(lock); status.Status != "refunded" {
+		t.Fatalf("lock status %+v", status)
+	}
+}
+
+func TestHashLockIsStoredInLowercase(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	digest := sha256.Sum256([]byte("swap"))
+	lock := HashLock{Hashlock: hex.EncodeToString(digest[:]), From: alice.ID, To: bob.ID, Amount: 2, RefundHeight: len(bc.Chain) + 5}
+	lock.Signature = alice.sign(t, lock.signingMessage())
+	lock.Hashlock = strings.ToUpper(lock.Hashlock)
+	stored, err := bc.lockHash(lock)
+	if err != nil {
+		t.Fatalf("uppercase hashlock signed in lowercase was rejected: %v", err)
+	}
+	if _, err := bc.mineHashLock(); err != nil {
+		t.Fatal(err)
+	}
+	if stored.Hashlock != hex.EncodeToString(digest[:]) {
+		t.Fatalf("hashlock stored as %s", stored.Hashlock)
+	}
+	if found, _ := bc.findHashLock(lock.Hashlock); found == nil {
+		t.Fatal("lock not found by its uppercase hashlock")
+	}
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Preimage: hex.EncodeToString([]byte("swap"))}); err != nil {
+		t.Fatalf("claim by the uppercase hashlock failed: %v", err)
+	}
+}
+
+func TestHashLockSettlementIsCheckedAgainWhenMined(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	// The amount of a pending lock is reserved from the sender
+	balance := bc.getBalance(alice.ID)
+	digest := sha256.Sum256([]byte("pending"))
+	pending := HashLock{Hashlock: hex.EncodeToString(digest[:]), From: alice.ID, To: bob.ID, Amount: balance, RefundHeight: len(bc.Chain) + 5}
+	pending.Signature = alice.sign(t, pending.signingMessage())
+	if _, err := bc.lockHash(pending); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.lockHash(pending); err == nil {
+		t.Fatal("pending hashlock was locked twice")
+	}
+	if bc.getBalance(alice.ID) != 0 {
+		t.Fatalf("sender holds %v with a pending lock", bc.getBalance(alice.ID))
+	}
+	if _, err := bc.mineHashLock(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A claim added in the last block before the refund height expires when mined at it
+	mineBlocks(t, bc, alice.ID, 1)
+	refundHeight := len(bc.Chain) + 1
+	lock := lockCoins(t, bc, alice, bob, "swap", refundHeight)
+	claim := HashLockSettlement{Hashlock: lock.Hashlock, Preimage: hex.EncodeToString([]byte("swap"))}
+	mineUntil(t, bc, alice.ID, refundHeight-1)
+	if _, err := bc.settleHashLock(claim); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.settleHashLock(claim); err == nil || !strings.Contains(err.Error(), "already being settled") {
+		t.Fatalf("second settlement of a lock was added: %v", err)
+	}
+	mineUntil(t, bc, alice.ID, refundHeight)
+	if _, err := bc.mineHashLockSettlement(); err == nil || !strings.Contains(err.Error(), "expired") {
+		t.Fatalf("claim was mined at the refund height: %v", err)
+	}
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Refund: true}); err != nil {
+		t.Fatalf("refund after the dropped claim failed: %v", err)
+	}
+}
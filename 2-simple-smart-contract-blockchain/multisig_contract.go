@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const CONTRACT_MULTISIG_TYPE string = "multisig"
+
+func init() {
+	registerCode(CONTRACT_MULTISIG_TYPE, func() Code { return &ContractCodeMultisig{} })
+}
+
+// MultisigSpecification is the specification of a multi-signature wallet, given as JSON at deployment
+// Proposals expire Expiry blocks after being proposed
+type MultisigSpecification struct {
+	Owners    []string `json:"owners"`
+	Threshold int      `json:"threshold"`
+	Expiry    int      `json:"expiry"`
+}
+
+// MultisigProposal is a transfer proposed by an owner of a multi-signature wallet
+type MultisigProposal struct {
+	ProposalID    int      `json:"proposal_id"`
+	Proposer      string   `json:"proposer"`
+	To            string   `json:"to"`
+	Amount        float64  `json:"amount"`
+	Confirmations []string `json:"confirmations"`
+	ProposedAt    int      `json:"proposed_at"`
+	ExpiresAt     int      `json:"expires_at"`
+	ExecutedAt    int      `json:"executed_at"` // 0 until the transfer is executed
+}
+
+// MultisigProposalStatus reports a proposal with its status at the current block
+type MultisigProposalStatus struct {
+	MultisigProposal
+	Status string `json:"status"`
+}
+
+// ContractCodeMultisig implements a wallet whose coins are transferred only when a threshold
+// of its owners confirm the proposed transfer before it expires
+type ContractCodeMultisig struct {
+	Owners    []string            `json:"owners"`
+	Threshold int                 `json:"threshold"`
+	Expiry    int                 `json:"expiry"`
+	Proposals []*MultisigProposal `json:"proposals"`
+}
+
+// Initialize configures the owners, threshold and proposal expiry of the wallet
+func (sc *ContractCodeMultisig) Initialize(owner string, specification string) error {
+	var spec MultisigSpecification
+	if err := json.Unmarshal([]byte(specification), &spec); err != nil {
+		return fmt.Errorf("multisig specification must be JSON: %v", err)
+	}
+	sc.Owners = spec.Owners
+	sc.Threshold = spec.Threshold
+	sc.Expiry = spec.Expiry
+	sc.Proposals = []*MultisigProposal{}
+	return nil
+}
+
+func (sc *ContractCodeMultisig) Validate(chain ChainReader) bool {
+	seen := make(map[string]bool, len(sc.Owners))
+	for _, owner := range sc.Owners {
+		if owner == "" || seen[owner] {
+			return false
+		}
+		seen[owner] = true
+	}
+	return sc.Threshold >= 1 && sc.Threshold <= len(sc.Owners) && sc.Expiry > 0
+}
+
+func (sc *ContractCodeMultisig) ABI() ContractABI {
+	proposalID := ParamABI{Name: "proposal_id", Type: "integer"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "owners", Params: []ParamABI{}, Returns: "array", ReadOnly: true},
+			{Name: "threshold", Params: []ParamABI{}, Returns: "integer", ReadOnly: true},
+			{Name: "proposal", Params: []ParamABI{proposalID}, Returns: "object", ReadOnly: true},
+			{Name: "proposals", Params: []ParamABI{}, Returns: "array", ReadOnly: true},
+			{Name: "deposit", Params: []ParamABI{}, Returns: "number", Payable: true},
+			{Name: "propose", Params: []ParamABI{{Name: "to", Type: "address"}, {Name: "amount", Type: "number"}}, Returns: "integer"},
+			{Name: "confirm", Params: []ParamABI{proposalID}, Returns: "string"},
+			{Name: "revoke", Params: []ParamABI{proposalID}, Returns: "integer"},
+		},
+		Events: []EventABI{
+			{Name: "Deposit", Topics: []string{"sender"}, Payload: []ParamABI{{Name: "amount", Type: "number"}}},
+			{Name: "Proposed", Topics: []string{"proposal_id", "proposer"}, Payload: []ParamABI{{Name: "to", Type: "address"}, {Name: "amount", Type: "number"}, {Name: "expires_at", Type: "integer"}}},
+			{Name: "Confirmed", Topics: []string{"proposal_id", "owner"}, Payload: []ParamABI{{Name: "confirmations", Type: "integer"}}},
+			{Name: "Revoked", Topics: []string{"proposal_id", "owner"}, Payload: []ParamABI{{Name: "confirmations", Type: "integer"}}},
+			{Name: "Executed", Topics: []string{"proposal_id", "to"}, Payload: []ParamABI{{Name: "amount", Type: "number"}}},
+		},
+	}
+}
+
+func (sc *ContractCodeMultisig) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "owners":
+		return marshalResult(sc.Owners)
+	case "threshold":
+		return strconv.Itoa(sc.Threshold), nil
+	case "proposal":
+		proposal, err := sc.proposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		return marshalResult(sc.status(ctx, proposal))
+	case "proposals":
+		statuses := make([]MultisigProposalStatus, 0, len(sc.Proposals))
+		for _, proposal := range sc.Proposals {
+			statuses = append(statuses, sc.status(ctx, proposal))
+		}
+		return marshalResult(statuses)
+	case "deposit":
+		if ctx.Value() <= 0 {
+			return "", fmt.Errorf("deposit must attach value")
+		}
+		if err := ctx.Emit("Deposit", []string{ctx.Caller()}, map[string]float64{"amount": ctx.Value()}); err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(ctx.Balance(ctx.ContractID()), 'f', -1, 64), nil
+	}
+
+	// The remaining methods are restricted to the owners
+	if !ctx.Authenticated() || !sc.isOwner(ctx.Caller()) {
+		return "", fmt.Errorf("method %s requires a signed execution by an owner", ctx.Method())
+	}
+
+	switch ctx.Method() {
+	case "propose":
+		proposal := &MultisigProposal{
+			ProposalID:    len(sc.Proposals) + 1,
+			Proposer:      ctx.Caller(),
+			Confirmations: []string{},
+			ProposedAt:    ctx.BlockHeight(),
+			ExpiresAt:     ctx.BlockHeight() + sc.Expiry,
+		}
+		if err := ctx.Arg("to", &proposal.To); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("amount", &proposal.Amount); err != nil {
+			return "", err
+		}
+		if proposal.To == "" || proposal.To == ctx.ContractID() {
+			return "", fmt.Errorf("invalid recipient")
+		}
+		if proposal.Amount <= 0 {
+			return "", fmt.Errorf("amount must be positive")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		sc.Proposals = append(sc.Proposals, proposal)
+		err := ctx.Emit("Proposed", []string{strconv.Itoa(proposal.ProposalID), proposal.Proposer}, map[string]any{
+			"to":         proposal.To,
+			"amount":     proposal.Amount,
+			"expires_at": proposal.ExpiresAt,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		// The proposer confirms its own proposal
+		if err := sc.confirm(ctx, proposal); err != nil {
+			return "", err
+		}
+		return strconv.Itoa(proposal.ProposalID), nil
+	case "confirm":
+		proposal, err := sc.pendingProposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err := sc.confirm(ctx, proposal); err != nil {
+			return "", err
+		}
+		return sc.status(ctx, proposal).Status, nil
+	case "revoke":
+		proposal, err := sc.pendingProposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		index := sort.SearchStrings(proposal.Confirmations, ctx.Caller())
+		if index == len(proposal.Confirmations) || proposal.Confirmations[index] != ctx.Caller() {
+			return "", fmt.Errorf("proposal %d is not confirmed by the caller", proposal.ProposalID)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		proposal.Confirmations = append(proposal.Confirmations[:index], proposal.Confirmations[index+1:]...)
+		err = ctx.Emit("Revoked", []string{strconv.Itoa(proposal.ProposalID), ctx.Caller()}, map[string]int{
+			"confirmations": len(proposal.Confirmations),
+		})
+		return strconv.Itoa(len(proposal.Confirmations)), err
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// isOwner checks if a wallet is an owner of the multi-signature wallet
+func (sc *ContractCodeMultisig) isOwner(wallet string) bool {
+	for _, owner := range sc.Owners {
+		if owner == wallet {
+			return true
+		}
+	}
+	return false
+}
+
+// proposal returns the proposal identified by the proposal_id argument
+func (sc *ContractCodeMultisig) proposal(ctx ExecutionContext) (*MultisigProposal, error) {
+	var proposalID int
+	if err := ctx.Arg("proposal_id", &proposalID); err != nil {
+		return nil, err
+	}
+	if proposalID < 1 || proposalID > len(sc.Proposals) {
+		return nil, fmt.Errorf("proposal %d not found", proposalID)
+	}
+	return sc.Proposals[proposalID-1], nil
+}
+
+// pendingProposal returns the proposal identified by the proposal_id argument when it can
+// still be confirmed
+func (sc *ContractCodeMultisig) pendingProposal(ctx ExecutionContext) (*MultisigProposal, error) {
+	proposal, err := sc.proposal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if status := sc.status(ctx, proposal).Status; status != "pending" {
+		return nil, fmt.Errorf("proposal %d is %s", proposal.ProposalID, status)
+	}
+	return proposal, nil
+}
+
+// status reports a proposal as pending, executed or expired at the current block
+func (sc *ContractCodeMultisig) status(ctx ExecutionContext, proposal *MultisigProposal) MultisigProposalStatus {
+	status := MultisigProposalStatus{MultisigProposal: *proposal, Status: "pending"}
+	if proposal.ExecutedAt > 0 {
+		status.Status = "executed"
+	} else if ctx.BlockHeight() > proposal.ExpiresAt {
+		status.Status = "expired"
+	}
+	return status
+}
+
+// confirm adds the confirmation of the caller to a proposal and executes its transfer once
+// the threshold is reached
+func (sc *ContractCodeMultisig) confirm(ctx ExecutionContext, proposal *MultisigProposal) error {
+	index := sort.SearchStrings(proposal.Confirmations, ctx.Caller())
+	if index < len(proposal.Confirmations) && proposal.Confirmations[index] == ctx.Caller() {
+		return fmt.Errorf("proposal %d already confirmed by the caller", proposal.ProposalID)
+	}
+	if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+		return err
+	}
+	proposal.Confirmations = append(proposal.Confirmations, ctx.Caller())
+	sort.Strings(proposal.Confirmations)
+	err := ctx.Emit("Confirmed", []string{strconv.Itoa(proposal.ProposalID), ctx.Caller()}, map[string]int{
+		"confirmations": len(proposal.Confirmations),
+	})
+	if err != nil || len(proposal.Confirmations) < sc.Threshold {
+		return err
+	}
+
+	if err := ctx.Transfer(proposal.To, proposal.Amount); err != nil {
+		return fmt.Errorf("proposal %d could not be executed: %v", proposal.ProposalID, err)
+	}
+	proposal.ExecutedAt = ctx.BlockHeight()
+	return ctx.Emit("Executed", []string{strconv.Itoa(proposal.ProposalID), proposal.To}, map[string]float64{
+		"amount": proposal.Amount,
+	})
+}
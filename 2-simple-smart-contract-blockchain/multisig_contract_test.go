@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// deployMultisig deploys a wallet of the owners funded with 10 coins by the first owner
+func deployMultisig(t *testing.T, bc *Blockchain, owners []*testWallet, threshold int, expiry int) string {
+	t.Helper()
+	ids := make([]string, len(owners))
+	for i, owner := range owners {
+		ids[i] = owner.ID
+	}
+	spec, err := json.Marshal(MultisigSpecification{Owners: ids, Threshold: threshold, Expiry: expiry})
+	if err != nil {
+		t.Fatal(err)
+	}
+	multisig := deployContract(t, bc, owners[0], CONTRACT_MULTISIG_TYPE, string(spec))
+	mustSucceed(t, executeContract(t, bc, owners[0], multisig, "deposit", nil, 10))
+	return multisig
+}
+
+// proposalStatus returns the status of a proposal at the open block
+func proposalStatus(t *testing.T, bc *Blockchain, multisig string, proposalID string) MultisigProposalStatus {
+	t.Helper()
+	var status MultisigProposalStatus
+	if err := json.Unmarshal([]byte(callView(t, bc, multisig, "proposal", map[string]any{"proposal_id": json.RawMessage(proposalID)})), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func TestMultisigExecutesOnceTheThresholdIsReached(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol, dave, erin := newTestWallet(t), newTestWallet(t), newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, carol, dave} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	multisig := deployMultisig(t, bc, []*testWallet{alice, bob, carol}, 2, 10)
+
+	mustFail(t, executeContract(t, bc, dave, multisig, "propose", map[string]any{"to": erin.ID, "amount": 4}, 0))
+	proposalID := mustSucceed(t, executeContract(t, bc, alice, multisig, "propose", map[string]any{"to": erin.ID, "amount": 4}, 0))
+	confirm := map[string]any{"proposal_id": json.RawMessage(proposalID)}
+
+	// The proposer confirmed the proposal, a second confirmation by the same owner is not counted
+	mustFail(t, executeContract(t, bc, alice, multisig, "confirm", confirm, 0))
+	mustFail(t, executeContract(t, bc, dave, multisig, "confirm", confirm, 0))
+	if status := proposalStatus(t, bc, multisig, proposalID); status.Status != "pending" || len(status.Confirmations) != 1 {
+		t.Fatalf("proposal reports %+v before the threshold", status)
+	}
+	if balance := bc.getBalance(erin.ID); balance != 0 {
+		t.Fatalf("erin received %v before the threshold", balance)
+	}
+
+	executed := executeContract(t, bc, bob, multisig, "confirm", confirm, 0)
+	if result := mustSucceed(t, executed); result != "executed" {
+		t.Fatalf("confirmation reaching the threshold returned %s", result)
+	}
+	if len(executed.Transfers) != 1 || executed.Transfers[0].To != erin.ID || executed.Transfers[0].Amount != 4 {
+		t.Fatalf("confirmation transferred %+v", executed.Transfers)
+	}
+	if bc.getBalance(erin.ID) != 4 || bc.getBalance(multisig) != 6 {
+		t.Fatalf("erin holds %v and the multisig %v", bc.getBalance(erin.ID), bc.getBalance(multisig))
+	}
+
+	// An executed proposal is not executed again
+	mustFail(t, executeContract(t, bc, carol, multisig, "confirm", confirm, 0))
+	mustFail(t, executeContract(t, bc, bob, multisig, "revoke", confirm, 0))
+	if balance := bc.getBalance(erin.ID); balance != 4 {
+		t.Fatalf("erin holds %v after the proposal was confirmed again", balance)
+	}
+}
+
+func TestMultisigRevokedConfirmationsDoNotCount(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol, dave := newTestWallet(t), newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, carol} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	multisig := deployMultisig(t, bc, []*testWallet{alice, bob, carol}, 3, 10)
+	proposalID := mustSucceed(t, executeContract(t, bc, alice, multisig, "propose", map[string]any{"to": dave.ID, "amount": 4}, 0))
+	confirm := map[string]any{"proposal_id": json.RawMessage(proposalID)}
+
+	mustSucceed(t, executeContract(t, bc, bob, multisig, "confirm", confirm, 0))
+	mustFail(t, executeContract(t, bc, carol, multisig, "revoke", confirm, 0))
+	if remaining := mustSucceed(t, executeContract(t, bc, bob, multisig, "revoke", confirm, 0)); remaining != "1" {
+		t.Fatalf("revoke left %s confirmations", remaining)
+	}
+	if result := mustSucceed(t, executeContract(t, bc, carol, multisig, "confirm", confirm, 0)); result != "pending" {
+		t.Fatalf("third confirmation after a revocation returned %s", result)
+	}
+	if result := mustSucceed(t, executeContract(t, bc, bob, multisig, "confirm", confirm, 0)); result != "executed" {
+		t.Fatalf("confirmation of every owner returned %s", result)
+	}
+}
+
+func TestMultisigProposalsExpireAndNeedTheBalance(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, dave := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	multisig := deployMultisig(t, bc, []*testWallet{alice, bob}, 2, 2)
+
+	// A transfer above the balance fails the confirmation, the proposal stays pending
+	overdrawn := mustSucceed(t, executeContract(t, bc, alice, multisig, "propose", map[string]any{"to": dave.ID, "amount": 11}, 0))
+	mustFail(t, executeContract(t, bc, bob, multisig, "confirm", map[string]any{"proposal_id": json.RawMessage(overdrawn)}, 0))
+	if status := proposalStatus(t, bc, multisig, overdrawn); status.Status != "pending" || len(status.Confirmations) != 1 {
+		t.Fatalf("overdrawn proposal reports %+v", status)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, multisig, "deposit", nil, 1))
+	mustSucceed(t, executeContract(t, bc, bob, multisig, "confirm", map[string]any{"proposal_id": json.RawMessage(overdrawn)}, 0))
+
+	mustSucceed(t, executeContract(t, bc, alice, multisig, "deposit", nil, 5))
+	expiring := mustSucceed(t, executeContract(t, bc, alice, multisig, "propose", map[string]any{"to": dave.ID, "amount": 1}, 0))
+	expiresAt := proposalStatus(t, bc, multisig, expiring).ExpiresAt
+	mineUntil(t, bc, alice.ID, expiresAt+1)
+	if status := proposalStatus(t, bc, multisig, expiring); status.Status != "expired" {
+		t.Fatalf("proposal past its expiry reports %+v", status)
+	}
+	mustFail(t, executeContract(t, bc, bob, multisig, "confirm", map[string]any{"proposal_id": json.RawMessage(expiring)}, 0))
+	mustFail(t, executeContract(t, bc, bob, multisig, "confirm", map[string]any{"proposal_id": json.RawMessage("99")}, 0))
+	if balance := bc.getBalance(dave.ID); balance != 11 {
+		t.Fatalf("dave holds %v", balance)
+	}
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const CONTRACT_NAME_REGISTRY_TYPE string = "name_registry"
+const NAME_SUFFIX string = ".chain" // Suffix of the names resolved in transactions
+const NAME_WALLET_RECORD string = "wallet"
+
+var namePattern = regexp.MustCompile(`^[a-z0-9-]{3,32}$`)
+
+func init() {
+	registerCode(CONTRACT_NAME_REGISTRY_TYPE, func() Code { return &ContractCodeNameRegistry{} })
+}
+
+// NameRegistrySpecification is the specification of a name registry, given as JSON at deployment
+// Registering or renewing a name costs Fee and extends its lease by Lease blocks
+type NameRegistrySpecification struct {
+	Fee   float64 `json:"fee"`
+	Lease int     `json:"lease"`
+}
+
+// NameRecord is a name registered by a wallet and its resolution records
+type NameRecord struct {
+	Name    string            `json:"name"`
+	Owner   string            `json:"owner"`
+	Expires int               `json:"expires"` // Block height from which the name can be registered again
+	Records map[string]string `json:"records"`
+}
+
+// ContractCodeNameRegistry implements a name service leasing names to wallets for a fee
+// The fees are withdrawn by the deployer
+type ContractCodeNameRegistry struct {
+	NameRegistrySpecification
+	Owner string                 `json:"owner"`
+	Names map[string]*NameRecord `json:"names"`
+}
+
+// Initialize configures the fee and lease of the registry, the deployer collects the fees
+func (sc *ContractCodeNameRegistry) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.NameRegistrySpecification); err != nil {
+		return fmt.Errorf("name registry specification must be JSON: %v", err)
+	}
+	sc.Owner = owner
+	sc.Names = map[string]*NameRecord{}
+	return nil
+}
+
+func (sc *ContractCodeNameRegistry) Validate(chain ChainReader) bool {
+	return sc.Owner != "" && sc.Fee >= 0 && sc.Lease > 0
+}
+
+func (sc *ContractCodeNameRegistry) ABI() ContractABI {
+	name := ParamABI{Name: "name", Type: "string"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "resolve", Params: []ParamABI{name}, Returns: "address", ReadOnly: true},
+			{Name: "record", Params: []ParamABI{name, {Name: "key", Type: "string"}}, Returns: "string", ReadOnly: true},
+			{Name: "lookup", Params: []ParamABI{name}, Returns: "object", ReadOnly: true},
+			{Name: "register", Params: []ParamABI{name}, Returns: "integer", Payable: true},
+			{Name: "renew", Params: []ParamABI{name}, Returns: "integer", Payable: true},
+			{Name: "transfer", Params: []ParamABI{name, {Name: "to", Type: "address"}}, Returns: "address"},
+			{Name: "set_record", Params: []ParamABI{name, {Name: "key", Type: "string"}, {Name: "value", Type: "string"}}, Returns: "string"},
+			{Name: "withdraw", Params: []ParamABI{}, Returns: "number"},
+		},
+		Events: []EventABI{
+			{Name: "Registered", Topics: []string{"name", "owner"}, Payload: []ParamABI{{Name: "expires", Type: "integer"}}},
+			{Name: "Renewed", Topics: []string{"name", "owner"}, Payload: []ParamABI{{Name: "expires", Type: "integer"}}},
+			{Name: "Transferred", Topics: []string{"name", "from", "to"}, Payload: []ParamABI{}},
+			{Name: "RecordSet", Topics: []string{"name", "key"}, Payload: []ParamABI{{Name: "value", Type: "string"}}},
+		},
+	}
+}
+
+func (sc *ContractCodeNameRegistry) Execute(ctx ExecutionContext) (string, error) {
+	var name string
+	if ctx.Method() != "withdraw" {
+		if err := ctx.Arg("name", &name); err != nil {
+			return "", err
+		}
+		if !namePattern.MatchString(name) {
+			return "", fmt.Errorf("names are 3 to 32 lowercase letters, digits or hyphens")
+		}
+	}
+
+	switch ctx.Method() {
+	case "resolve":
+		return sc.resolve(name, ctx.BlockHeight())
+	case "record":
+		record, err := sc.active(name, ctx.BlockHeight())
+		if err != nil {
+			return "", err
+		}
+		var key string
+		if err := ctx.Arg("key", &key); err != nil {
+			return "", err
+		}
+		return record.Records[key], nil
+	case "lookup":
+		record, err := sc.active(name, ctx.BlockHeight())
+		if err != nil {
+			return "", err
+		}
+		return marshalResult(record)
+	}
+
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	caller := ctx.Caller()
+	switch ctx.Method() {
+	case "register":
+		if record, err := sc.active(name, ctx.BlockHeight()); err == nil {
+			return "", fmt.Errorf("name %s is registered until block %d", name, record.Expires)
+		}
+		if ctx.Value() != sc.Fee {
+			return "", fmt.Errorf("registration must attach the fee of %v", sc.Fee)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		record := &NameRecord{
+			Name:    name,
+			Owner:   caller,
+			Expires: ctx.BlockHeight() + sc.Lease,
+			Records: map[string]string{},
+		}
+		sc.Names[name] = record
+		return strconv.Itoa(record.Expires), ctx.Emit("Registered", []string{name, caller}, map[string]int{"expires": record.Expires})
+	case "renew":
+		record, err := sc.owned(name, caller, ctx.BlockHeight())
+		if err != nil {
+			return "", err
+		}
+		if ctx.Value() != sc.Fee {
+			return "", fmt.Errorf("renewal must attach the fee of %v", sc.Fee)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		record.Expires += sc.Lease
+		return strconv.Itoa(record.Expires), ctx.Emit("Renewed", []string{name, caller}, map[string]int{"expires": record.Expires})
+	case "transfer":
+		record, err := sc.owned(name, caller, ctx.BlockHeight())
+		if err != nil {
+			return "", err
+		}
+		var to string
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		if to == "" || to == caller {
+			return "", fmt.Errorf("invalid recipient")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		// The records of the previous owner no longer apply
+		record.Owner = to
+		record.Records = map[string]string{}
+		return to, ctx.Emit("Transferred", []string{name, caller, to}, nil)
+	case "set_record":
+		record, err := sc.owned(name, caller, ctx.BlockHeight())
+		if err != nil {
+			return "", err
+		}
+		var key, value string
+		if err := ctx.Arg("key", &key); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("value", &value); err != nil {
+			return "", err
+		}
+		if key == "" {
+			return "", fmt.Errorf("record key must not be empty")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		if value == "" {
+			delete(record.Records, key)
+		} else {
+			record.Records[key] = value
+		}
+		return value, ctx.Emit("RecordSet", []string{name, key}, map[string]string{"value": value})
+	case "withdraw":
+		if caller != sc.Owner {
+			return "", fmt.Errorf("only the registry owner can withdraw the fees")
+		}
+		balance := ctx.Balance(ctx.ContractID())
+		if balance <= 0 {
+			return "", fmt.Errorf("no fees to withdraw")
+		}
+		if err := ctx.Transfer(sc.Owner, balance); err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(balance, 'f', -1, 64), nil
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// active returns the record of a name whose lease has not expired at a block height
+func (sc *ContractCodeNameRegistry) active(name string, height int) (*NameRecord, error) {
+	record, exists := sc.Names[name]
+	if !exists || height >= record.Expires {
+		return nil, fmt.Errorf("name %s is not registered", name)
+	}
+	return record, nil
+}
+
+// owned returns the active record of a name owned by a wallet
+func (sc *ContractCodeNameRegistry) owned(name string, owner string, height int) (*NameRecord, error) {
+	record, err := sc.active(name, height)
+	if err != nil {
+		return nil, err
+	}
+	if record.Owner != owner {
+		return nil, fmt.Errorf("name %s is not owned by the caller", name)
+	}
+	return record, nil
+}
+
+// resolve returns the wallet a name resolves to, its wallet record or else its owner
+func (sc *ContractCodeNameRegistry) resolve(name string, height int) (string, error) {
+	record, err := sc.active(name, height)
+	if err != nil {
+		return "", err
+	}
+	if wallet, exists := record.Records[NAME_WALLET_RECORD]; exists {
+		return wallet, nil
+	}
+	return record.Owner, nil
+}
+
+// NameRegistryUpdate designates the registry the node resolves names through, signed by the
+// node's name registry admin
+type NameRegistryUpdate struct {
+	ContractID string `json:"contract_id"`
+	Nonce      int64  `json:"nonce"`
+	Signature  string `json:"signature"`
+}
+
+func (u NameRegistryUpdate) signingMessage() string {
+	return signingMessage("name-registry", u.ContractID, strconv.FormatInt(u.Nonce, 10))
+}
+
+// setNameRegistry designates a deployed name registry when the update is signed by the admin
+// with a nonce above the one of its last update
+func (bc *Blockchain) setNameRegistry(update NameRegistryUpdate) error {
+	if bc.NameRegistryAdmin == "" {
+		return fmt.Errorf("no name registry admin is configured")
+	}
+	if update.Nonce <= bc.NameRegistryNonce {
+		return fmt.Errorf("nonce %d was already used, the next nonce must be above %d", update.Nonce, bc.NameRegistryNonce)
+	}
+	if err := verifySignature(bc.NameRegistryAdmin, update.signingMessage(), update.Signature); err != nil {
+		return err
+	}
+	if _, err := bc.getNameRegistry(update.ContractID); err != nil {
+		return err
+	}
+	bc.NameRegistry = update.ContractID
+	bc.NameRegistryNonce = update.Nonce
+	return nil
+}
+
+// resolveName resolves a transaction to an address ending with NAME_SUFFIX through the registry
+// chosen by the sender, or else the one designated on the node. The name and the registry are
+// recorded in the transaction with the resolved wallet, so every node applies the same transfer
+func (bc *Blockchain) resolveName(tx Transaction) (Transaction, error) {
+	if tx.Name != "" {
+		return tx, fmt.Errorf("the name is set by the node resolving it")
+	}
+	name, isName := strings.CutSuffix(tx.To, NAME_SUFFIX)
+	if !isName {
+		if tx.Registry != "" {
+			return tx, fmt.Errorf("a registry is only given with a name ending with %s", NAME_SUFFIX)
+		}
+		return tx, nil
+	}
+	if tx.Registry == "" {
+		tx.Registry = bc.NameRegistry
+	}
+	if tx.Registry == "" {
+		return tx, fmt.Errorf("cannot resolve %s, no name registry is configured", tx.To)
+	}
+	registry, err := bc.getNameRegistry(tx.Registry)
+	if err != nil {
+		return tx, err
+	}
+	to, err := registry.resolve(name, len(bc.Chain)-1)
+	if err != nil {
+		return tx, err
+	}
+	tx.Name, tx.To = tx.To, to
+	return tx, nil
+}
+
+// getNameRegistry returns the code of a deployed name registry
+func (bc *Blockchain) getNameRegistry(contractID string) (*ContractCodeNameRegistry, error) {
+	state, err := bc.getContractState(contractID)
+	if err != nil {
+		return nil, err
+	}
+	registry, ok := state.Code.(*ContractCodeNameRegistry)
+	if !ok {
+		return nil, fmt.Errorf("contract %s is not a name registry", contractID)
+	}
+	return registry, nil
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestNamesResolveThroughTheConfiguredRegistry(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, mallory.ID, 1)
+	spec := `{ "fee": 1, "lease": 1000 }`
+	rogue := deployContract(t, bc, mallory, CONTRACT_NAME_REGISTRY_TYPE, spec)
+	registry := deployContract(t, bc, alice, CONTRACT_NAME_REGISTRY_TYPE, spec)
+	mustSucceed(t, executeContract(t, bc, mallory, rogue, "register", map[string]any{"name": "alice"}, 1))
+	mustSucceed(t, executeContract(t, bc, alice, registry, "register", map[string]any{"name": "alice"}, 1))
+	mineBlocks(t, bc, alice.ID, 1)
+
+	if _, err := bc.addTransaction(Transaction{From: mallory.ID, To: "alice" + NAME_SUFFIX, Amount: 1}); err == nil || !strings.Contains(err.Error(), "no name registry") {
+		t.Fatalf("name was resolved without a configured registry: %v", err)
+	}
+
+	bc.NameRegistry = registry
+	tx, err := bc.addTransaction(Transaction{From: mallory.ID, To: "alice" + NAME_SUFFIX, Amount: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.To != alice.ID || tx.Name != "alice"+NAME_SUFFIX || tx.Registry != registry {
+		t.Fatalf("name was resolved to %s through %s", tx.To, tx.Registry)
+	}
+
+	bc.NameRegistry = "missing"
+	if _, err := bc.addTransaction(Transaction{From: mallory.ID, To: "alice" + NAME_SUFFIX, Amount: 1}); err == nil {
+		t.Fatal("name was resolved through a missing registry")
+	}
+}
+
+func TestTransactionsRecordTheRegistryResolvingTheirName(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, mallory := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	mineBlocks(t, bc, mallory.ID, 1)
+	spec := `{ "fee": 1, "lease": 1000 }`
+	registry := deployContract(t, bc, alice, CONTRACT_NAME_REGISTRY_TYPE, spec)
+	rogue := deployContract(t, bc, mallory, CONTRACT_NAME_REGISTRY_TYPE, spec)
+	mustSucceed(t, executeContract(t, bc, alice, registry, "register", map[string]any{"name": "alice"}, 1))
+	mustSucceed(t, executeContract(t, bc, mallory, rogue, "register", map[string]any{"name": "alice"}, 1))
+	bc.NameRegistry = registry
+
+	// The sender chooses the registry, the node's one being used otherwise
+	pinned, err := bc.addTransaction(Transaction{From: bob.ID, To: "alice" + NAME_SUFFIX, Amount: 1, Registry: rogue})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pinned.To != mallory.ID || pinned.Registry != rogue {
+		t.Fatalf("name was resolved to %s through %s", pinned.To, pinned.Registry)
+	}
+	if _, err := bc.addTransaction(Transaction{From: bob.ID, To: "alice" + NAME_SUFFIX, Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.mineTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.mineTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	rejected := map[string]Transaction{
+		"claimed name":          {From: bob.ID, To: alice.ID, Amount: 1, Name: "alice" + NAME_SUFFIX, Registry: registry},
+		"registry without name": {From: bob.ID, To: alice.ID, Amount: 1, Registry: registry},
+		"registry not deployed": {From: bob.ID, To: "alice" + NAME_SUFFIX, Amount: 1, Registry: "missing"},
+	}
+	for name, tx := range rejected {
+		if _, err := bc.addTransaction(tx); err == nil {
+			t.Fatalf("%s was accepted", name)
+		}
+	}
+
+	// Another node applies the recorded transfers whatever registry it designates
+	mineBlocks(t, bc, bob.ID, 1)
+	node := newTestBlockchain()
+	if err := node.replaceChain(receive(t, bc.Chain)); err != nil {
+		t.Fatalf("chain was rejected: %v", err)
+	}
+
+	tampered := receive(t, bc.Chain)
+	block := len(tampered) - 2
+	for j, tx := range tampered[block].Data.Transactions {
+		if tx.Registry == rogue {
+			tampered[block].Data.Transactions[j].Registry = "missing"
+		}
+	}
+	remine(tampered, block, bc.Difficulty)
+	if err := newTestBlockchain().validateChain(tampered); err == nil || !strings.Contains(err.Error(), "name registry") {
+		t.Fatalf("transfer resolved through a missing registry was accepted: %v", err)
+	}
+}
+
+func TestNameRegistryIsDesignatedByTheAdmin(t *testing.T) {
+	bc := newTestBlockchain()
+	admin, alice, mallory := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	registry := deployContract(t, bc, alice, CONTRACT_NAME_REGISTRY_TYPE, `{ "fee": 1, "lease": 1000 }`)
+	token := deployToken(t, bc, alice, 1000)
+
+	update := NameRegistryUpdate{ContractID: registry, Nonce: 1}
+	update.Signature = admin.sign(t, update.signingMessage())
+	if err := bc.setNameRegistry(update); err == nil || !strings.Contains(err.Error(), "no name registry admin") {
+		t.Fatalf("registry was set without an admin: %v", err)
+	}
+	bc.NameRegistryAdmin = admin.ID
+
+	forged := NameRegistryUpdate{ContractID: registry, Nonce: 1}
+	forged.Signature = mallory.sign(t, forged.signingMessage())
+	notRegistry := NameRegistryUpdate{ContractID: token, Nonce: 1}
+	notRegistry.Signature = admin.sign(t, notRegistry.signingMessage())
+	for name, rejected := range map[string]NameRegistryUpdate{"forged": forged, "not a registry": notRegistry} {
+		if err := bc.setNameRegistry(rejected); err == nil || bc.NameRegistry != "" {
+			t.Fatalf("%s update was accepted", name)
+		}
+	}
+
+	if err := bc.setNameRegistry(update); err != nil {
+		t.Fatal(err)
+	}
+	if bc.NameRegistry != registry {
+		t.Fatalf("name registry is %q", bc.NameRegistry)
+	}
+	if err := bc.setNameRegistry(update); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("replayed update returned %v", err)
+	}
+}
+
+func TestTransactionsToNamesResolveOnTheNode(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the node")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the node")
+	}
+	admin, alice, bob := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	node := startServer(t, ".", "NAME_REGISTRY_ADMIN="+admin.ID)
+	for i := 0; i < 3; i++ {
+		request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	}
+	request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(bob.ID), nil, http.StatusOK)
+
+	contract := SmartContract{Type: CONTRACT_NAME_REGISTRY_TYPE, Specification: `{ "fee": 1, "lease": 1000 }`, Nonce: alice.nextNonce()}
+	deployed := request(t, http.MethodPost, node+"/contract/new", map[string]any{
+		"wallet": alice.ID, "type": contract.Type, "specification": contract.Specification,
+		"nonce": contract.Nonce, "signature": alice.sign(t, contract.signingMessage()),
+	}, http.StatusCreated)
+	registry := deployed["contractID"].(string)
+	request(t, http.MethodGet, node+"/mine/deployment?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	request(t, http.MethodPost, node+"/contract/execute", signedExecution(t, alice, registry, "register", map[string]any{"name": "alice"}, 1), http.StatusCreated)
+	request(t, http.MethodGet, node+"/mine/contract?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+
+	transfer := map[string]any{"from": bob.ID, "to": "alice" + NAME_SUFFIX, "amount": 1}
+	request(t, http.MethodPost, node+"/transaction/new", transfer, http.StatusForbidden)
+
+	update := NameRegistryUpdate{ContractID: registry, Nonce: 1}
+	update.Signature = alice.sign(t, update.signingMessage())
+	request(t, http.MethodPost, node+"/name-registry", update, http.StatusForbidden)
+	update.Signature = admin.sign(t, update.signingMessage())
+	request(t, http.MethodPost, node+"/name-registry", update, http.StatusOK)
+	if designated := request(t, http.MethodGet, node+"/name-registry", nil, http.StatusOK); designated["contract_id"] != registry {
+		t.Fatalf("node resolves names through %v", designated["contract_id"])
+	}
+
+	before := balanceOn(t, node, alice.ID)
+	sent := request(t, http.MethodPost, node+"/transaction/new", transfer, http.StatusCreated)
+	if sent["to"] != alice.ID {
+		t.Fatalf("alice%s resolved to %v", NAME_SUFFIX, sent["to"])
+	}
+	request(t, http.MethodGet, node+"/mine/transaction?wallet="+url.QueryEscape(bob.ID), nil, http.StatusOK)
+	if received := balanceOn(t, node, alice.ID) - before; received != 1 {
+		t.Fatalf("alice received %v", received)
+	}
+}
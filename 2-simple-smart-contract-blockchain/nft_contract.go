@@ -0,0 +1,339 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+const CONTRACT_NFT_TYPE string = "nft"
+const NFT_RECEIVED_METHOD string = "on_nft_received"
+const NFT_RECEIVED_RESULT string = "accepted"
+
+func init() {
+	registerCode(CONTRACT_NFT_TYPE, func() Code { return &ContractCodeNFT{} })
+}
+
+// NFTSpecification is the specification of a non-fungible token contract, given as JSON at deployment
+type NFTSpecification struct {
+	Name   string `json:"name"`
+	Symbol string `json:"symbol"`
+}
+
+// NFTItem is a uniquely identified item of a non-fungible token contract
+type NFTItem struct {
+	TokenID  string         `json:"token_id"`
+	Owner    string         `json:"owner"`
+	URI      string         `json:"uri"`    // Location of the item's metadata
+	Digest   string         `json:"digest"` // Hex encoded SHA256 digest of the item's content
+	Approved string         `json:"approved"`
+	History  []NFTOwnership `json:"history"`
+}
+
+// NFTOwnership records a change of owner of an item, From is empty when the item is minted
+type NFTOwnership struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Block int    `json:"block"`
+}
+
+// ContractCodeNFT implements a collection of non-fungible items minted by its owner, with
+// approvals per item and operators allowed to manage all the items of a holder
+type ContractCodeNFT struct {
+	Owner     string                     `json:"owner"`
+	Name      string                     `json:"name"`
+	Symbol    string                     `json:"symbol"`
+	Items     map[string]*NFTItem        `json:"items"`
+	Operators map[string]map[string]bool `json:"operators"` // Holder to operators
+}
+
+// Initialize configures the collection from its specification
+func (sc *ContractCodeNFT) Initialize(owner string, specification string) error {
+	var spec NFTSpecification
+	if err := json.Unmarshal([]byte(specification), &spec); err != nil {
+		return fmt.Errorf("nft specification must be JSON: %v", err)
+	}
+	sc.Owner = owner
+	sc.Name = spec.Name
+	sc.Symbol = spec.Symbol
+	sc.Items = map[string]*NFTItem{}
+	sc.Operators = map[string]map[string]bool{}
+	return nil
+}
+
+func (sc *ContractCodeNFT) Validate(chain ChainReader) bool {
+	return sc.Owner != "" && sc.Name != "" && sc.Symbol != ""
+}
+
+func (sc *ContractCodeNFT) ABI() ContractABI {
+	param := func(name string, paramType string) ParamABI { return ParamABI{Name: name, Type: paramType} }
+	tokenID := param("token_id", "string")
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "name", Params: []ParamABI{}, Returns: "string", ReadOnly: true},
+			{Name: "symbol", Params: []ParamABI{}, Returns: "string", ReadOnly: true},
+			{Name: "total_supply", Params: []ParamABI{}, Returns: "integer", ReadOnly: true},
+			{Name: "owner_of", Params: []ParamABI{tokenID}, Returns: "address", ReadOnly: true},
+			{Name: "balance_of", Params: []ParamABI{param("owner", "address")}, Returns: "integer", ReadOnly: true},
+			{Name: "tokens_of", Params: []ParamABI{param("owner", "address")}, Returns: "array", ReadOnly: true},
+			{Name: "token", Params: []ParamABI{tokenID}, Returns: "object", ReadOnly: true},
+			{Name: "history", Params: []ParamABI{tokenID}, Returns: "array", ReadOnly: true},
+			{Name: "get_approved", Params: []ParamABI{tokenID}, Returns: "address", ReadOnly: true},
+			{Name: "is_approved_for_all", Params: []ParamABI{param("owner", "address"), param("operator", "address")}, Returns: "bool", ReadOnly: true},
+			{Name: "mint", Params: []ParamABI{param("to", "address"), tokenID, param("uri", "string"), param("digest", "string")}, Returns: "string"},
+			{Name: "approve", Params: []ParamABI{param("to", "address"), tokenID}, Returns: "bool"},
+			{Name: "set_approval_for_all", Params: []ParamABI{param("operator", "address"), param("approved", "bool")}, Returns: "bool"},
+			{Name: "transfer_from", Params: []ParamABI{param("from", "address"), param("to", "address"), tokenID}, Returns: "bool"},
+			{Name: "safe_transfer_from", Params: []ParamABI{param("from", "address"), param("to", "address"), tokenID, {Name: "data", Type: "any", Optional: true}}, Returns: "bool"},
+		},
+		Events: []EventABI{
+			{Name: "Transfer", Topics: []string{"from", "to", "token_id"}, Payload: []ParamABI{param("uri", "string"), param("digest", "string")}},
+			{Name: "Approval", Topics: []string{"owner", "approved", "token_id"}, Payload: []ParamABI{}},
+			{Name: "ApprovalForAll", Topics: []string{"owner", "operator"}, Payload: []ParamABI{param("approved", "bool")}},
+		},
+	}
+}
+
+func (sc *ContractCodeNFT) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "name":
+		return sc.Name, nil
+	case "symbol":
+		return sc.Symbol, nil
+	case "total_supply":
+		return strconv.Itoa(len(sc.Items)), nil
+	case "owner_of", "token", "history", "get_approved":
+		item, err := sc.item(ctx)
+		if err != nil {
+			return "", err
+		}
+		switch ctx.Method() {
+		case "owner_of":
+			return item.Owner, nil
+		case "get_approved":
+			return item.Approved, nil
+		case "history":
+			return marshalResult(item.History)
+		}
+		return marshalResult(item)
+	case "balance_of", "tokens_of":
+		var owner string
+		if err := ctx.Arg("owner", &owner); err != nil {
+			return "", err
+		}
+		tokens := sc.tokensOf(owner)
+		if ctx.Method() == "balance_of" {
+			return strconv.Itoa(len(tokens)), nil
+		}
+		return marshalResult(tokens)
+	case "is_approved_for_all":
+		var owner, operator string
+		if err := ctx.Arg("owner", &owner); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("operator", &operator); err != nil {
+			return "", err
+		}
+		return strconv.FormatBool(sc.Operators[owner][operator]), nil
+	}
+
+	// The remaining methods change the collection on behalf of the caller
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+
+	switch ctx.Method() {
+	case "mint":
+		return sc.mint(ctx)
+	case "approve":
+		var to string
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		item, err := sc.item(ctx)
+		if err != nil {
+			return "", err
+		}
+		if ctx.Caller() != item.Owner && !sc.Operators[item.Owner][ctx.Caller()] {
+			return "", fmt.Errorf("only the owner or an operator of token %s can approve", item.TokenID)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		item.Approved = to
+		return "true", ctx.Emit("Approval", []string{item.Owner, to, item.TokenID}, nil)
+	case "set_approval_for_all":
+		var operator string
+		var approved bool
+		if err := ctx.Arg("operator", &operator); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("approved", &approved); err != nil {
+			return "", err
+		}
+		if operator == ctx.Caller() {
+			return "", fmt.Errorf("an owner cannot be its own operator")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		if approved {
+			if sc.Operators[ctx.Caller()] == nil {
+				sc.Operators[ctx.Caller()] = map[string]bool{}
+			}
+			sc.Operators[ctx.Caller()][operator] = true
+		} else {
+			delete(sc.Operators[ctx.Caller()], operator)
+			if len(sc.Operators[ctx.Caller()]) == 0 {
+				delete(sc.Operators, ctx.Caller())
+			}
+		}
+		return "true", ctx.Emit("ApprovalForAll", []string{ctx.Caller(), operator}, map[string]bool{"approved": approved})
+	case "transfer_from", "safe_transfer_from":
+		var from, to string
+		if err := ctx.Arg("from", &from); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		item, err := sc.item(ctx)
+		if err != nil {
+			return "", err
+		}
+		if err := sc.transfer(ctx, item, from, to); err != nil {
+			return "", err
+		}
+		if ctx.Method() == "safe_transfer_from" && ctx.IsContract(to) {
+			var data json.RawMessage
+			ctx.Arg("data", &data) // Optional
+			if err := sc.notifyReceiver(ctx, item, from, to, data); err != nil {
+				return "", err
+			}
+		}
+		return "true", nil
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// item returns the item identified by the token_id argument
+func (sc *ContractCodeNFT) item(ctx ExecutionContext) (*NFTItem, error) {
+	var tokenID string
+	if err := ctx.Arg("token_id", &tokenID); err != nil {
+		return nil, err
+	}
+	item, exists := sc.Items[tokenID]
+	if !exists {
+		return nil, fmt.Errorf("token %s not found", tokenID)
+	}
+	return item, nil
+}
+
+// tokensOf returns the sorted IDs of the items owned by a holder
+func (sc *ContractCodeNFT) tokensOf(owner string) []string {
+	tokens := []string{}
+	for tokenID, item := range sc.Items {
+		if item.Owner == owner {
+			tokens = append(tokens, tokenID)
+		}
+	}
+	sort.Strings(tokens)
+	return tokens
+}
+
+// mint creates a new item owned by the to argument, only the collection owner can mint
+func (sc *ContractCodeNFT) mint(ctx ExecutionContext) (string, error) {
+	if ctx.Caller() != sc.Owner {
+		return "", fmt.Errorf("only the collection owner can mint")
+	}
+	item := &NFTItem{}
+	if err := ctx.Arg("to", &item.Owner); err != nil {
+		return "", err
+	}
+	if err := ctx.Arg("token_id", &item.TokenID); err != nil {
+		return "", err
+	}
+	if err := ctx.Arg("uri", &item.URI); err != nil {
+		return "", err
+	}
+	if err := ctx.Arg("digest", &item.Digest); err != nil {
+		return "", err
+	}
+	if item.Owner == "" {
+		return "", fmt.Errorf("invalid recipient")
+	}
+	if item.TokenID == "" {
+		return "", fmt.Errorf("token id must not be empty")
+	}
+	if _, exists := sc.Items[item.TokenID]; exists {
+		return "", fmt.Errorf("token %s already minted", item.TokenID)
+	}
+	if digest, err := hex.DecodeString(item.Digest); err != nil || len(digest) != 32 {
+		return "", fmt.Errorf("digest must be a hex encoded SHA256 digest")
+	}
+	if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+		return "", err
+	}
+
+	item.History = []NFTOwnership{{To: item.Owner, Block: ctx.BlockHeight()}}
+	sc.Items[item.TokenID] = item
+	err := ctx.Emit("Transfer", []string{"", item.Owner, item.TokenID}, map[string]string{
+		"uri":    item.URI,
+		"digest": item.Digest,
+	})
+	return item.TokenID, err
+}
+
+// transfer changes the owner of an item, the caller must be its owner, approved for it or an
+// operator of its owner. The approval of the item is cleared
+func (sc *ContractCodeNFT) transfer(ctx ExecutionContext, item *NFTItem, from string, to string) error {
+	if item.Owner != from {
+		return fmt.Errorf("token %s is not owned by %s", item.TokenID, from)
+	}
+	if to == "" {
+		return fmt.Errorf("invalid recipient")
+	}
+	caller := ctx.Caller()
+	if caller != from && caller != item.Approved && !sc.Operators[from][caller] {
+		return fmt.Errorf("caller is not allowed to transfer token %s", item.TokenID)
+	}
+	if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+		return err
+	}
+
+	item.Owner = to
+	item.Approved = ""
+	item.History = append(item.History, NFTOwnership{From: from, To: to, Block: ctx.BlockHeight()})
+	return ctx.Emit("Transfer", []string{from, to, item.TokenID}, map[string]string{
+		"uri":    item.URI,
+		"digest": item.Digest,
+	})
+}
+
+// notifyReceiver calls the receiving contract of a safe transfer, which must accept the item
+func (sc *ContractCodeNFT) notifyReceiver(ctx ExecutionContext, item *NFTItem, from string, to string, data json.RawMessage) error {
+	result, err := ctx.Call(to, NFT_RECEIVED_METHOD, map[string]any{
+		"operator": ctx.Caller(),
+		"from":     from,
+		"token_id": item.TokenID,
+		"data":     data,
+	}, 0)
+	if err != nil {
+		return fmt.Errorf("receiver contract rejected token %s: %v", item.TokenID, err)
+	}
+	if result != NFT_RECEIVED_RESULT {
+		return fmt.Errorf("receiver contract did not accept token %s", item.TokenID)
+	}
+	return nil
+}
+
+// marshalResult returns the JSON encoding of a value as the result of a method
+func marshalResult(v any) (string, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
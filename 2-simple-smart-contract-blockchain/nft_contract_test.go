@@ -0,0 +1,168 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+const TEST_NFT_RECEIVER_TYPE string = "test_nft_receiver"
+
+func init() {
+	registerCode(TEST_NFT_RECEIVER_TYPE, func() Code { return &testNFTReceiverCode{} })
+}
+
+// testNFTReceiverCode accepts the items of safe transfers sent with the data "accept"
+type testNFTReceiverCode struct{}
+
+func (sc *testNFTReceiverCode) Execute(ctx ExecutionContext) (string, error) {
+	if ctx.Method() != NFT_RECEIVED_METHOD {
+		return "", nil
+	}
+	var data string
+	ctx.Arg("data", &data)
+	if data != "accept" {
+		return "rejected", nil
+	}
+	return NFT_RECEIVED_RESULT, nil
+}
+
+func (sc *testNFTReceiverCode) Validate(chain ChainReader) bool {
+	return true
+}
+
+// mintItem mints an item of the collection to a wallet
+func mintItem(t *testing.T, bc *Blockchain, owner *testWallet, nft string, to string, tokenID string) ContractExecution {
+	t.Helper()
+	digest := sha256.Sum256([]byte(tokenID))
+	return executeContract(t, bc, owner, nft, "mint", map[string]any{
+		"to": to, "token_id": tokenID, "uri": "ipfs://" + tokenID, "digest": hex.EncodeToString(digest[:]),
+	}, 0)
+}
+
+func TestNFTMintingAndApprovals(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, carol} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	nft := deployContract(t, bc, alice, CONTRACT_NFT_TYPE, `{ "name": "Paintings", "symbol": "ART" }`)
+
+	mustSucceed(t, mintItem(t, bc, alice, nft, bob.ID, "mona"))
+	mustFail(t, mintItem(t, bc, alice, nft, bob.ID, "mona"))
+	mustFail(t, mintItem(t, bc, bob, nft, bob.ID, "scream"))
+	mustFail(t, executeContract(t, bc, alice, nft, "mint", map[string]any{"to": bob.ID, "token_id": "scream", "uri": "", "digest": "00"}, 0))
+	if owner := callView(t, bc, nft, "owner_of", map[string]any{"token_id": "mona"}); owner != bob.ID {
+		t.Fatalf("mona is owned by %s", owner)
+	}
+
+	// Only the owner approves an item, the approved wallet transfers it once
+	transfer := map[string]any{"from": bob.ID, "to": carol.ID, "token_id": "mona"}
+	mustFail(t, executeContract(t, bc, carol, nft, "approve", map[string]any{"to": carol.ID, "token_id": "mona"}, 0))
+	mustFail(t, executeContract(t, bc, carol, nft, "transfer_from", transfer, 0))
+	mustSucceed(t, executeContract(t, bc, bob, nft, "approve", map[string]any{"to": carol.ID, "token_id": "mona"}, 0))
+	if approved := callView(t, bc, nft, "get_approved", map[string]any{"token_id": "mona"}); approved != carol.ID {
+		t.Fatalf("mona is approved for %s", approved)
+	}
+	mustSucceed(t, executeContract(t, bc, carol, nft, "transfer_from", transfer, 0))
+	if approved := callView(t, bc, nft, "get_approved", map[string]any{"token_id": "mona"}); approved != "" {
+		t.Fatalf("approval for %s was kept after the transfer", approved)
+	}
+	mustFail(t, executeContract(t, bc, carol, nft, "transfer_from", transfer, 0))
+	if balance := callView(t, bc, nft, "balance_of", map[string]any{"owner": carol.ID}); balance != "1" {
+		t.Fatalf("carol holds %s items", balance)
+	}
+}
+
+func TestNFTOperatorsTransferEveryItemOfTheirHolder(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, carol} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	nft := deployContract(t, bc, alice, CONTRACT_NFT_TYPE, `{ "name": "Paintings", "symbol": "ART" }`)
+	for _, tokenID := range []string{"mona", "scream"} {
+		mustSucceed(t, mintItem(t, bc, alice, nft, bob.ID, tokenID))
+	}
+
+	mustFail(t, executeContract(t, bc, bob, nft, "set_approval_for_all", map[string]any{"operator": bob.ID, "approved": true}, 0))
+	mustSucceed(t, executeContract(t, bc, bob, nft, "set_approval_for_all", map[string]any{"operator": carol.ID, "approved": true}, 0))
+	if approved := callView(t, bc, nft, "is_approved_for_all", map[string]any{"owner": bob.ID, "operator": carol.ID}); approved != "true" {
+		t.Fatalf("carol is operator of bob: %s", approved)
+	}
+
+	// The operator approves and transfers any item of the holder
+	mustSucceed(t, executeContract(t, bc, carol, nft, "approve", map[string]any{"to": alice.ID, "token_id": "scream"}, 0))
+	mustSucceed(t, executeContract(t, bc, carol, nft, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "token_id": "mona"}, 0))
+	mustSucceed(t, executeContract(t, bc, alice, nft, "transfer_from", map[string]any{"from": bob.ID, "to": alice.ID, "token_id": "scream"}, 0))
+	if tokens := callView(t, bc, nft, "tokens_of", map[string]any{"owner": bob.ID}); tokens != "[]" {
+		t.Fatalf("bob still holds %s", tokens)
+	}
+
+	// Revoked operators lose access to the items the holder receives later
+	mustSucceed(t, executeContract(t, bc, alice, nft, "transfer_from", map[string]any{"from": alice.ID, "to": bob.ID, "token_id": "scream"}, 0))
+	mustSucceed(t, executeContract(t, bc, bob, nft, "set_approval_for_all", map[string]any{"operator": carol.ID, "approved": false}, 0))
+	mustFail(t, executeContract(t, bc, carol, nft, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "token_id": "scream"}, 0))
+}
+
+func TestNFTSafeTransfersRequireTheReceiverToAccept(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	nft := deployContract(t, bc, alice, CONTRACT_NFT_TYPE, `{ "name": "Paintings", "symbol": "ART" }`)
+	receiver := deployContract(t, bc, alice, TEST_NFT_RECEIVER_TYPE, "")
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, mintItem(t, bc, alice, nft, alice.ID, "mona"))
+
+	tests := []struct {
+		name string
+		to   string
+		data string
+	}{
+		{"receiver rejecting the item", receiver, "reject"},
+		{"contract without a receiver method", counter, "accept"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mustFail(t, executeContract(t, bc, alice, nft, "safe_transfer_from", map[string]any{"from": alice.ID, "to": test.to, "token_id": "mona", "data": test.data}, 0))
+			if owner := callView(t, bc, nft, "owner_of", map[string]any{"token_id": "mona"}); owner != alice.ID {
+				t.Fatalf("rejected item moved to %s", owner)
+			}
+		})
+	}
+
+	mustSucceed(t, executeContract(t, bc, alice, nft, "safe_transfer_from", map[string]any{"from": alice.ID, "to": receiver, "token_id": "mona", "data": "accept"}, 0))
+	if owner := callView(t, bc, nft, "owner_of", map[string]any{"token_id": "mona"}); owner != receiver {
+		t.Fatalf("accepted item is owned by %s", owner)
+	}
+	// Safe transfers to wallets do not call a receiver
+	mustSucceed(t, mintItem(t, bc, alice, nft, alice.ID, "scream"))
+	mustSucceed(t, executeContract(t, bc, alice, nft, "safe_transfer_from", map[string]any{"from": alice.ID, "to": bob.ID, "token_id": "scream"}, 0))
+}
+
+func TestNFTHistoryRecordsEveryOwner(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, carol} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	nft := deployContract(t, bc, alice, CONTRACT_NFT_TYPE, `{ "name": "Paintings", "symbol": "ART" }`)
+	minted := len(bc.Chain) - 1
+	mustSucceed(t, mintItem(t, bc, alice, nft, bob.ID, "mona"))
+	mineBlocks(t, bc, alice.ID, 1)
+	mustSucceed(t, executeContract(t, bc, bob, nft, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "token_id": "mona"}, 0))
+
+	var history []NFTOwnership
+	if err := json.Unmarshal([]byte(callView(t, bc, nft, "history", map[string]any{"token_id": "mona"})), &history); err != nil {
+		t.Fatal(err)
+	}
+	expected := []NFTOwnership{{From: "", To: bob.ID, Block: minted}, {From: bob.ID, To: carol.ID, Block: minted + 1}}
+	if len(history) != len(expected) || history[0] != expected[0] || history[1] != expected[1] {
+		t.Fatalf("history of mona is %+v", history)
+	}
+	if _, err := bc.callContract(ContractExecution{ContractID: nft, Method: "history", Args: marshalArgs(t, map[string]any{"token_id": "unknown"}), GasLimit: VIEW_GAS_LIMIT}); err == nil {
+		t.Fatal("history of an unknown token")
+	}
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ORACLE_MAX_CLOCK_DRIFT time.Duration = 30 * time.Second // Reports timestamped further in the future are rejected
+
+// OracleFeed represents a data feed whose observations are reported by authorised wallets
+// The owner registering the feed first can later replace its reporters with a higher version
+type OracleFeed struct {
+	Feed      string   `json:"feed"`
+	Owner     string   `json:"owner"`
+	Reporters []string `json:"reporters"`
+	MaxAge    int      `json:"max_age"` // Seconds after which an observation is too stale to be reported
+	Version   int      `json:"version"`
+	Signature string   `json:"signature"`
+}
+
+// OracleReport represents an observation of a key of a feed signed by one of its reporters
+type OracleReport struct {
+	Feed      string `json:"feed"`
+	Key       string `json:"key"`
+	Value     string `json:"value"`
+	Timestamp int64  `json:"timestamp"` // Unix time of the observation
+	Reporter  string `json:"reporter"`
+	Signature string `json:"signature"`
+}
+
+// OracleObservation is the latest reported value of a key of a feed, as read by contracts
+type OracleObservation struct {
+	Feed      string    `json:"feed"`
+	Key       string    `json:"key"`
+	Value     string    `json:"value"`
+	Timestamp time.Time `json:"timestamp"`
+	Reporter  string    `json:"reporter"`
+	Block     int       `json:"block"`
+}
+
+// signingMessage returns the message the owner signs to authorise the feed
+func (f OracleFeed) signingMessage() string {
+	return signingMessage("oracle-feed", f.Feed, strings.Join(f.Reporters, ","), strconv.Itoa(f.MaxAge), strconv.Itoa(f.Version))
+}
+
+// isReporter checks if a wallet is authorised to report observations of the feed
+func (f OracleFeed) isReporter(wallet string) bool {
+	for _, reporter := range f.Reporters {
+		if reporter == wallet {
+			return true
+		}
+	}
+	return false
+}
+
+// signingMessage returns the message the reporter signs to authorise the report
+func (r OracleReport) signingMessage() string {
+	return signingMessage("oracle-report", r.Feed, r.Key, r.Value, strconv.FormatInt(r.Timestamp, 10))
+}
+
+// findOracleFeed returns the latest version of a feed stored on the chain
+func (bc *Blockchain) findOracleFeed(feed string) *OracleFeed {
+	var latest *OracleFeed
+	for i := range bc.Chain {
+		data := &bc.Chain[i].Data
+		for j := range data.OracleFeeds {
+			if data.OracleFeeds[j].Feed == feed {
+				latest = &data.OracleFeeds[j]
+			}
+		}
+	}
+	return latest
+}
+
+// findOracleObservation returns the latest observation of a key of a feed stored in the blocks
+// below height
+func (bc *Blockchain) findOracleObservation(feed string, key string, height int) (OracleObservation, bool) {
+	for i := height - 1; i >= 0; i-- {
+		reports := bc.Chain[i].Data.OracleReports
+		for j := len(reports) - 1; j >= 0; j-- {
+			report := reports[j]
+			if report.Feed == feed && report.Key == key {
+				return OracleObservation{
+					Feed:      report.Feed,
+					Key:       report.Key,
+					Value:     report.Value,
+					Timestamp: time.Unix(report.Timestamp, 0).UTC(),
+					Reporter:  report.Reporter,
+					Block:     i,
+				}, true
+			}
+		}
+	}
+	return OracleObservation{}, false
+}
+
+// getOracleObservations returns the latest observation of each key of a feed
+func (bc *Blockchain) getOracleObservations(feed string) []OracleObservation {
+	observations := []OracleObservation{}
+	seen := make(map[string]bool)
+	for _, block := range bc.Chain {
+		for _, report := range block.Data.OracleReports {
+			if report.Feed == feed && !seen[report.Key] {
+				seen[report.Key] = true
+				observation, _ := bc.findOracleObservation(feed, report.Key, len(bc.Chain))
+				observations = append(observations, observation)
+			}
+		}
+	}
+	return observations
+}
+
+// checkOracleFeed checks a feed, or a new version of it, can be stored in the current block
+func (bc *Blockchain) checkOracleFeed(feed OracleFeed) error {
+	if existing := bc.findOracleFeed(feed.Feed); existing == nil {
+		if feed.Version != 1 {
+			return fmt.Errorf("first version of a feed must be 1")
+		}
+	} else {
+		if existing.Owner != feed.Owner {
+			return fmt.Errorf("feed %s is owned by another wallet", feed.Feed)
+		}
+		if feed.Version != existing.Version+1 {
+			return fmt.Errorf("next version of feed %s must be %d", feed.Feed, existing.Version+1)
+		}
+	}
+	return nil
+}
+
+// addOracleFeed adds a feed, or a new version of it, to the oracle feed pool after validating it
+func (bc *Blockchain) addOracleFeed(feed OracleFeed) error {
+	if err := verifySignature(feed.Owner, feed.signingMessage(), feed.Signature); err != nil {
+		return fmt.Errorf("feed signature verification failed: %v", err)
+	}
+	if feed.Feed == "" || strings.Contains(feed.Feed, "|") {
+		return fmt.Errorf("feed name must not be empty or contain |")
+	}
+	if len(feed.Reporters) == 0 {
+		return fmt.Errorf("feed needs at least one reporter")
+	}
+	for _, reporter := range feed.Reporters {
+		if _, err := parseWalletKey(reporter); err != nil {
+			return fmt.Errorf("invalid reporter: %v", err)
+		}
+	}
+	if feed.MaxAge <= 0 {
+		return fmt.Errorf("max age must be positive")
+	}
+	if err := bc.checkOracleFeed(feed); err != nil {
+		return err
+	}
+	// Versions of a feed follow each other, a single one waits to be mined
+	for _, pending := range bc.OracleFeedPool {
+		if pending.Feed == feed.Feed {
+			return fmt.Errorf("feed %s already has a version waiting to be mined", feed.Feed)
+		}
+	}
+
+	bc.OracleFeedPool = append(bc.OracleFeedPool, feed)
+	return nil
+}
+
+// mineOracleFeed mines feeds from the oracle feed pool into the current block
+func (bc *Blockchain) mineOracleFeed() (OracleFeed, error) {
+	if len(bc.OracleFeedPool) == 0 {
+		return OracleFeed{}, fmt.Errorf("no oracle feeds to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first feed in the pool (FIFO) and remove it from the pool
+	feed := bc.OracleFeedPool[0]
+	bc.OracleFeedPool = bc.OracleFeedPool[1:]
+
+	// Another wallet may have registered the feed since it was added to the pool
+	if err := bc.checkOracleFeed(feed); err != nil {
+		return OracleFeed{}, fmt.Errorf("feed %s is no longer valid: %v", feed.Feed, err)
+	}
+
+	lastBlock.Data.OracleFeeds = append(lastBlock.Data.OracleFeeds, feed)
+	return feed, nil
+}
+
+// checkOracleReport checks a report can be stored in the current block: its reporter is one of
+// the feed and it is newer than the latest observation of its key
+func (bc *Blockchain) checkOracleReport(report OracleReport) error {
+	feed := bc.findOracleFeed(report.Feed)
+	if feed == nil {
+		return fmt.Errorf("feed not found")
+	}
+	if !feed.isReporter(report.Reporter) {
+		return fmt.Errorf("wallet is not a reporter of feed %s", report.Feed)
+	}
+	observed := time.Unix(report.Timestamp, 0)
+	if latest, exists := bc.findOracleObservation(report.Feed, report.Key, len(bc.Chain)); exists && !observed.After(latest.Timestamp) {
+		return fmt.Errorf("report is not newer than the latest observation at %s", latest.Timestamp.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// addOracleReport adds an observation received at now to the oracle report pool after validating it
+// Unsigned, unauthorised and stale reports are rejected
+func (bc *Blockchain) addOracleReport(report OracleReport, now time.Time) error {
+	feed := bc.findOracleFeed(report.Feed)
+	if feed == nil {
+		return fmt.Errorf("feed not found")
+	}
+	if !feed.isReporter(report.Reporter) {
+		return fmt.Errorf("wallet is not a reporter of feed %s", report.Feed)
+	}
+	if err := verifySignature(report.Reporter, report.signingMessage(), report.Signature); err != nil {
+		return fmt.Errorf("report signature verification failed: %v", err)
+	}
+	if report.Key == "" || strings.Contains(report.Key, "|") {
+		return fmt.Errorf("key must not be empty or contain |")
+	}
+
+	observed := time.Unix(report.Timestamp, 0)
+	if observed.After(now.Add(ORACLE_MAX_CLOCK_DRIFT)) {
+		return fmt.Errorf("report timestamp is in the future")
+	}
+	if now.Sub(observed) > time.Duration(feed.MaxAge)*time.Second {
+		return fmt.Errorf("report is older than the max age of %d seconds", feed.MaxAge)
+	}
+	if err := bc.checkOracleReport(report); err != nil {
+		return err
+	}
+	for _, pending := range bc.OracleReportPool {
+		if pending.Feed == report.Feed && pending.Key == report.Key && pending.Timestamp >= report.Timestamp {
+			return fmt.Errorf("report is not newer than the report waiting to be mined at %s", time.Unix(pending.Timestamp, 0).UTC().Format(time.RFC3339))
+		}
+	}
+
+	bc.OracleReportPool = append(bc.OracleReportPool, report)
+	return nil
+}
+
+// mineOracleReport mines reports from the oracle report pool into the current block
+func (bc *Blockchain) mineOracleReport() (OracleReport, error) {
+	if len(bc.OracleReportPool) == 0 {
+		return OracleReport{}, fmt.Errorf("no oracle reports to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first report in the pool (FIFO) and remove it from the pool
+	report := bc.OracleReportPool[0]
+	bc.OracleReportPool = bc.OracleReportPool[1:]
+
+	// The feed may have replaced its reporters, or a newer report been mined, since it was added to the pool
+	if err := bc.checkOracleReport(report); err != nil {
+		return OracleReport{}, fmt.Errorf("report of %s is no longer valid: %v", report.Key, err)
+	}
+
+	lastBlock.Data.OracleReports = append(lastBlock.Data.OracleReports, report)
+	return report, nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TEST_ORACLE_TYPE string = "test_oracle"
+
+func init() {
+	registerCode(TEST_ORACLE_TYPE, func() Code { return &testOracleCode{} })
+}
+
+// testOracleCode returns the latest observation of a key of the acme feed
+type testOracleCode struct{}
+
+func (sc *testOracleCode) Execute(ctx ExecutionContext) (string, error) {
+	var key string
+	if err := ctx.Arg("key", &key); err != nil {
+		return "", err
+	}
+	observation, err := ctx.Oracle("acme", key)
+	if err != nil {
+		return "", err
+	}
+	return observation.Value, nil
+}
+
+func (sc *testOracleCode) Validate(chain ChainReader) bool {
+	return true
+}
+
+func TestOracleRelaysTheChallengeDigest(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the node and the challenge server")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the node and the challenge server")
+	}
+	alice, mallory, reporter := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	node := startServer(t, ".")
+	request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	contract := SmartContract{Type: "contract_example", Specification: "acme", Nonce: alice.nextNonce()}
+	deployed := request(t, http.MethodPost, node+"/contract/new", map[string]any{
+		"wallet": alice.ID, "type": contract.Type, "specification": contract.Specification,
+		"nonce": contract.Nonce, "signature": alice.sign(t, contract.signingMessage()),
+	}, http.StatusCreated)
+	contractID := deployed["contractID"].(string)
+	request(t, http.MethodGet, node+"/mine/deployment?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	challenge := startServer(t, "challenge", "NODE_URL="+node, "STORE_PATH="+filepath.Join(t.TempDir(), "submissions.jsonl"))
+
+	// The digest of the contract is the SHA256 of the JSON the node serves, also sent in X-Contract-Digest
+	response, err := http.Get(node + "/contract/" + contractID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := sha256.Sum256(data)
+	digest := hex.EncodeToString(hash[:])
+	if header := response.Header.Get("X-Contract-Digest"); header != digest {
+		t.Fatalf("node sent the digest %q, the contract hashes to %s", header, digest)
+	}
+
+	// A submission in the name of the deployer not signed by it is neither verified nor recorded
+	forgedAt := time.Now().Unix() + 10
+	request(t, http.MethodPost, challenge+"/acme", map[string]any{
+		"wallet": alice.ID, "contract_id": contractID, "digest": digest, "signed_at": forgedAt,
+		"signature": mallory.sign(t, signingMessage("acme", contractID, digest, strconv.FormatInt(forgedAt, 10))),
+	}, http.StatusUnauthorized)
+	history := request(t, http.MethodGet, challenge+"/acme/history?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	if submissions := history["submissions"].([]any); len(submissions) != 0 {
+		t.Fatalf("forged submission was recorded: %v", submissions)
+	}
+
+	// The deployer proves to the challenge server that its wallet deployed the contract
+	signedAt := time.Now().Unix()
+	body := map[string]any{
+		"wallet": alice.ID, "contract_id": contractID, "digest": digest, "signed_at": signedAt,
+		"signature": alice.sign(t, signingMessage("acme", contractID, digest, strconv.FormatInt(signedAt, 10))),
+	}
+	submission := request(t, http.MethodPost, challenge+"/acme", body, http.StatusOK)
+	if submission["passed"] != true {
+		t.Fatalf("challenge rejected the submission: %v", submission["reason"])
+	}
+	request(t, http.MethodPost, challenge+"/acme", body, http.StatusForbidden)
+
+	// A later failed submission does not replace the digest the challenge server holds
+	failedAt := signedAt + 1
+	failed := request(t, http.MethodPost, challenge+"/acme", map[string]any{
+		"wallet": alice.ID, "contract_id": contractID, "digest": "wrong", "signed_at": failedAt,
+		"signature": alice.sign(t, signingMessage("acme", contractID, "wrong", strconv.FormatInt(failedAt, 10))),
+	}, http.StatusOK)
+	if failed["passed"] != false {
+		t.Fatal("challenge accepted a digest not matching the contract")
+	}
+
+	// The reporter relays the digest held by the challenge server into the acme feed of the node
+	feed := OracleFeed{Feed: "acme", Owner: alice.ID, Reporters: []string{reporter.ID}, MaxAge: 60, Version: 1}
+	feed.Signature = alice.sign(t, feed.signingMessage())
+	request(t, http.MethodPost, node+"/oracle/feed", feed, http.StatusCreated)
+	request(t, http.MethodGet, node+"/mine/feed", nil, http.StatusOK)
+	response, err = http.Get(challenge + "/acme?wallet=" + url.QueryEscape(alice.ID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := io.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil || response.StatusCode != http.StatusOK || string(latest) != digest {
+		t.Fatalf("challenge server answered %d with %q", response.StatusCode, latest)
+	}
+	report := OracleReport{Feed: "acme", Key: alice.ID, Value: string(latest), Timestamp: time.Now().Unix(), Reporter: reporter.ID}
+	report.Signature = reporter.sign(t, report.signingMessage())
+	request(t, http.MethodPost, node+"/oracle/report", report, http.StatusCreated)
+	request(t, http.MethodGet, node+"/mine/report", nil, http.StatusOK)
+	request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	observation := request(t, http.MethodGet, node+"/oracle/acme/observation?key="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	if observation["value"] != digest {
+		t.Fatalf("node observed %v, expected the digest %s", observation["value"], digest)
+	}
+}
+
+func TestContractsReadOracleObservationsOfMinedBlocks(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, reporter := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, TEST_ORACLE_TYPE, "")
+
+	feed := OracleFeed{Feed: "acme", Owner: alice.ID, Reporters: []string{reporter.ID}, MaxAge: 60, Version: 1}
+	feed.Signature = alice.sign(t, feed.signingMessage())
+	if err := bc.addOracleFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleFeed(); err != nil {
+		t.Fatal(err)
+	}
+	report := OracleReport{Feed: "acme", Key: alice.ID, Value: "digest", Timestamp: time.Now().Unix(), Reporter: reporter.ID}
+	report.Signature = reporter.sign(t, report.signingMessage())
+	if err := bc.addOracleReport(report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleReport(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Contracts only read observations of mined blocks
+	if _, err := bc.callContract(ContractExecution{ContractID: contractID, Method: "observe", Args: marshalArgs(t, map[string]any{"key": alice.ID}), GasLimit: VIEW_GAS_LIMIT}); err == nil {
+		t.Fatal("contract read an observation of the open block")
+	}
+	mineBlocks(t, bc, alice.ID, 1)
+	if value := callView(t, bc, contractID, "observe", map[string]any{"key": alice.ID}); value != "digest" {
+		t.Fatalf("contract read %q, expected the reported digest", value)
+	}
+}
+
+func TestOracleReportIsCheckedAgainWhenMined(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, reporter, replacement := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	feed := OracleFeed{Feed: "acme", Owner: alice.ID, Reporters: []string{reporter.ID}, MaxAge: 60, Version: 1}
+	feed.Signature = alice.sign(t, feed.signingMessage())
+	if err := bc.addOracleFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleFeed(); err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	report := OracleReport{Feed: "acme", Key: "price", Value: "1", Timestamp: now.Unix(), Reporter: reporter.ID}
+	report.Signature = reporter.sign(t, report.signingMessage())
+	if err := bc.addOracleReport(report, now); err != nil {
+		t.Fatal(err)
+	}
+	older := OracleReport{Feed: "acme", Key: "price", Value: "0", Timestamp: now.Unix() - 1, Reporter: reporter.ID}
+	older.Signature = reporter.sign(t, older.signingMessage())
+	if err := bc.addOracleReport(older, now); err == nil {
+		t.Fatal("report older than a pending report was added")
+	}
+
+	// The owner replaces the reporter before the report is mined
+	next := OracleFeed{Feed: "acme", Owner: alice.ID, Reporters: []string{replacement.ID}, MaxAge: 60, Version: 2}
+	next.Signature = alice.sign(t, next.signingMessage())
+	if err := bc.addOracleFeed(next); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.addOracleFeed(next); err == nil {
+		t.Fatal("second pending version of a feed was added")
+	}
+	if _, err := bc.mineOracleFeed(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleReport(); err == nil || !strings.Contains(err.Error(), "not a reporter") {
+		t.Fatalf("report of a replaced reporter was mined: %v", err)
+	}
+	if len(bc.getLastBlock().Data.OracleReports) != 0 {
+		t.Fatal("dropped report was stored")
+	}
+}
@@ -0,0 +1,536 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const BALANCE_TOLERANCE float64 = 1e-9 // Rounding error tolerated when replaying balances
+
+// blockReplay replays a block received from another node on top of the blocks before it
+// Each operation of the block is checked by the function the node uses to add it, with the
+// open block holding the received transactions that preceded the operation
+type blockReplay struct {
+	bc    *Blockchain // Replay of the received chain, its last block is the one being replayed
+	block Block       // Received block
+	used  []bool      // Transactions of the received block accounted for by an operation
+}
+
+// isSystemWallet checks if an address is a wallet whose coins only move through the chain rules
+func isSystemWallet(address string) bool {
+	switch address {
+	case BLOCK_REWARD_WALLET, SCHEDULE_ESCROW_WALLET, HTLC_ESCROW_WALLET, BEACON_ESCROW_WALLET:
+		return true
+	}
+	return false
+}
+
+// validateChain checks a chain received from another node: its first block must be built on our
+// genesis block, its blocks must be linked and mined at our difficulty, except the open last block, and replaying
+// their operations must reproduce their signatures, contract states, payments and balances
+func (bc *Blockchain) validateChain(chain []Block) error {
+	if len(chain) == 0 || chain[0].PreviousHash != "" || !chain[0].Timestamp.Equal(bc.GenesisBlock.Timestamp) {
+		return fmt.Errorf("genesis block does not match")
+	}
+
+	replay := &Blockchain{
+		GenesisBlock:   bc.GenesisBlock,
+		ContractStates: make(map[string]*ContractState),
+		EventBroker:    NewEventBroker(),
+		Difficulty:     bc.Difficulty,
+		RewardPerBlock: bc.RewardPerBlock,
+		MaxCoins:       bc.MaxCoins,
+	}
+	balances := make(map[string]float64)
+	prefix := strings.Repeat("0", bc.Difficulty)
+	for i, block := range chain {
+		mined := i < len(chain)-1
+		if i > 0 && block.PreviousHash != chain[i-1].Hash {
+			return fmt.Errorf("block %d is not linked to the previous block", i)
+		}
+		if mined && (block.Hash != block.calculateHash() || !strings.HasPrefix(block.Hash, prefix)) {
+			return fmt.Errorf("block %d is not mined at difficulty %d", i, bc.Difficulty)
+		}
+
+		r := &blockReplay{bc: replay, block: block, used: make([]bool, len(block.Data.Transactions))}
+		if err := r.replay(mined); err != nil {
+			return fmt.Errorf("block %d: %v", i, err)
+		}
+
+		// No wallet, contract or escrow spends more than it holds
+		for j, tx := range block.Data.Transactions {
+			balances[tx.From] -= tx.Amount
+			balances[tx.To] += tx.Amount
+			if tx.From != BLOCK_REWARD_WALLET && balances[tx.From] < -BALANCE_TOLERANCE {
+				return fmt.Errorf("block %d: transaction %d overdraws %s", i, j, tx.From)
+			}
+		}
+	}
+	return nil
+}
+
+// open returns the block being replayed
+func (r *blockReplay) open() *Block {
+	return r.bc.getLastBlock()
+}
+
+// find returns the index of the first transaction of the received block not yet accounted for
+// that matches, -1 if none
+func (r *blockReplay) find(match func(tx Transaction) bool) int {
+	for j, tx := range r.block.Data.Transactions {
+		if !r.used[j] && match(tx) {
+			return j
+		}
+	}
+	return -1
+}
+
+// apply runs add with the open block holding the received transactions before tx, and checks it
+// appends exactly tx, or no transaction when tx is nil
+func (r *blockReplay) apply(tx *Transaction, add func() error) error {
+	txs := r.block.Data.Transactions
+	if tx == nil {
+		r.open().Data.Transactions = append([]Transaction(nil), txs...)
+		if err := add(); err != nil {
+			return err
+		}
+		if len(r.open().Data.Transactions) != len(txs) {
+			return fmt.Errorf("operation is missing its transaction")
+		}
+		return nil
+	}
+
+	j := r.find(func(candidate Transaction) bool { return candidate == *tx })
+	if j < 0 {
+		return fmt.Errorf("missing transaction of %v from %s to %s", tx.Amount, tx.From, tx.To)
+	}
+	r.open().Data.Transactions = append([]Transaction(nil), txs[:j]...)
+	if err := add(); err != nil {
+		return err
+	}
+	appended := r.open().Data.Transactions[j:]
+	if len(appended) != 1 || appended[0] != *tx {
+		return fmt.Errorf("transaction of %v from %s to %s does not match its operation", tx.Amount, tx.From, tx.To)
+	}
+	r.used[j] = true
+	return nil
+}
+
+// replay checks the operations of the received block in an order respecting their dependencies
+// and replaces the open block of the replay by the received block
+func (r *blockReplay) replay(mined bool) error {
+	data := r.block.Data
+	r.bc.Chain = append(r.bc.Chain, Block{PreviousHash: r.block.PreviousHash, Timestamp: r.block.Timestamp})
+
+	// Beacon rounds are closed when the block is opened, their shares come first
+	r.bc.closeBeaconRound()
+	for _, share := range r.open().Data.Transactions {
+		if j := r.find(func(tx Transaction) bool { return tx == share }); j >= 0 {
+			r.used[j] = true
+		} else {
+			return fmt.Errorf("missing beacon share of %s", share.To)
+		}
+	}
+	r.open().Data.Transactions = nil
+
+	for _, contract := range data.Contracts {
+		if err := r.replayDeployment(contract); err != nil {
+			return fmt.Errorf("contract %s: %v", contract.ContractID, err)
+		}
+	}
+	// Schedules created by deployments were recorded by their replay, the others are signed by their owner
+	created := r.open().Data.Schedules
+	r.open().Data.Schedules = nil
+	for _, schedule := range data.Schedules {
+		if schedule.Owner == SCHEDULE_ESCROW_WALLET {
+			if len(created) == 0 || created[0].ScheduleID != schedule.ScheduleID {
+				return fmt.Errorf("schedule %s was not created by a deployment", schedule.ScheduleID)
+			}
+			r.open().Data.Schedules = append(r.open().Data.Schedules, created[0])
+			created = created[1:]
+			continue
+		}
+		err := r.apply(&Transaction{From: schedule.Owner, To: SCHEDULE_ESCROW_WALLET, Amount: schedule.PrepaidGas}, func() error {
+			if err := r.bc.addSchedule(schedule); err != nil {
+				return err
+			}
+			_, err := r.bc.mineSchedule()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("schedule %s: %v", schedule.ScheduleID, err)
+		}
+	}
+	if len(created) > 0 {
+		return fmt.Errorf("missing schedule %s created by a deployment", created[0].ScheduleID)
+	}
+	for _, lock := range data.HashLocks {
+		err := r.apply(&Transaction{From: lock.From, To: HTLC_ESCROW_WALLET, Amount: lock.Amount}, func() error {
+			if _, err := r.bc.lockHash(lock); err != nil {
+				return err
+			}
+			_, err := r.bc.mineHashLock()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("hash lock %s: %v", lock.Hashlock, err)
+		}
+	}
+	for _, settlement := range data.HashLockSettlements {
+		lock, _ := r.bc.findHashLock(settlement.Hashlock)
+		if lock == nil {
+			return fmt.Errorf("hash lock %s not found", settlement.Hashlock)
+		}
+		to := lock.To
+		if settlement.Refund {
+			to = lock.From
+		}
+		err := r.apply(&Transaction{From: HTLC_ESCROW_WALLET, To: to, Amount: lock.Amount}, func() error {
+			if _, err := r.bc.settleHashLock(settlement); err != nil {
+				return err
+			}
+			_, err := r.bc.mineHashLockSettlement()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("hash lock %s: %v", settlement.Hashlock, err)
+		}
+	}
+	for _, feed := range data.OracleFeeds {
+		err := r.apply(nil, func() error {
+			if err := r.bc.addOracleFeed(feed); err != nil {
+				return err
+			}
+			_, err := r.bc.mineOracleFeed()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("oracle feed %s: %v", feed.Feed, err)
+		}
+	}
+	for _, report := range data.OracleReports {
+		// The time the report was received is unknown, it is checked against its own timestamp
+		observed := time.Unix(report.Timestamp, 0)
+		err := r.apply(nil, func() error {
+			if err := r.bc.addOracleReport(report, observed); err != nil {
+				return err
+			}
+			_, err := r.bc.mineOracleReport()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("oracle report of %s: %v", report.Key, err)
+		}
+	}
+	for _, commit := range data.BeaconCommits {
+		err := r.apply(&Transaction{From: commit.Participant, To: BEACON_ESCROW_WALLET, Amount: commit.Deposit}, func() error {
+			if err := r.bc.addBeaconCommit(commit); err != nil {
+				return err
+			}
+			_, err := r.bc.mineBeaconCommit()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("beacon commit of %s: %v", commit.Participant, err)
+		}
+	}
+	for _, reveal := range data.BeaconReveals {
+		// The deposit refunded is the one of the commitment, a missing commitment fails the reveal
+		deposit := 0.0
+		if commit, _ := r.bc.findBeaconCommit(reveal.Round, reveal.Participant); commit != nil {
+			deposit = commit.Deposit
+		}
+		err := r.apply(&Transaction{From: BEACON_ESCROW_WALLET, To: reveal.Participant, Amount: deposit}, func() error {
+			if err := r.bc.addBeaconReveal(reveal); err != nil {
+				return err
+			}
+			_, err := r.bc.mineBeaconReveal()
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("beacon reveal of %s: %v", reveal.Participant, err)
+		}
+	}
+
+	// Upgrades are applied, with the upgrades published before them, before the first execution
+	// running their version
+	upgrades := data.ContractUpgrades
+	for _, execution := range data.ContractExecutionHistory {
+		versions := execution.versions()
+		published := 0
+		for k, upgrade := range upgrades {
+			if upgrade.Version <= versions[upgrade.ContractID] {
+				published = k + 1
+			}
+		}
+		for _, upgrade := range upgrades[:published] {
+			if err := r.replayUpgrade(upgrade); err != nil {
+				return err
+			}
+		}
+		upgrades = upgrades[published:]
+		if err := r.replayExecution(execution); err != nil {
+			return fmt.Errorf("execution of %s on contract %s: %v", execution.Method, execution.ContractID, err)
+		}
+	}
+	for _, upgrade := range upgrades {
+		if err := r.replayUpgrade(upgrade); err != nil {
+			return err
+		}
+	}
+
+	// Cancellations remove the pending executions of their schedule, none can follow them
+	for _, cancellation := range data.ScheduleCancellations {
+		schedule, _ := r.bc.findSchedule(cancellation.ScheduleID)
+		var refund *Transaction
+		if schedule != nil && cancellation.Refund > 0 {
+			refund = &Transaction{From: SCHEDULE_ESCROW_WALLET, To: schedule.Owner, Amount: cancellation.Refund}
+		}
+		err := r.apply(refund, func() error {
+			_, err := r.bc.cancelSchedule(cancellation.ScheduleID, cancellation.Signature)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("cancellation of schedule %s: %v", cancellation.ScheduleID, err)
+		}
+	}
+
+	if err := r.replayReward(mined); err != nil {
+		return err
+	}
+
+	// The remaining transactions are transfers between wallets, allowed only if they would
+	// pass the validation of the transaction pool
+	for j, tx := range data.Transactions {
+		if r.used[j] {
+			continue
+		}
+		if tx.From == tx.To || tx.Amount <= 0 || isSystemWallet(tx.From) || isSystemWallet(tx.To) || r.bc.isContract(tx.From) {
+			return fmt.Errorf("transaction %d from %s to %s is not allowed", j, tx.From, tx.To)
+		}
+		// The resolution of a name is recorded by the node that resolved it, through a registry
+		// deployed on the chain
+		if tx.Name != "" || tx.Registry != "" {
+			if _, err := r.bc.getNameRegistry(tx.Registry); err != nil || !strings.HasSuffix(tx.Name, NAME_SUFFIX) {
+				return fmt.Errorf("transaction %d to %s was not resolved through a name registry", j, tx.Name)
+			}
+		}
+	}
+
+	// The replayed operations must be those of the received block
+	replayed := r.open().Data
+	replayed.Transactions = data.Transactions
+	got, _ := json.Marshal(replayed)
+	want, _ := json.Marshal(data)
+	if string(got) != string(want) {
+		return fmt.Errorf("operations do not match their replay")
+	}
+	r.bc.Chain[len(r.bc.Chain)-1] = r.block
+	return nil
+}
+
+// replayDeployment checks a deployment as the deployment pool and its mining do, its code must be
+// the one initialized from its specification
+func (r *blockReplay) replayDeployment(contract SmartContract) error {
+	if r.bc.findContractByID(contract.ContractID) != nil {
+		return fmt.Errorf("contract ID already used")
+	}
+	fee := contract.deploymentFee()
+	j := r.find(func(tx Transaction) bool {
+		return tx.From == contract.Wallet && tx.Amount == fee && !isSystemWallet(tx.To)
+	})
+	if j < 0 {
+		return fmt.Errorf("missing deployment fee")
+	}
+	r.open().Data.Transactions = append([]Transaction(nil), r.block.Data.Transactions[:j]...)
+
+	code, err := newCode(contract.Type)
+	if err != nil {
+		return err
+	}
+	initialized := contract
+	initialized.Code = code
+	if err := r.bc.addContract(initialized); err != nil {
+		return err
+	}
+	r.bc.ContractDeploymentPool = nil
+	got, _ := json.Marshal(initialized.Code)
+	want, _ := json.Marshal(contract.Code)
+	if string(got) != string(want) {
+		return fmt.Errorf("code does not match its specification")
+	}
+
+	r.bc.ContractDeploymentPool = []SmartContract{contract}
+	if _, err := r.bc.mineContractDeployment(r.block.Data.Transactions[j].To); err != nil {
+		return err
+	}
+	r.used[j] = true
+	for _, schedule := range contract.deploymentSchedules() {
+		prepaid := Transaction{From: contract.Wallet, To: SCHEDULE_ESCROW_WALLET, Amount: schedule.PrepaidGas}
+		k := r.find(func(tx Transaction) bool { return tx == prepaid })
+		if k < 0 {
+			return fmt.Errorf("missing prepaid gas of schedule %s", schedule.ScheduleID)
+		}
+		r.used[k] = true
+	}
+	return nil
+}
+
+// replayUpgrade checks an upgrade as the upgrade pool and its mining do
+func (r *blockReplay) replayUpgrade(upgrade ContractUpgrade) error {
+	contract := r.bc.findContractByID(upgrade.ContractID)
+	if contract == nil {
+		return fmt.Errorf("upgrade of contract %s: contract not found", upgrade.ContractID)
+	}
+	j := r.find(func(tx Transaction) bool {
+		return tx.From == contract.Wallet && tx.Amount == upgrade.Fee && !isSystemWallet(tx.To)
+	})
+	if j < 0 {
+		return fmt.Errorf("upgrade of contract %s: missing upgrade fee", upgrade.ContractID)
+	}
+	r.open().Data.Transactions = append([]Transaction(nil), r.block.Data.Transactions[:j]...)
+
+	pending, err := r.bc.addContractUpgrade(upgrade)
+	if err != nil {
+		return fmt.Errorf("upgrade of contract %s: %v", upgrade.ContractID, err)
+	}
+	if pending.Version != upgrade.Version {
+		return fmt.Errorf("upgrade of contract %s should be version %d", upgrade.ContractID, pending.Version)
+	}
+	if _, err := r.bc.mineContractUpgrade(r.block.Data.Transactions[j].To); err != nil {
+		return err
+	}
+	r.used[j] = true
+	upgrades := r.open().Data.ContractUpgrades
+	upgrades[len(upgrades)-1].Timestamp = upgrade.Timestamp
+	return nil
+}
+
+// replayExecution runs an execution again on the state it was mined on and checks its receipt
+func (r *blockReplay) replayExecution(execution ContractExecution) error {
+	if execution.ScheduleID == "" {
+		if err := verifySignature(execution.Caller, execution.signingMessage(), execution.Signature); err != nil {
+			return fmt.Errorf("execution signature verification failed: %v", err)
+		}
+		if r.bc.isExecutionNonceUsed(execution.Caller, execution.Nonce) {
+			return fmt.Errorf("execution nonce %d already used", execution.Nonce)
+		}
+	} else if err := r.bc.checkScheduledExecution(execution); err != nil {
+		return err
+	}
+	if execution.ConsumedGas < 0 || execution.ConsumedGas > execution.GasLimit {
+		return fmt.Errorf("consumed gas exceeds the gas limit")
+	}
+
+	// The gas payment is followed by the transfers of the execution
+	txs := r.block.Data.Transactions
+	gas := Transaction{From: execution.gasPayer(), To: execution.Miner, Amount: execution.ConsumedGas}
+	j := r.find(func(tx Transaction) bool { return tx == gas })
+	if j < 0 || j+1+len(execution.Transfers) > len(txs) {
+		return fmt.Errorf("missing gas payment")
+	}
+	for k, transfer := range execution.Transfers {
+		if r.used[j+1+k] || txs[j+1+k] != transfer {
+			return fmt.Errorf("missing transfer %d", k)
+		}
+	}
+	r.open().Data.Transactions = append([]Transaction(nil), txs[:j]...)
+
+	if execution.ScheduleID == "" && r.bc.getBalance(execution.Caller) < execution.GasLimit+execution.Value {
+		return fmt.Errorf("caller cannot afford the gas limit and value")
+	}
+	request := ContractExecution{
+		ContractID: execution.ContractID,
+		Caller:     execution.Caller,
+		Method:     execution.Method,
+		Args:       execution.Args,
+		Value:      execution.Value,
+		GasLimit:   execution.GasLimit,
+		ScheduleID: execution.ScheduleID,
+		Nonce:      execution.Nonce,
+		Signature:  execution.Signature,
+	}
+	ctx, result, err := r.bc.runContract(request)
+	if errors.Is(err, errExecutionAborted) {
+		return fmt.Errorf("execution cannot be replayed on this node: %v", err)
+	}
+	switch {
+	case execution.Error != "":
+		if err == nil {
+			return fmt.Errorf("execution succeeds when replayed")
+		}
+	case err != nil:
+		return fmt.Errorf("execution fails when replayed: %v", err)
+	default:
+		request.Result = result
+		request.States = make(map[string]json.RawMessage, len(ctx.states))
+		for contractID, state := range ctx.states {
+			request.States[contractID], _ = json.Marshal(state)
+		}
+		request.Events = ctx.events
+		request.Transfers = ctx.transfers
+		request.Calls = ctx.calls
+		request.Version = ctx.version
+		request.ConsumedGas = ctx.gasUsed
+		if !sameReceipt(request, execution) {
+			return fmt.Errorf("receipt does not match its replay")
+		}
+		for contractID, state := range ctx.states {
+			r.bc.ContractStates[contractID] = state
+		}
+	}
+
+	for k := j; k <= j+len(execution.Transfers); k++ {
+		r.used[k] = true
+	}
+	r.open().Data.ContractExecutionHistory = append(r.open().Data.ContractExecutionHistory, execution)
+	return nil
+}
+
+// sameReceipt checks if two receipts of a successful execution record the same outcome
+func sameReceipt(a ContractExecution, b ContractExecution) bool {
+	outcome := func(e ContractExecution) string {
+		data, _ := json.Marshal([]any{e.Result, e.States, e.Events, e.Transfers, e.Calls, e.Version, e.ConsumedGas})
+		return string(data)
+	}
+	return outcome(a) == outcome(b)
+}
+
+// versions returns the code version of each contract an execution ran, including its calls
+func (e ContractExecution) versions() map[string]int {
+	versions := map[string]int{e.ContractID: e.Version}
+	var visit func(calls []ContractCall)
+	visit = func(calls []ContractCall) {
+		for _, call := range calls {
+			if call.Version > versions[call.ContractID] {
+				versions[call.ContractID] = call.Version
+			}
+			visit(call.Calls)
+		}
+	}
+	visit(e.Calls)
+	return versions
+}
+
+// replayReward checks the block reward, the last transaction of each mined block as long as
+// the maximum coins are not reached, and absent from the open block
+func (r *blockReplay) replayReward(mined bool) error {
+	txs := r.block.Data.Transactions
+	r.open().Data.Transactions = nil
+	j := r.find(func(tx Transaction) bool { return tx.From == BLOCK_REWARD_WALLET })
+	rewarded := mined && r.bc.getMinedCoins()+r.bc.RewardPerBlock <= r.bc.MaxCoins
+	if !rewarded {
+		if j >= 0 {
+			return fmt.Errorf("unexpected block reward")
+		}
+		return nil
+	}
+	if j != len(txs)-1 || txs[j].Amount != r.bc.RewardPerBlock || isSystemWallet(txs[j].To) {
+		return fmt.Errorf("invalid block reward")
+	}
+	r.used[j] = true
+	return nil
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// receive round trips a chain through JSON as POST /chain does
+func receive(t *testing.T, chain []Block) []Block {
+	t.Helper()
+	data, err := json.Marshal(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received []Block
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	return received
+}
+
+// remine mines again the blocks of a tampered chain from the first tampered one, the last block stays open
+func remine(chain []Block, from int, difficulty int) {
+	for i := from; i < len(chain); i++ {
+		if i > 0 {
+			chain[i].PreviousHash = chain[i-1].Hash
+		}
+		chain[i].Nonce = 0
+		chain[i].Hash = chain[i].calculateHash()
+		if i < len(chain)-1 {
+			chain[i].mine(difficulty)
+		}
+	}
+}
+
+// buildBusyChain builds a chain using every kind of operation the node stores in blocks
+func buildBusyChain(t *testing.T) (*Blockchain, *testWallet, *testWallet) {
+	t.Helper()
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 2)
+	mineBlocks(t, bc, bob.ID, 1)
+
+	// Beacon commit during the commit phase of round 0
+	secret := strings.Repeat("ab", BEACON_SECRET_SIZE)
+	commit := BeaconCommit{Round: 0, Participant: alice.ID, Commitment: beaconCommitment(secret, alice.ID), Deposit: BEACON_MIN_DEPOSIT}
+	commit.Signature = alice.sign(t, commit.signingMessage())
+	if err := bc.addBeaconCommit(commit); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineBeaconCommit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Plain transfer, contract with value, transfers and an upgrade
+	if _, err := bc.addTransaction(Transaction{From: alice.ID, To: bob.ID, Amount: 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.mineTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, executeContract(t, bc, bob, counter, "increment", nil, 1))
+	upgrade := ContractUpgrade{ContractID: counter, Type: CONTRACT_EXAMPLE_V2_TYPE, Version: 2}
+	upgrade.Signature = alice.sign(t, upgrade.signingMessage())
+	if _, err := bc.addContractUpgrade(upgrade); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineContractUpgrade(bob.ID); err != nil {
+		t.Fatal(err)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+	mineBlocks(t, bc, alice.ID, 1)
+
+	spec := fmt.Sprintf(`{ "buyer": %q, "seller": %q, "arbiter": %q, "amount": 3, "timeout": 500 }`, bob.ID, alice.ID, newTestWallet(t).ID)
+	escrow := deployContract(t, bc, alice, CONTRACT_ESCROW_TYPE, spec)
+	mustSucceed(t, executeContract(t, bc, bob, escrow, "deposit", nil, 3))
+	mustSucceed(t, executeContract(t, bc, bob, escrow, "release", nil, 0))
+	mustFail(t, executeContract(t, bc, bob, escrow, "release", nil, 0))
+
+	// Schedule running at the next block
+	schedule := ContractSchedule{
+		ScheduleID:  "schedule-1",
+		Owner:       bob.ID,
+		ContractID:  counter,
+		Method:      "increment",
+		StartHeight: len(bc.Chain),
+		Interval:    1,
+		GasLimit:    1,
+		PrepaidGas:  2,
+		Nonce:       bob.nextNonce(),
+	}
+	schedule.Signature = bob.sign(t, schedule.signingMessage())
+	if err := bc.addSchedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	mineBlocks(t, bc, alice.ID, 1)
+
+	// Beacon reveal during the reveal phase of round 0
+	for len(bc.Chain)-1 < BEACON_COMMIT_BLOCKS {
+		mineBlocks(t, bc, bob.ID, 1)
+	}
+	if err := revealSecret(bc, BeaconReveal{Round: 0, Participant: alice.ID, Secret: secret}); err != nil {
+		t.Fatal(err)
+	}
+	mustSucceed(t, mineScheduled(t, bc, bob.ID))
+
+	// Hash lock claimed with its preimage
+	preimage := "73776170"
+	digest := sha256.Sum256([]byte("swap"))
+	lock := HashLock{Hashlock: hex.EncodeToString(digest[:]), From: alice.ID, To: bob.ID, Amount: 2, RefundHeight: len(bc.Chain) + 10}
+	lock.Signature = alice.sign(t, lock.signingMessage())
+	if _, err := bc.lockHash(lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineHashLock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := settleCoins(bc, HashLockSettlement{Hashlock: lock.Hashlock, Preimage: preimage}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Oracle feed with a report
+	feed := OracleFeed{Feed: "acme", Owner: alice.ID, Reporters: []string{bob.ID}, MaxAge: 60, Version: 1}
+	feed.Signature = alice.sign(t, feed.signingMessage())
+	if err := bc.addOracleFeed(feed); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleFeed(); err != nil {
+		t.Fatal(err)
+	}
+	report := OracleReport{Feed: "acme", Key: alice.ID, Value: "digest", Timestamp: time.Now().Unix(), Reporter: bob.ID}
+	report.Signature = bob.sign(t, report.signingMessage())
+	if err := bc.addOracleReport(report, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineOracleReport(); err != nil {
+		t.Fatal(err)
+	}
+
+	cancelSignature := bob.sign(t, signingMessage("cancel-schedule", schedule.ScheduleID))
+	if _, err := bc.cancelSchedule(schedule.ScheduleID, cancelSignature); err != nil {
+		t.Fatal(err)
+	}
+
+	// Close beacon round 0 and leave operations in the open block
+	for len(bc.Chain)-1 < BEACON_ROUND_BLOCKS+1 {
+		mineBlocks(t, bc, bob.ID, 1)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+	return bc, alice, bob
+}
+
+// mustMarshal encodes v as JSON
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// mineScheduled mines the pending scheduled execution
+func mineScheduled(t *testing.T, bc *Blockchain, miner string) ContractExecution {
+	t.Helper()
+	execution, err := bc.mineContractExecution(miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if execution.ScheduleID == "" {
+		t.Fatal("mined execution is not scheduled")
+	}
+	return execution
+}
+
+func TestReplaceChainAcceptsValidChain(t *testing.T) {
+	bc, _, _ := buildBusyChain(t)
+
+	node := newTestBlockchain()
+	if err := node.replaceChain(receive(t, bc.Chain)); err != nil {
+		t.Fatalf("valid chain was rejected: %v", err)
+	}
+	if len(node.Chain) != len(bc.Chain) {
+		t.Fatalf("chain has %d blocks, expected %d", len(node.Chain), len(bc.Chain))
+	}
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			for contractID := range execution.States {
+				want, _ := bc.getContractState(contractID)
+				got, err := node.getContractState(contractID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(mustMarshal(t, got)) != string(mustMarshal(t, want)) {
+					t.Fatalf("state of %s not restored", contractID)
+				}
+			}
+		}
+	}
+}
+
+func TestReplaceChainRejectsTamperedChains(t *testing.T) {
+	bc, alice, bob := buildBusyChain(t)
+	mallory := newTestWallet(t)
+
+	// findBlock returns the index of the first block with an operation
+	findBlock := func(chain []Block, has func(data BlockData) bool) int {
+		for i, block := range chain {
+			if has(block.Data) {
+				return i
+			}
+		}
+		t.Fatal("no block with the operation")
+		return -1
+	}
+
+	tests := []struct {
+		name   string
+		tamper func(chain []Block) int // Returns the first block to mine again, 0 for none
+		reason string
+	}{
+		{"other genesis", func(chain []Block) int {
+			chain[0].Timestamp = chain[0].Timestamp.Add(time.Second)
+			remine(chain, 0, 1)
+			return 0
+		}, "genesis"},
+		{"unmined block", func(chain []Block) int {
+			chain[1].Nonce++
+			chain[1].Hash = chain[1].calculateHash()
+			for strings.HasPrefix(chain[1].Hash, "0") {
+				chain[1].Nonce++
+				chain[1].Hash = chain[1].calculateHash()
+			}
+			for i := 2; i < len(chain); i++ {
+				chain[i].PreviousHash = chain[i-1].Hash
+				chain[i].Hash = chain[i].calculateHash()
+			}
+			return 0
+		}, "mined"},
+		{"broken link", func(chain []Block) int {
+			chain[3].PreviousHash = chain[1].Hash
+			return 0
+		}, "linked"},
+		{"stolen coins", func(chain []Block) int {
+			i := 2
+			chain[i].Data.Transactions = append([]Transaction{{From: alice.ID, To: mallory.ID, Amount: 1000}}, chain[i].Data.Transactions...)
+			return i
+		}, "overdraws"},
+		{"inflated reward", func(chain []Block) int {
+			last := len(chain[1].Data.Transactions) - 1
+			chain[1].Data.Transactions[last].Amount *= 2
+			return 1
+		}, "reward"},
+		{"escrow drained", func(chain []Block) int {
+			i := 2
+			chain[i].Data.Transactions = append([]Transaction{{From: HTLC_ESCROW_WALLET, To: mallory.ID, Amount: 1}}, chain[i].Data.Transactions...)
+			return i
+		}, "not allowed"},
+		{"forged execution", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.ContractExecutionHistory) > 0 })
+			chain[i].Data.ContractExecutionHistory[0].Signature = mallory.sign(t, chain[i].Data.ContractExecutionHistory[0].signingMessage())
+			return i
+		}, "signature"},
+		{"forged contract state", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.ContractExecutionHistory) > 0 })
+			execution := &chain[i].Data.ContractExecutionHistory[0]
+			for contractID := range execution.States {
+				execution.States[contractID] = json.RawMessage(`{"code":{"number_of_executions":1000},"storage":{}}`)
+			}
+			return i
+		}, "replay"},
+		{"forged deployment", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.Contracts) > 0 })
+			chain[i].Data.Contracts[0].Signature = mallory.sign(t, chain[i].Data.Contracts[0].signingMessage())
+			return i
+		}, "signature"},
+		{"dropped deployment schedule", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool {
+				return len(data.Schedules) > 0 && data.Schedules[0].Owner == SCHEDULE_ESCROW_WALLET
+			})
+			chain[i].Data.Schedules = chain[i].Data.Schedules[1:]
+			return i
+		}, "missing schedule"},
+		{"replayed nonce", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.ContractExecutionHistory) > 0 })
+			data := &chain[i].Data
+			execution := data.ContractExecutionHistory[0]
+			data.ContractExecutionHistory = append(data.ContractExecutionHistory, execution)
+			data.Transactions = append(data.Transactions, Transaction{From: execution.Caller, To: execution.Miner, Amount: execution.ConsumedGas})
+			data.Transactions = append(data.Transactions, execution.Transfers...)
+			return i
+		}, "nonce"},
+		{"forged hash lock claim", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.HashLockSettlements) > 0 })
+			chain[i].Data.HashLockSettlements[0].Preimage = "00"
+			return i
+		}, "preimage"},
+		{"forged oracle report", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.OracleReports) > 0 })
+			chain[i].Data.OracleReports[0].Value = "forged"
+			return i
+		}, "signature"},
+		{"forged beacon round", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.BeaconRounds) > 0 })
+			chain[i].Data.BeaconRounds[0].Value = strings.Repeat("00", BEACON_SECRET_SIZE)
+			return i
+		}, "replay"},
+		{"refund of cancelled schedule", func(chain []Block) int {
+			i := findBlock(chain, func(data BlockData) bool { return len(data.ScheduleCancellations) > 0 })
+			cancellation := &chain[i].Data.ScheduleCancellations[0]
+			for j, tx := range chain[i].Data.Transactions {
+				if tx.From == SCHEDULE_ESCROW_WALLET && tx.To == bob.ID && tx.Amount == cancellation.Refund {
+					chain[i].Data.Transactions[j].Amount += 1
+				}
+			}
+			cancellation.Refund += 1
+			return i
+		}, "does not match"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain := receive(t, bc.Chain)
+			if from := test.tamper(chain); from > 0 {
+				remine(chain, from, bc.Difficulty)
+			}
+			node := newTestBlockchain()
+			err := node.replaceChain(chain)
+			if err == nil {
+				t.Fatal("tampered chain was accepted")
+			}
+			if !strings.Contains(err.Error(), test.reason) {
+				t.Fatalf("rejected for %q, expected %q", err, test.reason)
+			}
+			if len(node.Chain) != 1 {
+				t.Fatal("chain was replaced")
+			}
+		})
+	}
+}
+
+func TestReplaceChainRevalidatesPools(t *testing.T) {
+	bc, alice, _ := buildBusyChain(t)
+	node := newTestBlockchain()
+	carol := newTestWallet(t)
+	mineBlocks(t, node, carol.ID, 1)
+	if _, err := node.addTransaction(Transaction{From: carol.ID, To: alice.ID, Amount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// An execution of the received chain submitted locally is dropped as already mined
+	var mined ContractExecution
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			if execution.ScheduleID == "" {
+				mined = execution
+			}
+		}
+	}
+	node.ContractExecutionPool = append(node.ContractExecutionPool, mined)
+
+	if err := node.replaceChain(receive(t, bc.Chain)); err != nil {
+		t.Fatal(err)
+	}
+	if len(node.TransactionPool) != 0 {
+		t.Fatal("transaction of a wallet without coins on the new chain kept in the pool")
+	}
+	if len(node.ContractExecutionPool) != 0 {
+		t.Fatal("execution mined on the new chain kept in the pool")
+	}
+}
+
+func TestMineTransactionRevalidatesBalance(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	balance := bc.getBalance(alice.ID)
+
+	for i := 0; i < 2; i++ {
+		if _, err := bc.addTransaction(Transaction{From: alice.ID, To: bob.ID, Amount: balance}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := bc.mineTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bc.mineTransaction(); err == nil {
+		t.Fatal("transaction spending coins already spent was mined")
+	}
+	if got := bc.getBalance(alice.ID); got != 0 {
+		t.Fatalf("balance %v, expected 0", got)
+	}
+	if got := bc.getBalance(bob.ID); got != balance {
+		t.Fatalf("recipient balance %v, expected %v", got, balance)
+	}
+}
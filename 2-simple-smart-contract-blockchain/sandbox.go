@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"runtime/metrics"
+	"sync"
+	"time"
+)
+
+const MEMORY_POLL_INTERVAL time.Duration = 10 * time.Millisecond
+
+// errExecutionAborted reports an execution the node gave up on, exceeding its local time or
+// memory limits. Those limits depend on the node, the execution is neither mined nor replayed
+var errExecutionAborted = errors.New("execution aborted by the node")
+
+// sandbox tracks the code of a contract running on behalf of the node. The code holds the
+// sandbox while it uses the chain through its context, so the node gives up on it only between
+// two uses and the abandoned code can no longer reach the chain afterwards
+type sandbox struct {
+	mu      sync.Mutex
+	aborted bool
+	written int // Bytes stored or emitted by the execution, including its calls
+}
+
+// enter holds the sandbox for a use of the chain, failing once the node gave up on the code
+func (s *sandbox) enter() error {
+	s.mu.Lock()
+	if s.aborted {
+		s.mu.Unlock()
+		return fmt.Errorf("execution aborted")
+	}
+	return nil
+}
+
+// leave releases the sandbox after a use of the chain
+func (s *sandbox) leave() {
+	s.mu.Unlock()
+}
+
+// abort gives up on the code, waiting for its current use of the chain to end
+func (s *sandbox) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.aborted = true
+}
+
+// isAborted checks if the node gave up on the code
+func (s *sandbox) isAborted() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.aborted
+}
+
+// write accounts for bytes the code stores or emits, failing beyond the output limit
+// It must be called while holding the sandbox
+func (s *sandbox) write(bytes int) error {
+	s.written += bytes
+	if s.written > MAX_EXECUTION_OUTPUT {
+		return fmt.Errorf("execution exceeded the output limit of %d bytes", MAX_EXECUTION_OUTPUT)
+	}
+	return nil
+}
+
+// guardedReader gives code running in a sandbox read-only access to the chain until the node
+// gives up on it, reads made afterwards return zero values or fail
+type guardedReader struct {
+	chainReader
+	sandbox *sandbox
+}
+
+// Balance returns the balance of an address
+func (r guardedReader) Balance(address string) float64 {
+	if r.sandbox.enter() != nil {
+		return 0
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.Balance(address)
+}
+
+// IsContract checks if an address is a deployed contract
+func (r guardedReader) IsContract(address string) bool {
+	if r.sandbox.enter() != nil {
+		return false
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.IsContract(address)
+}
+
+// ContractType returns the type of the current code version of a contract
+func (r guardedReader) ContractType(address string) (string, bool) {
+	if r.sandbox.enter() != nil {
+		return "", false
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.ContractType(address)
+}
+
+// BlockHeight returns the index of the block being built
+func (r guardedReader) BlockHeight() int {
+	if r.sandbox.enter() != nil {
+		return 0
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.BlockHeight()
+}
+
+// BlockTime returns the timestamp of the block being built
+func (r guardedReader) BlockTime() time.Time {
+	if r.sandbox.enter() != nil {
+		return time.Time{}
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.BlockTime()
+}
+
+// Oracle returns the latest observation of a key of an oracle feed reported in a mined block
+func (r guardedReader) Oracle(feed string, key string) (OracleObservation, error) {
+	if err := r.sandbox.enter(); err != nil {
+		return OracleObservation{}, err
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.Oracle(feed, key)
+}
+
+// Randomness returns the hex encoded random value of a closed beacon round
+func (r guardedReader) Randomness(round int) (string, error) {
+	if err := r.sandbox.enter(); err != nil {
+		return "", err
+	}
+	defer r.sandbox.leave()
+	return r.chainReader.Randomness(round)
+}
+
+// allocatedBytes returns the bytes allocated by the node since it started
+func allocatedBytes() uint64 {
+	sample := []metrics.Sample{{Name: "/gc/heap/allocs:bytes"}}
+	metrics.Read(sample)
+	return sample[0].Value.Uint64()
+}
+
+// runSandboxed runs contract code in its own goroutine, recovering its panics and giving up on it
+// when it exceeds the execution timeout or allocates more than the memory limit. Allocations are
+// measured for the whole node while the code runs, requests being handled one at a time. Go code
+// cannot be stopped from outside: the abandoned goroutine fails at its next use of the chain, and
+// one looping without using it keeps running
+func runSandboxed(s *sandbox, fn func() (string, error)) (string, error) {
+	type outcome struct {
+		result string
+		err    error
+	}
+	done := make(chan outcome, 1)
+	allocated := allocatedBytes()
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- outcome{err: fmt.Errorf("contract panicked: %v", r)}
+			}
+		}()
+		result, err := fn()
+		done <- outcome{result: result, err: err}
+	}()
+
+	timeout := time.NewTimer(EXECUTION_TIMEOUT)
+	defer timeout.Stop()
+	poll := time.NewTicker(MEMORY_POLL_INTERVAL)
+	defer poll.Stop()
+	for {
+		select {
+		case o := <-done:
+			return o.result, o.err
+		case <-timeout.C:
+			s.abort()
+			return "", fmt.Errorf("%w: timed out after %v", errExecutionAborted, EXECUTION_TIMEOUT)
+		case <-poll.C:
+			if allocatedBytes()-allocated > MAX_EXECUTION_MEMORY {
+				s.abort()
+				return "", fmt.Errorf("%w: allocated more than %d bytes", errExecutionAborted, MAX_EXECUTION_MEMORY)
+			}
+		}
+	}
+}
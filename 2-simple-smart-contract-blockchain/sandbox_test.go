@@ -0,0 +1,199 @@
+package main
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const TEST_SANDBOX_TYPE string = "test_sandbox"
+
+// loopExited receives the error ending the loop of an abandoned execution
+var loopExited = make(chan error, 1)
+
+func init() {
+	registerCode(TEST_SANDBOX_TYPE, func() Code { return &testSandboxCode{} })
+}
+
+// testSandboxCode misbehaves in the ways the sandbox must contain
+type testSandboxCode struct{}
+
+func (sc *testSandboxCode) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "loop":
+		// Keeps reading the chain until the node gives up on the execution
+		var value string
+		for {
+			if _, err := ctx.GetStorage("key", &value); err != nil && strings.Contains(err.Error(), "aborted") {
+				loopExited <- err
+				return "", err
+			}
+		}
+	case "hoard":
+		// Keeps allocating memory, without using gas, until the node gives up on the execution
+		var hoard [][]byte
+		var value string
+		for {
+			hoard = append(hoard, make([]byte, 1<<20))
+			if _, err := ctx.GetStorage("key", &value); err != nil {
+				return strconv.Itoa(len(hoard)), err
+			}
+		}
+	case "store":
+		var size int
+		if err := ctx.Arg("size", &size); err != nil {
+			return "", err
+		}
+		return "", ctx.SetStorage("blob", strings.Repeat("x", size))
+	case "emit":
+		var size int
+		if err := ctx.Arg("size", &size); err != nil {
+			return "", err
+		}
+		return "", ctx.Emit("Blob", nil, strings.Repeat("x", size))
+	case "panic":
+		panic("boom")
+	}
+	return "", nil
+}
+
+func (sc *testSandboxCode) Validate(chain ChainReader) bool {
+	return true
+}
+
+// mineAbortedExecution submits an execution the node gives up on and checks it is dropped
+// without being charged or recorded, returning the error of its mining
+func mineAbortedExecution(t *testing.T, bc *Blockchain, w *testWallet, contractID string, method string) error {
+	t.Helper()
+	if err := bc.addContractExecution(signedExecution(t, w, contractID, method, nil, 0)); err != nil {
+		t.Fatal(err)
+	}
+	before := bc.getBalance(w.ID) + 10 // The gas limit reserved by the pending execution
+	_, err := bc.mineContractExecution(w.ID)
+	if !errors.Is(err, errExecutionAborted) {
+		t.Fatalf("aborted execution was mined: %v", err)
+	}
+	if len(bc.ContractExecutionPool) != 0 || len(bc.getLastBlock().Data.ContractExecutionHistory) != 0 {
+		t.Fatal("aborted execution was kept")
+	}
+	if after := bc.getBalance(w.ID); after != before {
+		t.Fatalf("caller balance %v, expected %v", after, before)
+	}
+	return err
+}
+
+func TestSandboxTimesOutAndStopsAbandonedCode(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, TEST_SANDBOX_TYPE, "")
+
+	start := time.Now()
+	if err := mineAbortedExecution(t, bc, alice, contractID, "loop"); !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("looping execution ended with %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > EXECUTION_TIMEOUT+time.Second {
+		t.Fatalf("execution gave up after %v", elapsed)
+	}
+
+	// The abandoned code fails at its next use of the chain
+	select {
+	case err := <-loopExited:
+		if !strings.Contains(err.Error(), "aborted") {
+			t.Fatalf("abandoned code failed with %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("abandoned code kept using the chain")
+	}
+
+	// The chain is unaffected and keeps working
+	mineBlocks(t, bc, alice.ID, 1)
+	if _, err := bc.getContractState(contractID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSandboxAbortsExecutionsAllocatingTooMuchMemory(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, TEST_SANDBOX_TYPE, "")
+
+	if err := mineAbortedExecution(t, bc, alice, contractID, "hoard"); !strings.Contains(err.Error(), "allocated") {
+		t.Fatalf("hoarding execution ended with %v", err)
+	}
+}
+
+func TestSandboxRejectsAbortedReads(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	s := new(sandbox)
+	reader := guardedReader{chainReader{bc}, s}
+	if reader.Balance(alice.ID) == 0 || reader.BlockHeight() == 0 {
+		t.Fatal("reader did not reach the chain before abort")
+	}
+	s.abort()
+	if reader.Balance(alice.ID) != 0 || reader.BlockHeight() != 0 {
+		t.Fatal("aborted reader reached the chain")
+	}
+}
+
+func TestSandboxMetersStoredBytes(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, TEST_SANDBOX_TYPE, "")
+
+	small := executeContract(t, bc, alice, contractID, "store", map[string]any{"size": 10}, 0)
+	mustSucceed(t, small)
+	large := executeContract(t, bc, alice, contractID, "store", map[string]any{"size": 10010}, 0)
+	mustSucceed(t, large)
+	if extra := large.ConsumedGas - small.ConsumedGas; extra < 0.9999 || extra > 1.0001 {
+		t.Fatalf("10000 more bytes cost %v gas, expected 1", extra)
+	}
+
+	emitted := executeContract(t, bc, alice, contractID, "emit", map[string]any{"size": 10000}, 0)
+	mustSucceed(t, emitted)
+	if emitted.ConsumedGas < GAS_PRICE+GAS_PER_EVENT+1 {
+		t.Fatalf("event of 10000 bytes cost %v gas", emitted.ConsumedGas)
+	}
+}
+
+func TestSandboxEnforcesOutputLimit(t *testing.T) {
+	bc := CreateBlockchain(1, 1000, 1000000)
+	alice := newTestWallet(t)
+	mineBlocks(t, &bc, alice.ID, 1)
+	contractID := deployContract(t, &bc, alice, TEST_SANDBOX_TYPE, "")
+
+	execution := signedExecution(t, alice, contractID, "store", map[string]any{"size": MAX_EXECUTION_OUTPUT + 1}, 0)
+	execution.GasLimit = 900
+	execution.Signature = alice.sign(t, execution.signingMessage())
+	if err := bc.addContractExecution(execution); err != nil {
+		t.Fatal(err)
+	}
+	mined, err := bc.mineContractExecution(alice.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(mined.Error, "output limit") {
+		t.Fatalf("oversized write ended with %q", mined.Error)
+	}
+}
+
+func TestSandboxRecoversPanics(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	contractID := deployContract(t, bc, alice, TEST_SANDBOX_TYPE, "")
+
+	execution := executeContract(t, bc, alice, contractID, "panic", nil, 0)
+	if !strings.Contains(execution.Error, "panicked") {
+		t.Fatalf("panicking execution ended with %q", execution.Error)
+	}
+	if len(execution.States) != 0 {
+		t.Fatal("state of a failed execution was kept")
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const SCHEDULE_ESCROW_WALLET string = "Schedule Escrow"
+
+// ContractSchedule represents executions of a contract method enqueued automatically at a start
+// height and then every Interval blocks, paid from the gas prepaid by the Owner
+type ContractSchedule struct {
+	ScheduleID  string                     `json:"schedule_id"`
+	Owner       string                     `json:"owner"`
+	ContractID  string                     `json:"contract_id"`
+	Method      string                     `json:"method"`
+	Args        map[string]json.RawMessage `json:"args"`
+	StartHeight int                        `json:"start_height"`
+	Interval    int                        `json:"interval"` // 0 runs the execution once
+	GasLimit    float64                    `json:"gas_limit"`
+	PrepaidGas  float64                    `json:"prepaid_gas"`
+	Nonce       int64                      `json:"nonce"`
+	Signature   string                     `json:"signature"`
+}
+
+// Scheduler is implemented by the Code of contracts scheduling executions of their own methods
+// when deployed. The node owns these schedules with the schedule escrow wallet, so no party can
+// cancel them, and the deployer prepays their gas with the deployment fee
+type Scheduler interface {
+	DeploymentSchedules() []ContractSchedule
+}
+
+// ScheduleCancellation represents the cancellation of a schedule by its owner
+// The prepaid gas left is refunded to the owner
+type ScheduleCancellation struct {
+	ScheduleID string  `json:"schedule_id"`
+	Refund     float64 `json:"refund"`
+	Signature  string  `json:"signature"`
+}
+
+// ScheduleStatus reports the progress of a schedule
+type ScheduleStatus struct {
+	ContractSchedule
+	Status       string  `json:"status"`
+	NextHeight   int     `json:"next_height"`
+	RemainingGas float64 `json:"remaining_gas"`
+}
+
+// signingMessage returns the message the owner signs to authorise the schedule
+// The arguments are signed as compact JSON with sorted keys
+func (s ContractSchedule) signingMessage() string {
+	args, _ := json.Marshal(s.Args)
+	return signingMessage("schedule", s.ContractID, s.Method, string(args),
+		strconv.Itoa(s.StartHeight), strconv.Itoa(s.Interval),
+		strconv.FormatFloat(s.GasLimit, 'f', -1, 64), strconv.FormatFloat(s.PrepaidGas, 'f', -1, 64),
+		strconv.FormatInt(s.Nonce, 10))
+}
+
+// isDue checks if the schedule enqueues an execution at a block height
+func (s ContractSchedule) isDue(height int) bool {
+	if height < s.StartHeight {
+		return false
+	}
+	if s.Interval == 0 {
+		return height == s.StartHeight
+	}
+	return (height-s.StartHeight)%s.Interval == 0
+}
+
+// nextHeight returns the first height from which the schedule enqueues an execution, -1 if none
+func (s ContractSchedule) nextHeight(height int) int {
+	if height <= s.StartHeight {
+		return s.StartHeight
+	}
+	if s.Interval == 0 {
+		return -1
+	}
+	return s.StartHeight + ((height-s.StartHeight+s.Interval-1)/s.Interval)*s.Interval
+}
+
+// dueCount returns the number of executions the schedule enqueued up to a block height
+func (s ContractSchedule) dueCount(height int) int {
+	if height < s.StartHeight {
+		return 0
+	}
+	if s.Interval == 0 {
+		return 1
+	}
+	return (height-s.StartHeight)/s.Interval + 1
+}
+
+// deploymentSchedules returns the schedules created by the deployment of a contract
+func (sc *SmartContract) deploymentSchedules() []ContractSchedule {
+	scheduler, ok := sc.Code.(Scheduler)
+	if !ok {
+		return nil
+	}
+	schedules := scheduler.DeploymentSchedules()
+	for i := range schedules {
+		schedules[i].ScheduleID = fmt.Sprintf("%s-%d", sc.ContractID, i)
+		schedules[i].Owner = SCHEDULE_ESCROW_WALLET
+		schedules[i].ContractID = sc.ContractID
+	}
+	return schedules
+}
+
+// findSchedule returns a schedule stored on the chain and whether it was cancelled
+func (bc *Blockchain) findSchedule(scheduleID string) (*ContractSchedule, bool) {
+	var schedule *ContractSchedule
+	cancelled := false
+	for i := range bc.Chain {
+		data := &bc.Chain[i].Data
+		for j := range data.Schedules {
+			if data.Schedules[j].ScheduleID == scheduleID {
+				schedule = &data.Schedules[j]
+			}
+		}
+		for _, cancellation := range data.ScheduleCancellations {
+			if cancellation.ScheduleID == scheduleID {
+				cancelled = true
+			}
+		}
+	}
+	return schedule, cancelled
+}
+
+// getScheduleRemainingGas calculates the prepaid gas of a schedule not yet consumed or reserved
+// by its pending executions
+func (bc *Blockchain) getScheduleRemainingGas(schedule ContractSchedule) float64 {
+	remaining := schedule.PrepaidGas
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			if execution.ScheduleID == schedule.ScheduleID {
+				remaining -= execution.ConsumedGas
+			}
+		}
+		for _, cancellation := range block.Data.ScheduleCancellations {
+			if cancellation.ScheduleID == schedule.ScheduleID {
+				remaining -= cancellation.Refund
+			}
+		}
+	}
+	for _, execution := range bc.ContractExecutionPool {
+		if execution.ScheduleID == schedule.ScheduleID {
+			remaining -= execution.GasLimit
+		}
+	}
+	return remaining
+}
+
+// checkSchedule checks a schedule can be stored in the current block
+func (bc *Blockchain) checkSchedule(schedule ContractSchedule) error {
+	if existing, _ := bc.findSchedule(schedule.ScheduleID); existing != nil {
+		return fmt.Errorf("schedule ID already used")
+	}
+	if bc.findContractByID(schedule.ContractID) == nil {
+		return fmt.Errorf("contract not found")
+	}
+	if err := bc.validateContractCall(schedule.ContractID, schedule.Method, schedule.Args, 0); err != nil {
+		return err
+	}
+	if schedule.StartHeight <= len(bc.Chain)-1 {
+		return fmt.Errorf("start height must be after the current block %d", len(bc.Chain)-1)
+	}
+	if schedule.Interval < 0 {
+		return fmt.Errorf("interval must not be negative")
+	}
+	if schedule.GasLimit < GAS_PRICE {
+		return fmt.Errorf("gas limit must be at least %v", GAS_PRICE)
+	}
+	if schedule.PrepaidGas < schedule.GasLimit {
+		return fmt.Errorf("prepaid gas must cover at least one execution")
+	}
+	for _, block := range bc.Chain {
+		for _, existing := range block.Data.Schedules {
+			if existing.Owner == schedule.Owner && existing.Nonce == schedule.Nonce {
+				return fmt.Errorf("schedule nonce %d already used", schedule.Nonce)
+			}
+		}
+	}
+	return nil
+}
+
+// addSchedule adds a schedule to the schedule pool after validating it
+func (bc *Blockchain) addSchedule(schedule ContractSchedule) error {
+	if err := verifySignature(schedule.Owner, schedule.signingMessage(), schedule.Signature); err != nil {
+		return fmt.Errorf("schedule signature verification failed: %v", err)
+	}
+	if err := bc.checkSchedule(schedule); err != nil {
+		return err
+	}
+	for _, pending := range bc.SchedulePool {
+		if pending.ScheduleID == schedule.ScheduleID {
+			return fmt.Errorf("schedule ID already used")
+		}
+		if pending.Owner == schedule.Owner && pending.Nonce == schedule.Nonce {
+			return fmt.Errorf("schedule nonce %d already used", schedule.Nonce)
+		}
+	}
+
+	// The prepaid gas is reserved from the owner's balance until the schedule is mined
+	if bc.getBalance(schedule.Owner) < schedule.PrepaidGas {
+		return fmt.Errorf("insufficient balance to prepay the gas")
+	}
+
+	bc.SchedulePool = append(bc.SchedulePool, schedule)
+	return nil
+}
+
+// mineSchedule mines schedules from the schedule pool into the current block
+// The prepaid gas is transferred from the owner to the schedule escrow
+func (bc *Blockchain) mineSchedule() (ContractSchedule, error) {
+	if len(bc.SchedulePool) == 0 {
+		return ContractSchedule{}, fmt.Errorf("no schedules to mine")
+	}
+
+	lastBlock := bc.getLastBlock()
+
+	// Process the first schedule in the pool (FIFO) and remove it from the pool,
+	// releasing the prepaid gas reserved for it
+	schedule := bc.SchedulePool[0]
+	bc.SchedulePool = bc.SchedulePool[1:]
+
+	// The start height may have passed and the balance been spent since the schedule was added to the pool
+	if err := bc.checkSchedule(schedule); err != nil {
+		return ContractSchedule{}, fmt.Errorf("schedule %s is no longer valid: %v", schedule.ScheduleID, err)
+	}
+	if bc.getBalance(schedule.Owner) < schedule.PrepaidGas {
+		return ContractSchedule{}, fmt.Errorf("owner cannot afford the prepaid gas of schedule %s", schedule.ScheduleID)
+	}
+
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   schedule.Owner,
+		To:     SCHEDULE_ESCROW_WALLET,
+		Amount: schedule.PrepaidGas,
+	})
+	lastBlock.Data.Schedules = append(lastBlock.Data.Schedules, schedule)
+	return schedule, nil
+}
+
+// cancelSchedule cancels a schedule of its owner, removing its pending executions and
+// refunding the prepaid gas left
+func (bc *Blockchain) cancelSchedule(scheduleID string, signature string) (ScheduleCancellation, error) {
+	schedule, cancelled := bc.findSchedule(scheduleID)
+	if schedule == nil {
+		return ScheduleCancellation{}, fmt.Errorf("schedule not found")
+	}
+	if cancelled {
+		return ScheduleCancellation{}, fmt.Errorf("schedule already cancelled")
+	}
+	if err := verifySignature(schedule.Owner, signingMessage("cancel-schedule", scheduleID), signature); err != nil {
+		return ScheduleCancellation{}, fmt.Errorf("cancellation signature verification failed: %v", err)
+	}
+
+	pool := bc.ContractExecutionPool[:0:0]
+	for _, execution := range bc.ContractExecutionPool {
+		if execution.ScheduleID != scheduleID {
+			pool = append(pool, execution)
+		}
+	}
+	bc.ContractExecutionPool = pool
+
+	cancellation := ScheduleCancellation{
+		ScheduleID: scheduleID,
+		Refund:     bc.getScheduleRemainingGas(*schedule),
+		Signature:  signature,
+	}
+	lastBlock := bc.getLastBlock()
+	if cancellation.Refund > 0 {
+		lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+			From:   SCHEDULE_ESCROW_WALLET,
+			To:     schedule.Owner,
+			Amount: cancellation.Refund,
+		})
+	}
+	lastBlock.Data.ScheduleCancellations = append(lastBlock.Data.ScheduleCancellations, cancellation)
+	return cancellation, nil
+}
+
+// enqueueScheduledExecutions adds the executions of the schedules due at the current block
+// to the execution pool, skipping the schedules without enough prepaid gas left
+func (bc *Blockchain) enqueueScheduledExecutions() {
+	height := len(bc.Chain) - 1
+	for _, block := range bc.Chain {
+		for _, schedule := range block.Data.Schedules {
+			if !schedule.isDue(height) {
+				continue
+			}
+			if _, cancelled := bc.findSchedule(schedule.ScheduleID); cancelled {
+				continue
+			}
+			if bc.getScheduleRemainingGas(schedule) < schedule.GasLimit {
+				continue
+			}
+			bc.ContractExecutionPool = append(bc.ContractExecutionPool, ContractExecution{
+				ContractID: schedule.ContractID,
+				Caller:     schedule.Owner,
+				Method:     schedule.Method,
+				Args:       schedule.Args,
+				GasLimit:   schedule.GasLimit,
+				ScheduleID: schedule.ScheduleID,
+				Timestamp:  bc.getLastBlock().Timestamp,
+			})
+		}
+	}
+}
+
+// countScheduledExecutions counts the executions of a schedule mined on the chain
+func (bc *Blockchain) countScheduledExecutions(scheduleID string) int {
+	count := 0
+	for _, block := range bc.Chain {
+		for _, execution := range block.Data.ContractExecutionHistory {
+			if execution.ScheduleID == scheduleID {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+// checkScheduledExecution checks an execution mined for a schedule was enqueued by it
+func (bc *Blockchain) checkScheduledExecution(execution ContractExecution) error {
+	schedule, cancelled := bc.findSchedule(execution.ScheduleID)
+	if schedule == nil {
+		return fmt.Errorf("schedule not found")
+	}
+	if cancelled {
+		return fmt.Errorf("schedule cancelled")
+	}
+	args, _ := json.Marshal(execution.Args)
+	scheduled, _ := json.Marshal(schedule.Args)
+	if execution.ContractID != schedule.ContractID || execution.Caller != schedule.Owner || execution.Method != schedule.Method ||
+		string(args) != string(scheduled) || execution.GasLimit != schedule.GasLimit || execution.Value != 0 {
+		return fmt.Errorf("execution does not match schedule %s", schedule.ScheduleID)
+	}
+	if bc.getScheduleRemainingGas(*schedule) < execution.GasLimit {
+		return fmt.Errorf("schedule %s cannot afford the gas limit", schedule.ScheduleID)
+	}
+	if bc.countScheduledExecutions(schedule.ScheduleID) >= schedule.dueCount(len(bc.Chain)-1) {
+		return fmt.Errorf("schedule %s was not due", schedule.ScheduleID)
+	}
+	return nil
+}
+
+// requeueScheduledExecutions adds to the execution pool the executions of the active schedules
+// that were due but are neither mined nor pending, after the chain was replaced
+func (bc *Blockchain) requeueScheduledExecutions() {
+	height := len(bc.Chain) - 1
+	for _, block := range bc.Chain {
+		for _, schedule := range block.Data.Schedules {
+			if _, cancelled := bc.findSchedule(schedule.ScheduleID); cancelled {
+				continue
+			}
+			pending := 0
+			for _, execution := range bc.ContractExecutionPool {
+				if execution.ScheduleID == schedule.ScheduleID {
+					pending++
+				}
+			}
+			missing := schedule.dueCount(height) - bc.countScheduledExecutions(schedule.ScheduleID) - pending
+			for ; missing > 0 && bc.getScheduleRemainingGas(schedule) >= schedule.GasLimit; missing-- {
+				bc.ContractExecutionPool = append(bc.ContractExecutionPool, ContractExecution{
+					ContractID: schedule.ContractID,
+					Caller:     schedule.Owner,
+					Method:     schedule.Method,
+					Args:       schedule.Args,
+					GasLimit:   schedule.GasLimit,
+					ScheduleID: schedule.ScheduleID,
+					Timestamp:  bc.getLastBlock().Timestamp,
+				})
+			}
+		}
+	}
+}
+
+// getScheduleStatus reports the progress of a schedule
+func (bc *Blockchain) getScheduleStatus(schedule ContractSchedule) ScheduleStatus {
+	height := len(bc.Chain) - 1
+	status := ScheduleStatus{
+		ContractSchedule: schedule,
+		Status:           "active",
+		NextHeight:       schedule.nextHeight(height + 1),
+		RemainingGas:     bc.getScheduleRemainingGas(schedule),
+	}
+	if _, cancelled := bc.findSchedule(schedule.ScheduleID); cancelled {
+		status.Status = "cancelled"
+		status.NextHeight = -1
+	} else if status.NextHeight == -1 {
+		status.Status = "completed"
+	} else if status.RemainingGas < schedule.GasLimit {
+		status.Status = "exhausted"
+		status.NextHeight = -1
+	}
+	return status
+}
+
+// getSchedules reports the schedules stored on the chain, filtered by owner and contract
+func (bc *Blockchain) getSchedules(owner string, contractID string) []ScheduleStatus {
+	schedules := []ScheduleStatus{}
+	for _, block := range bc.Chain {
+		for _, schedule := range block.Data.Schedules {
+			if owner != "" && schedule.Owner != owner {
+				continue
+			}
+			if contractID != "" && schedule.ContractID != contractID {
+				continue
+			}
+			schedules = append(schedules, bc.getScheduleStatus(schedule))
+		}
+	}
+	return schedules
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// addSchedule signs a schedule of the wallet executing a method of a contract and mines it
+func addSchedule(t *testing.T, bc *Blockchain, w *testWallet, contractID string, method string, args map[string]any, start int, interval int, prepaid float64) ContractSchedule {
+	t.Helper()
+	scheduleID, err := generateRandomID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := ContractSchedule{
+		ScheduleID:  scheduleID,
+		Owner:       w.ID,
+		ContractID:  contractID,
+		Method:      method,
+		Args:        marshalArgs(t, args),
+		StartHeight: start,
+		Interval:    interval,
+		GasLimit:    DEFAULT_GAS_LIMIT,
+		PrepaidGas:  prepaid,
+		Nonce:       w.nextNonce(),
+	}
+	schedule.Signature = w.sign(t, schedule.signingMessage())
+	if err := bc.addSchedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bc.mineSchedule(); err != nil {
+		t.Fatal(err)
+	}
+	return schedule
+}
+
+// pendingScheduled counts the executions of a schedule waiting in the execution pool
+func pendingScheduled(bc *Blockchain, scheduleID string) int {
+	pending := 0
+	for _, execution := range bc.ContractExecutionPool {
+		if execution.ScheduleID == scheduleID {
+			pending++
+		}
+	}
+	return pending
+}
+
+func TestScheduleEnqueuesAtItsStartAndInterval(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	start := len(bc.Chain) + 1
+	recurring := addSchedule(t, bc, alice, counter, "increment", nil, start, 3, 10)
+	once := addSchedule(t, bc, alice, counter, "increment", nil, start+1, 0, 10)
+
+	for height := len(bc.Chain); height <= start+6; height++ {
+		mineBlocks(t, bc, miner.ID, 1)
+		expected := 0
+		if recurring.isDue(height) {
+			expected = 1
+		}
+		if pending := pendingScheduled(bc, recurring.ScheduleID); pending != expected {
+			t.Fatalf("recurring schedule enqueued %d executions at height %d", pending, height)
+		}
+		for len(bc.ContractExecutionPool) > 0 {
+			mustSucceed(t, mineScheduled(t, bc, miner.ID))
+		}
+	}
+
+	if count := callView(t, bc, counter, "count", nil); count != "4" {
+		t.Fatalf("counter is %s after 3 recurring and 1 single executions", count)
+	}
+	if status := bc.getScheduleStatus(recurring); status.Status != "active" || status.NextHeight != start+9 {
+		t.Fatalf("recurring schedule reports %+v", status)
+	}
+	if status := bc.getScheduleStatus(once); status.Status != "completed" || status.NextHeight != -1 {
+		t.Fatalf("single schedule reports %+v", status)
+	}
+	if schedules := bc.getSchedules(alice.ID, counter); len(schedules) != 2 {
+		t.Fatalf("alice has the schedules %+v", schedules)
+	}
+}
+
+func TestScheduleKeepsRunningAfterAFailedExecution(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	relay := deployContract(t, bc, alice, TEST_RELAY_TYPE, "")
+	start := len(bc.Chain) + 1
+	// relay fails without its next argument
+	schedule := addSchedule(t, bc, alice, relay, "relay", nil, start, 2, 10)
+
+	mineUntil(t, bc, miner.ID, start)
+	failed := mineScheduled(t, bc, miner.ID)
+	mustFail(t, failed)
+	if remaining := bc.getScheduleRemainingGas(schedule); math.Abs(remaining-(10-failed.ConsumedGas)) > BALANCE_TOLERANCE {
+		t.Fatalf("schedule has %v gas left after a failure consuming %v", remaining, failed.ConsumedGas)
+	}
+	mineUntil(t, bc, miner.ID, start+2)
+	if pending := pendingScheduled(bc, schedule.ScheduleID); pending != 1 {
+		t.Fatalf("schedule enqueued %d executions after its failure", pending)
+	}
+	mustFail(t, mineScheduled(t, bc, miner.ID))
+}
+
+func TestScheduleRunsOutOfPrepaidGas(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	start := len(bc.Chain) + 1
+	schedule := addSchedule(t, bc, alice, counter, "increment", nil, start, 1, DEFAULT_GAS_LIMIT)
+
+	mineUntil(t, bc, miner.ID, start)
+	mustSucceed(t, mineScheduled(t, bc, miner.ID))
+	mineBlocks(t, bc, miner.ID, 1)
+	if pending := pendingScheduled(bc, schedule.ScheduleID); pending != 0 {
+		t.Fatal("schedule without enough prepaid gas left enqueued an execution")
+	}
+	if status := bc.getScheduleStatus(schedule); status.Status != "exhausted" {
+		t.Fatalf("schedule reports %+v", status)
+	}
+}
+
+func TestScheduleIsCheckedAgainWhenMined(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	before := bc.getBalance(alice.ID)
+
+	schedule := ContractSchedule{
+		ScheduleID:  "schedule-1",
+		Owner:       alice.ID,
+		ContractID:  counter,
+		Method:      "increment",
+		StartHeight: len(bc.Chain),
+		GasLimit:    1,
+		PrepaidGas:  5,
+		Nonce:       alice.nextNonce(),
+	}
+	schedule.Signature = alice.sign(t, schedule.signingMessage())
+	if err := bc.addSchedule(schedule); err != nil {
+		t.Fatal(err)
+	}
+	if reserved := before - bc.getBalance(alice.ID); reserved != 5 || len(bc.getLastBlock().Data.Schedules) != 0 {
+		t.Fatalf("pending schedule reserved %v and was stored before being mined", reserved)
+	}
+	if err := bc.addSchedule(schedule); err == nil {
+		t.Fatal("schedule pending in the pool was added again")
+	}
+
+	// The start height passed while the schedule waited in the pool
+	mineBlocks(t, bc, miner.ID, 1)
+	if _, err := bc.mineSchedule(); err == nil || !strings.Contains(err.Error(), "start height") {
+		t.Fatalf("mining a schedule whose start passed returned %v", err)
+	}
+	if len(bc.SchedulePool) != 0 || bc.getBalance(alice.ID) != before {
+		t.Fatal("dropped schedule kept its reservation")
+	}
+	if schedules := bc.getSchedules(alice.ID, ""); len(schedules) != 0 {
+		t.Fatalf("dropped schedule was stored: %+v", schedules)
+	}
+}
+
+func TestScheduleCancellationRefundsThePrepaidGas(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, mallory, miner := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	start := len(bc.Chain) + 1
+	before := bc.getBalance(alice.ID)
+	schedule := addSchedule(t, bc, alice, counter, "increment", nil, start, 1, 5)
+	if prepaid := before - bc.getBalance(alice.ID); prepaid != 5 {
+		t.Fatalf("alice prepaid %v", prepaid)
+	}
+
+	mineUntil(t, bc, miner.ID, start)
+	executed := mineScheduled(t, bc, miner.ID)
+	mustSucceed(t, executed)
+	mineBlocks(t, bc, miner.ID, 1)
+	if pendingScheduled(bc, schedule.ScheduleID) != 1 {
+		t.Fatal("schedule did not enqueue its second execution")
+	}
+
+	if _, err := bc.cancelSchedule(schedule.ScheduleID, mallory.sign(t, signingMessage("cancel-schedule", schedule.ScheduleID))); err == nil {
+		t.Fatal("schedule was cancelled by another wallet")
+	}
+	cancellation, err := bc.cancelSchedule(schedule.ScheduleID, alice.sign(t, signingMessage("cancel-schedule", schedule.ScheduleID)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pendingScheduled(bc, schedule.ScheduleID) != 0 {
+		t.Fatal("pending execution of the cancelled schedule was kept")
+	}
+	if math.Abs(cancellation.Refund-(5-executed.ConsumedGas)) > BALANCE_TOLERANCE {
+		t.Fatalf("cancellation refunded %v after consuming %v", cancellation.Refund, executed.ConsumedGas)
+	}
+	if spent := before - bc.getBalance(alice.ID); math.Abs(spent-executed.ConsumedGas) > BALANCE_TOLERANCE {
+		t.Fatalf("alice spent %v on the schedule, expected the consumed gas %v", spent, executed.ConsumedGas)
+	}
+	if _, err := bc.cancelSchedule(schedule.ScheduleID, alice.sign(t, signingMessage("cancel-schedule", schedule.ScheduleID))); err == nil || !strings.Contains(err.Error(), "already cancelled") {
+		t.Fatalf("second cancellation returned %v", err)
+	}
+	mineBlocks(t, bc, miner.ID, 1)
+	if pendingScheduled(bc, schedule.ScheduleID) != 0 || bc.getScheduleStatus(schedule).Status != "cancelled" {
+		t.Fatal("cancelled schedule is still running")
+	}
+}
+
+func TestDeploymentSchedulesCannotBeCancelled(t *testing.T) {
+	bc := newTestBlockchain()
+	buyer, seller := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, buyer.ID, 1)
+	mineBlocks(t, bc, seller.ID, 1)
+	escrow := deployEscrow(t, bc, buyer, seller, len(bc.Chain)+2)
+
+	schedules := bc.getSchedules("", escrow)
+	if len(schedules) != 1 || schedules[0].Owner != SCHEDULE_ESCROW_WALLET {
+		t.Fatalf("escrow created the schedules %+v", schedules)
+	}
+	for _, w := range []*testWallet{buyer, seller} {
+		if _, err := bc.cancelSchedule(schedules[0].ScheduleID, w.sign(t, signingMessage("cancel-schedule", schedules[0].ScheduleID))); err == nil {
+			t.Fatal("schedule of the escrow refund was cancelled by a party")
+		}
+	}
+}
+
+func TestReplaceChainRequeuesDueScheduledExecutions(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	start := len(bc.Chain) + 1
+	schedule := addSchedule(t, bc, alice, counter, "increment", nil, start, 0, 5)
+	mineUntil(t, bc, miner.ID, start)
+	if pendingScheduled(bc, schedule.ScheduleID) != 1 {
+		t.Fatal("schedule did not enqueue its execution")
+	}
+
+	// The execution enqueued on the first node is owed by any node taking its chain
+	node := newTestBlockchain()
+	if err := node.replaceChain(receive(t, bc.Chain)); err != nil {
+		t.Fatal(err)
+	}
+	if pending := pendingScheduled(node, schedule.ScheduleID); pending != 1 {
+		t.Fatalf("replaced chain requeued %d executions", pending)
+	}
+	mustSucceed(t, mineScheduled(t, node, miner.ID))
+	if count := callView(t, node, counter, "count", nil); count != "1" {
+		t.Fatalf("counter is %s on the node", count)
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"crypto/rsa"
+
+	"blockchain/signing"
+)
+
+// parseWalletKey decodes a wallet (base64 encoded PEM public key) into an RSA public key
+func parseWalletKey(wallet string) (*rsa.PublicKey, error) {
+	return signing.ParseWalletKey(wallet)
+}
+
+// signingMessage builds the message a wallet signs from the fields of an operation
+func signingMessage(fields ...string) string {
+	return signing.Message(fields...)
+}
+
+// verifySignature checks that signature is a base64 encoded RSA PKCS#1 v1.5 SHA-256
+// signature of message made with the private key of wallet
+func verifySignature(wallet string, message string, signature string) error {
+	return signing.Verify(wallet, message, signature)
+}
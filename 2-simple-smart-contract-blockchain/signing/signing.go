@@ -0,0 +1,62 @@
+// Package signing verifies the RSA signatures of wallets
+package signing
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"strings"
+)
+
+// ParseWalletKey decodes a wallet (base64 encoded PEM public key) into an RSA public key
+func ParseWalletKey(wallet string) (*rsa.PublicKey, error) {
+	data, err := base64.StdEncoding.DecodeString(wallet)
+	if err != nil {
+		return nil, fmt.Errorf("wallet is not base64 encoded")
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("wallet is not a PEM encoded public key")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("wallet is not a valid public key: %v", err)
+	}
+
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("wallet is not an RSA public key")
+	}
+	return rsaKey, nil
+}
+
+// Message builds the message a wallet signs from the fields of an operation
+func Message(fields ...string) string {
+	return strings.Join(fields, "|")
+}
+
+// Verify checks that signature is a base64 encoded RSA PKCS#1 v1.5 SHA-256 signature of
+// message made with the private key of wallet
+func Verify(wallet string, message string, signature string) error {
+	key, err := ParseWalletKey(wallet)
+	if err != nil {
+		return err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("signature is not base64 encoded")
+	}
+
+	hash := sha256.Sum256([]byte(message))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig); err != nil {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
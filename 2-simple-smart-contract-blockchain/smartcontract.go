@@ -4,40 +4,202 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
+	"strconv"
 	"time"
 )
 
+const DEPLOYMENT_FEE_PER_BYTE float64 = 0.001
+
 // SmartContract represents a smart contract in the blockchain
 type SmartContract struct {
 	ContractID    string `json:"contract_id"`
 	Wallet        string `json:"wallet"`
 	Type          string `json:"type"`
 	Specification string `json:"spec"`
-	Code          Code   `json:"-"`
+	Nonce         int64  `json:"nonce"`
+	Signature     string `json:"signature"`
+	Code          Code   `json:"code"`
 }
 
 // Code interface defines the methods for a smart contract
+// The state of a contract is the JSON serialization of its Code, the ExecutionContext and
+// ChainReader are the only access the contract has to the blockchain
 type Code interface {
-	Execute(blockchain *Blockchain) error
-	Validate(blockchain *Blockchain) bool
+	Execute(ctx ExecutionContext) (string, error)
+	Validate(chain ChainReader) bool
+}
+
+// Initializer is implemented by the Code of contract types configured at deployment
+// Initialize receives the deployer wallet and the specification of the contract
+type Initializer interface {
+	Initialize(owner string, specification string) error
+}
+
+// ContractState is the live state of a deployed contract, its Code and its key-value storage
+type ContractState struct {
+	Code    Code                       `json:"code"`
+	Storage map[string]json.RawMessage `json:"storage"`
+}
+
+// codeRegistry maps contract types to factories of empty Code instances
+var codeRegistry = make(map[string]func() Code)
+
+// registerCode registers the factory used to create the Code of a contract type
+func registerCode(contractType string, factory func() Code) {
+	if _, exists := codeRegistry[contractType]; exists {
+		panic(fmt.Sprintf("contract type %s already registered", contractType))
+	}
+	codeRegistry[contractType] = factory
 }
 
+// newCode creates an empty Code instance of a registered contract type
+func newCode(contractType string) (Code, error) {
+	factory, exists := codeRegistry[contractType]
+	if !exists {
+		return nil, fmt.Errorf("unknown contract type %s", contractType)
+	}
+	return factory(), nil
+}
+
+// restoreCode creates a Code instance of a registered contract type from its serialized state
+func restoreCode(contractType string, state json.RawMessage) (Code, error) {
+	code, err := newCode(contractType)
+	if err != nil {
+		return nil, err
+	}
+	if len(state) > 0 && string(state) != "null" {
+		if err := json.Unmarshal(state, code); err != nil {
+			return nil, fmt.Errorf("could not restore state of contract type %s: %v", contractType, err)
+		}
+	}
+	return code, nil
+}
+
+// restoreContractState creates the state of a contract of a registered type from its serialization
+func restoreContractState(contractType string, data json.RawMessage) (*ContractState, error) {
+	var aux struct {
+		Code    json.RawMessage            `json:"code"`
+		Storage map[string]json.RawMessage `json:"storage"`
+	}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return nil, fmt.Errorf("could not restore state of contract type %s: %v", contractType, err)
+	}
+
+	code, err := restoreCode(contractType, aux.Code)
+	if err != nil {
+		return nil, err
+	}
+	if aux.Storage == nil {
+		aux.Storage = make(map[string]json.RawMessage)
+	}
+	return &ContractState{Code: code, Storage: aux.Storage}, nil
+}
+
+// UnmarshalJSON restores the Code of the contract from its type and serialized state
+func (sc *SmartContract) UnmarshalJSON(data []byte) error {
+	type smartContract SmartContract
+	aux := struct {
+		*smartContract
+		Code json.RawMessage `json:"code"`
+	}{smartContract: (*smartContract)(sc)}
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	code, err := restoreCode(sc.Type, aux.Code)
+	if err != nil {
+		return err
+	}
+	sc.Code = code
+	return nil
+}
+
+// ContractExecution represents a request to execute a method of a smart contract, paid by the Caller
 type ContractExecution struct {
-	ContractID  string    `json:"contract_id"`
-	ConsumedGas float64   `json:"consumed_gas"`
-	Result      string    `json:"result"`
-	Timestamp   time.Time `json:"timestamp"`
-	Miner       string    `json:"miner"`
+	ContractID  string                     `json:"contract_id"`
+	Version     int                        `json:"version"` // Code version of the contract that ran
+	Caller      string                     `json:"caller"`
+	Method      string                     `json:"method"`
+	Args        map[string]json.RawMessage `json:"args"`
+	Value       float64                    `json:"value"`
+	GasLimit    float64                    `json:"gas_limit"`
+	ConsumedGas float64                    `json:"consumed_gas"`
+	Result      string                     `json:"result"`
+	Error       string                     `json:"error"`
+	Events      []ContractEvent            `json:"events"`
+	Transfers   []Transaction              `json:"transfers"`
+	Calls       []ContractCall             `json:"calls"`
+	States      map[string]json.RawMessage `json:"states"` // Resulting states of the modified contracts
+	ScheduleID  string                     `json:"schedule_id"`
+	Nonce       int64                      `json:"nonce"`
+	Signature   string                     `json:"signature"` // Authorises the charges and authenticates the Caller to the contract
+	Timestamp   time.Time                  `json:"timestamp"`
+	Miner       string                     `json:"miner"`
+}
+
+// gasPayer returns the wallet paying the gas of the execution, the schedule escrow for
+// executions enqueued by a schedule
+func (e ContractExecution) gasPayer() string {
+	if e.ScheduleID != "" {
+		return SCHEDULE_ESCROW_WALLET
+	}
+	return e.Caller
+}
+
+// signingMessage returns the message the caller signs to authenticate the execution
+// The arguments are signed as compact JSON with sorted keys
+func (e ContractExecution) signingMessage() string {
+	args, _ := json.Marshal(e.Args)
+	return signingMessage("execute", e.ContractID, e.Method, string(args),
+		strconv.FormatFloat(e.Value, 'f', -1, 64), strconv.FormatFloat(e.GasLimit, 'f', -1, 64),
+		strconv.FormatInt(e.Nonce, 10))
 }
 
-// Execute calls the Execute method of the Code interface
-func (sc *SmartContract) Execute(blockchain *Blockchain) error {
-	return sc.Code.Execute(blockchain)
+// isAuthenticated checks if the caller of the execution proved its identity, by signing the
+// execution or the schedule enqueuing it
+func (e ContractExecution) isAuthenticated() bool {
+	return e.Signature != "" || e.ScheduleID != ""
 }
 
-// Validate calls the Validate method of the Code interface
+// Validate calls the Validate method of the Code interface with read-only access to the chain
+// A panicking or stalling validation is treated as invalid
 func (sc *SmartContract) Validate(blockchain *Blockchain) bool {
-	return sc.Code.Validate(blockchain)
+	s := new(sandbox)
+	_, err := runSandboxed(s, func() (string, error) {
+		if !sc.Code.Validate(guardedReader{chainReader{blockchain}, s}) {
+			return "", fmt.Errorf("contract validation failed")
+		}
+		return "", nil
+	})
+	return err == nil
+}
+
+// signingMessage returns the message the deployer signs to authorise the deployment
+func (sc *SmartContract) signingMessage() string {
+	return signingMessage("deploy", sc.Type, sc.Specification, strconv.FormatInt(sc.Nonce, 10))
+}
+
+// codeSize returns the size in bytes of the contract specification and code
+func (sc *SmartContract) codeSize() int {
+	code, _ := json.Marshal(sc.Code)
+	return len(sc.Specification) + len(code)
+}
+
+// deploymentFee calculates the fee paid by the deployer to the miner based on the code size
+func (sc *SmartContract) deploymentFee() float64 {
+	return float64(sc.codeSize()) * DEPLOYMENT_FEE_PER_BYTE
+}
+
+// deploymentCost calculates the coins the deployer pays when the deployment is mined, the fee and
+// the gas prepaid for the schedules the contract creates
+func (sc *SmartContract) deploymentCost() float64 {
+	cost := sc.deploymentFee()
+	for _, schedule := range sc.deploymentSchedules() {
+		cost += schedule.PrepaidGas
+	}
+	return cost
 }
 
 // calculateDigest generates a SHA256 digest of the contract data
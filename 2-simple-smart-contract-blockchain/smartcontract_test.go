@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// testInvalidCode is contract code failing its validation
+type testInvalidCode struct{}
+
+func (sc *testInvalidCode) Execute(ctx ExecutionContext) (string, error) {
+	return "", fmt.Errorf("invalid contract executed")
+}
+
+func (sc *testInvalidCode) Validate(chain ChainReader) bool {
+	return false
+}
+
+// signedDeployment builds a deployment of the code signed by the wallet
+func signedDeployment(t *testing.T, w *testWallet, code Code, specification string) SmartContract {
+	t.Helper()
+	contractID, err := generateRandomID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := SmartContract{
+		ContractID:    contractID,
+		Wallet:        w.ID,
+		Type:          CONTRACT_EXAMPLE_TYPE,
+		Specification: specification,
+		Nonce:         w.nextNonce(),
+		Code:          code,
+	}
+	contract.Signature = w.sign(t, contract.signingMessage())
+	return contract
+}
+
+func TestDeploymentRequiresTheDeployerSignature(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	impersonated := signedDeployment(t, mallory, &ContractCodeExample{}, "counter")
+	impersonated.Wallet = alice.ID
+	altered := signedDeployment(t, alice, &ContractCodeExample{}, "counter")
+	altered.Specification = "altered"
+	unsigned := signedDeployment(t, alice, &ContractCodeExample{}, "counter")
+	unsigned.Signature = ""
+	for name, contract := range map[string]SmartContract{"impersonated": impersonated, "altered": altered, "unsigned": unsigned} {
+		if err := bc.addContract(contract); err == nil || !strings.Contains(err.Error(), "signature") {
+			t.Fatalf("%s deployment returned %v", name, err)
+		}
+	}
+
+	contract := signedDeployment(t, alice, &ContractCodeExample{}, "counter")
+	if err := bc.addContract(contract); err != nil {
+		t.Fatal(err)
+	}
+	replayed := contract
+	replayed.ContractID = "replayed"
+	if err := bc.addContract(replayed); err == nil || !strings.Contains(err.Error(), "nonce") {
+		t.Fatalf("replayed deployment returned %v", err)
+	}
+}
+
+func TestDeploymentIsValidatedBeforeThePool(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, poor := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+
+	if err := bc.addContract(signedDeployment(t, alice, &testInvalidCode{}, "invalid")); err == nil || !strings.Contains(err.Error(), "validation failed") {
+		t.Fatalf("deployment of invalid code returned %v", err)
+	}
+	if err := bc.addContract(signedDeployment(t, poor, &ContractCodeExample{}, "counter")); err == nil || !strings.Contains(err.Error(), "insufficient balance") {
+		t.Fatalf("deployment by an unfunded wallet returned %v", err)
+	}
+	if len(bc.ContractDeploymentPool) != 0 {
+		t.Fatalf("pool holds %d rejected deployments", len(bc.ContractDeploymentPool))
+	}
+}
+
+func TestDeploymentFeeIsReservedThenPaidToTheMiner(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, miner := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	before := bc.getBalance(alice.ID)
+
+	contract := signedDeployment(t, alice, &ContractCodeExample{}, strings.Repeat("x", 1000))
+	if err := bc.addContract(contract); err != nil {
+		t.Fatal(err)
+	}
+	fee := bc.ContractDeploymentPool[0].deploymentFee()
+	if fee < 1000*DEPLOYMENT_FEE_PER_BYTE {
+		t.Fatalf("fee %v does not cover the size of the specification", fee)
+	}
+	if balance := bc.getBalance(alice.ID); balance != before-fee {
+		t.Fatalf("alice has %v with a pending deployment, expected the fee %v reserved", balance, fee)
+	}
+	if bc.findContractByID(contract.ContractID) != nil {
+		t.Fatal("pending deployment is already deployed")
+	}
+
+	if _, err := bc.mineContractDeployment(miner.ID); err != nil {
+		t.Fatal(err)
+	}
+	if bc.findContractByID(contract.ContractID) == nil {
+		t.Fatal("mined deployment was not deployed")
+	}
+	if balance := bc.getBalance(alice.ID); balance != before-fee {
+		t.Fatalf("alice has %v after the deployment, expected the fee %v paid", balance, fee)
+	}
+	if earned := bc.getBalance(miner.ID); earned != fee {
+		t.Fatalf("miner earned %v, expected the fee %v", earned, fee)
+	}
+	if _, err := bc.mineContractDeployment(miner.ID); err == nil {
+		t.Fatal("mined a deployment from an empty pool")
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const CONTRACT_TOKEN_TYPE string = "token"
+const MAX_TOKEN_DECIMALS int = 18
+
+func init() {
+	registerCode(CONTRACT_TOKEN_TYPE, func() Code { return &ContractCodeToken{} })
+}
+
+// TokenSpecification is the specification of a token contract, given as JSON at deployment
+// The supply is minted to the deployer, amounts are integers in the smallest unit of the token
+type TokenSpecification struct {
+	Name     string `json:"name"`
+	Symbol   string `json:"symbol"`
+	Decimals int    `json:"decimals"`
+	Supply   int64  `json:"supply"`
+}
+
+// ContractCodeToken implements a fungible token with allowances, mintable and burnable by its owner
+type ContractCodeToken struct {
+	Owner       string                      `json:"owner"`
+	Name        string                      `json:"name"`
+	Symbol      string                      `json:"symbol"`
+	Decimals    int                         `json:"decimals"`
+	TotalSupply int64                       `json:"total_supply"`
+	Balances    map[string]int64            `json:"balances"`
+	Allowances  map[string]map[string]int64 `json:"allowances"` // Owner to spender to amount
+}
+
+// Initialize configures the token from its specification and mints the supply to the deployer
+func (sc *ContractCodeToken) Initialize(owner string, specification string) error {
+	var spec TokenSpecification
+	if err := json.Unmarshal([]byte(specification), &spec); err != nil {
+		return fmt.Errorf("token specification must be JSON: %v", err)
+	}
+	if spec.Supply < 0 {
+		return fmt.Errorf("token supply must not be negative")
+	}
+	sc.Owner = owner
+	sc.Name = spec.Name
+	sc.Symbol = spec.Symbol
+	sc.Decimals = spec.Decimals
+	sc.TotalSupply = spec.Supply
+	sc.Balances = map[string]int64{}
+	sc.Allowances = map[string]map[string]int64{}
+	if spec.Supply > 0 {
+		sc.Balances[owner] = spec.Supply
+	}
+	return nil
+}
+
+func (sc *ContractCodeToken) Validate(chain ChainReader) bool {
+	return sc.Owner != "" && sc.Name != "" && sc.Symbol != "" &&
+		sc.Decimals >= 0 && sc.Decimals <= MAX_TOKEN_DECIMALS && sc.TotalSupply >= 0
+}
+
+func (sc *ContractCodeToken) ABI() ContractABI {
+	address := func(name string) ParamABI { return ParamABI{Name: name, Type: "address"} }
+	amount := ParamABI{Name: "amount", Type: "integer"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "name", Params: []ParamABI{}, Returns: "string", ReadOnly: true},
+			{Name: "symbol", Params: []ParamABI{}, Returns: "string", ReadOnly: true},
+			{Name: "decimals", Params: []ParamABI{}, Returns: "integer", ReadOnly: true},
+			{Name: "total_supply", Params: []ParamABI{}, Returns: "integer", ReadOnly: true},
+			{Name: "balance_of", Params: []ParamABI{address("owner")}, Returns: "integer", ReadOnly: true},
+			{Name: "allowance", Params: []ParamABI{address("owner"), address("spender")}, Returns: "integer", ReadOnly: true},
+			{Name: "transfer", Params: []ParamABI{address("to"), amount}, Returns: "bool"},
+			{Name: "approve", Params: []ParamABI{address("spender"), amount}, Returns: "bool"},
+			{Name: "transfer_from", Params: []ParamABI{address("from"), address("to"), amount}, Returns: "bool"},
+			{Name: "mint", Params: []ParamABI{address("to"), amount}, Returns: "integer"},
+			{Name: "burn", Params: []ParamABI{amount}, Returns: "integer"},
+		},
+		Events: []EventABI{
+			{Name: "Transfer", Topics: []string{"from", "to"}, Payload: []ParamABI{amount}},
+			{Name: "Approval", Topics: []string{"owner", "spender"}, Payload: []ParamABI{amount}},
+		},
+	}
+}
+
+func (sc *ContractCodeToken) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "name":
+		return sc.Name, nil
+	case "symbol":
+		return sc.Symbol, nil
+	case "decimals":
+		return strconv.Itoa(sc.Decimals), nil
+	case "total_supply":
+		return strconv.FormatInt(sc.TotalSupply, 10), nil
+	case "balance_of":
+		var owner string
+		if err := ctx.Arg("owner", &owner); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(sc.Balances[owner], 10), nil
+	case "allowance":
+		var owner, spender string
+		if err := ctx.Arg("owner", &owner); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("spender", &spender); err != nil {
+			return "", err
+		}
+		return strconv.FormatInt(sc.Allowances[owner][spender], 10), nil
+	}
+
+	// The remaining methods move tokens on behalf of the caller
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	var amount int64
+	if err := ctx.Arg("amount", &amount); err != nil {
+		return "", err
+	}
+	if amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+
+	switch ctx.Method() {
+	case "transfer":
+		var to string
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		return "true", sc.transfer(ctx, ctx.Caller(), to, amount)
+	case "approve":
+		var spender string
+		if err := ctx.Arg("spender", &spender); err != nil {
+			return "", err
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		if sc.Allowances[ctx.Caller()] == nil {
+			sc.Allowances[ctx.Caller()] = map[string]int64{}
+		}
+		sc.Allowances[ctx.Caller()][spender] = amount
+		return "true", ctx.Emit("Approval", []string{ctx.Caller(), spender}, map[string]int64{"amount": amount})
+	case "transfer_from":
+		var from, to string
+		if err := ctx.Arg("from", &from); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		allowance := sc.Allowances[from][ctx.Caller()]
+		if allowance < amount {
+			return "", fmt.Errorf("insufficient allowance")
+		}
+		if err := sc.transfer(ctx, from, to, amount); err != nil {
+			return "", err
+		}
+		sc.Allowances[from][ctx.Caller()] = allowance - amount
+		return "true", nil
+	case "mint":
+		if ctx.Caller() != sc.Owner {
+			return "", fmt.Errorf("only the token owner can mint")
+		}
+		var to string
+		if err := ctx.Arg("to", &to); err != nil {
+			return "", err
+		}
+		if to == "" {
+			return "", fmt.Errorf("invalid recipient")
+		}
+		// Every balance is part of the total supply, neither may wrap around
+		if sc.TotalSupply > math.MaxInt64-amount || sc.Balances[to] > math.MaxInt64-amount {
+			return "", fmt.Errorf("minting %d would overflow the total supply", amount)
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		sc.Balances[to] += amount
+		sc.TotalSupply += amount
+		return strconv.FormatInt(sc.TotalSupply, 10), ctx.Emit("Transfer", []string{"", to}, map[string]int64{"amount": amount})
+	case "burn":
+		if ctx.Caller() != sc.Owner {
+			return "", fmt.Errorf("only the token owner can burn")
+		}
+		if sc.Balances[sc.Owner] < amount {
+			return "", fmt.Errorf("insufficient token balance")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		sc.setBalance(sc.Owner, sc.Balances[sc.Owner]-amount)
+		sc.TotalSupply -= amount
+		return strconv.FormatInt(sc.TotalSupply, 10), ctx.Emit("Transfer", []string{sc.Owner, ""}, map[string]int64{"amount": amount})
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// transfer moves tokens between two holders and emits a Transfer event
+func (sc *ContractCodeToken) transfer(ctx ExecutionContext, from string, to string, amount int64) error {
+	if to == "" {
+		return fmt.Errorf("invalid recipient")
+	}
+	if sc.Balances[from] < amount {
+		return fmt.Errorf("insufficient token balance")
+	}
+	if err := ctx.UseGas(2 * GAS_PER_STORAGE_WRITE); err != nil {
+		return err
+	}
+	sc.setBalance(from, sc.Balances[from]-amount)
+	sc.Balances[to] += amount
+	return ctx.Emit("Transfer", []string{from, to}, map[string]int64{"amount": amount})
+}
+
+// setBalance updates the balance of a holder, removing empty balances from the state
+func (sc *ContractCodeToken) setBalance(holder string, balance int64) {
+	if balance == 0 {
+		delete(sc.Balances, holder)
+		return
+	}
+	sc.Balances[holder] = balance
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+// deployToken deploys a token whose supply is minted to the wallet
+func deployToken(t *testing.T, bc *Blockchain, w *testWallet, supply int64) string {
+	t.Helper()
+	return deployContract(t, bc, w, CONTRACT_TOKEN_TYPE, string(mustMarshal(t, TokenSpecification{Name: "Team Token", Symbol: "TEAM", Supply: supply})))
+}
+
+func TestTokenTransfersAndAllowances(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, carol := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	mineBlocks(t, bc, carol.ID, 1)
+	token := deployToken(t, bc, alice, 1000)
+
+	mustSucceed(t, executeContract(t, bc, alice, token, "transfer", map[string]any{"to": bob.ID, "amount": 300}, 0))
+	mustFail(t, executeContract(t, bc, bob, token, "transfer", map[string]any{"to": carol.ID, "amount": 301}, 0))
+
+	mustFail(t, executeContract(t, bc, carol, token, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "amount": 50}, 0))
+	mustSucceed(t, executeContract(t, bc, bob, token, "approve", map[string]any{"spender": alice.ID, "amount": 100}, 0))
+	mustSucceed(t, executeContract(t, bc, alice, token, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "amount": 60}, 0))
+	mustFail(t, executeContract(t, bc, alice, token, "transfer_from", map[string]any{"from": bob.ID, "to": carol.ID, "amount": 41}, 0))
+
+	expected := map[string]string{alice.ID: "700", bob.ID: "240", carol.ID: "60"}
+	for wallet, balance := range expected {
+		if got := callView(t, bc, token, "balance_of", map[string]any{"owner": wallet}); got != balance {
+			t.Fatalf("balance is %s, expected %s", got, balance)
+		}
+	}
+	if got := callView(t, bc, token, "allowance", map[string]any{"owner": bob.ID, "spender": alice.ID}); got != "40" {
+		t.Fatalf("allowance is %s, expected 40", got)
+	}
+}
+
+func TestTokenMintIsLimitedToTheOwnerAndInt64(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	token := deployToken(t, bc, alice, 1000)
+
+	mustFail(t, executeContract(t, bc, bob, token, "mint", map[string]any{"to": bob.ID, "amount": 1}, 0))
+
+	overflow := executeContract(t, bc, alice, token, "mint", map[string]any{"to": bob.ID, "amount": int64(math.MaxInt64 - 999)}, 0)
+	if !strings.Contains(overflow.Error, "overflow") {
+		t.Fatalf("overflowing mint ended with %q", overflow.Error)
+	}
+	mustSucceed(t, executeContract(t, bc, alice, token, "mint", map[string]any{"to": bob.ID, "amount": int64(math.MaxInt64 - 1000)}, 0))
+	if got := callView(t, bc, token, "total_supply", nil); got != "9223372036854775807" {
+		t.Fatalf("total supply is %s", got)
+	}
+	mustFail(t, executeContract(t, bc, alice, token, "mint", map[string]any{"to": alice.ID, "amount": 1}, 0))
+
+	mustSucceed(t, executeContract(t, bc, alice, token, "burn", map[string]any{"amount": 1000}, 0))
+	mustFail(t, executeContract(t, bc, alice, token, "burn", map[string]any{"amount": 1}, 0))
+}
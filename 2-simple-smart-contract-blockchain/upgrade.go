@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// ContractUpgrade represents a new code version published by the owner of a contract
+// The storage and the serialized state of the contract are kept by the new code
+type ContractUpgrade struct {
+	ContractID string    `json:"contract_id"`
+	Version    int       `json:"version"`
+	Type       string    `json:"type"`
+	Fee        float64   `json:"fee"` // Paid by the owner to the miner, set when the upgrade is mined
+	Signature  string    `json:"signature"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Upgradable is implemented by the Code of contract types their owner may upgrade alone
+// Successors lists the contract types, besides its own, able to take over the state of the code
+// Contract types holding coins or tokens of other parties do not implement it, their owner could
+// otherwise replace the rules those parties rely on
+type Upgradable interface {
+	Successors() []string
+}
+
+// ContractVersion describes a code version of a contract and the block publishing it
+type ContractVersion struct {
+	Version   int       `json:"version"`
+	Type      string    `json:"type"`
+	Block     int       `json:"block"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// signingMessage returns the message the contract owner signs to authorise the upgrade
+func (u ContractUpgrade) signingMessage() string {
+	return signingMessage("upgrade", u.ContractID, u.Type, strconv.Itoa(u.Version))
+}
+
+// getContractVersions returns the code versions of a contract, from its deployment to its
+// current version
+func (bc *Blockchain) getContractVersions(contractID string) []ContractVersion {
+	versions := []ContractVersion{}
+	for i, block := range bc.Chain {
+		for _, contract := range block.Data.Contracts {
+			if contract.ContractID == contractID {
+				versions = append(versions, ContractVersion{
+					Version:   1,
+					Type:      contract.Type,
+					Block:     i,
+					Timestamp: block.Timestamp,
+				})
+			}
+		}
+		for _, upgrade := range block.Data.ContractUpgrades {
+			if upgrade.ContractID == contractID {
+				versions = append(versions, ContractVersion{
+					Version:   upgrade.Version,
+					Type:      upgrade.Type,
+					Block:     i,
+					Timestamp: upgrade.Timestamp,
+				})
+			}
+		}
+	}
+	return versions
+}
+
+// getContractVersion returns the current code version of a contract
+func (bc *Blockchain) getContractVersion(contractID string) (ContractVersion, error) {
+	versions := bc.getContractVersions(contractID)
+	if len(versions) == 0 {
+		return ContractVersion{}, fmt.Errorf("contract %s not found", contractID)
+	}
+	return versions[len(versions)-1], nil
+}
+
+// checkContractUpgrade checks an upgrade is signed by the contract owner, moves the contract to
+// its own type or a successor of its code and that the new code is valid. It returns the state
+// taken over by the new code and the fee of the upgrade
+func (bc *Blockchain) checkContractUpgrade(upgrade ContractUpgrade) (*ContractState, float64, error) {
+	contract := bc.findContractByID(upgrade.ContractID)
+	if contract == nil {
+		return nil, 0, fmt.Errorf("contract not found")
+	}
+	current, err := bc.getContractVersion(upgrade.ContractID)
+	if err != nil {
+		return nil, 0, err
+	}
+	if upgrade.Version != current.Version+1 {
+		return nil, 0, fmt.Errorf("upgrade must publish version %d", current.Version+1)
+	}
+	if err := verifySignature(contract.Wallet, upgrade.signingMessage(), upgrade.Signature); err != nil {
+		return nil, 0, fmt.Errorf("upgrade signature verification failed: %v", err)
+	}
+
+	live, err := bc.getContractState(upgrade.ContractID)
+	if err != nil {
+		return nil, 0, err
+	}
+	upgradable, ok := live.Code.(Upgradable)
+	if !ok {
+		return nil, 0, fmt.Errorf("contract type %s cannot be upgraded", current.Type)
+	}
+	allowed := upgrade.Type == current.Type
+	for _, successor := range upgradable.Successors() {
+		allowed = allowed || upgrade.Type == successor
+	}
+	if !allowed {
+		return nil, 0, fmt.Errorf("contract type %s cannot be upgraded to %s", current.Type, upgrade.Type)
+	}
+
+	// The new code takes over the serialized state and the storage of the contract
+	data, _ := json.Marshal(live)
+	state, err := restoreContractState(upgrade.Type, data)
+	if err != nil {
+		return nil, 0, err
+	}
+	upgraded := SmartContract{
+		ContractID:    contract.ContractID,
+		Wallet:        contract.Wallet,
+		Type:          upgrade.Type,
+		Specification: contract.Specification,
+		Code:          state.Code,
+	}
+	if !upgraded.Validate(bc) {
+		return nil, 0, fmt.Errorf("contract validation failed")
+	}
+	return state, upgraded.deploymentFee(), nil
+}
+
+// addContractUpgrade adds an upgrade to the upgrade pool after validating it, the fee of the
+// new code is reserved from the owner's balance until the upgrade is mined
+func (bc *Blockchain) addContractUpgrade(upgrade ContractUpgrade) (ContractUpgrade, error) {
+	for _, pending := range bc.ContractUpgradePool {
+		if pending.ContractID == upgrade.ContractID {
+			return upgrade, fmt.Errorf("an upgrade of the contract is already pending")
+		}
+	}
+	current, err := bc.getContractVersion(upgrade.ContractID)
+	if err != nil {
+		return upgrade, err
+	}
+	upgrade.Version = current.Version + 1
+	upgrade.Timestamp = time.Now()
+
+	_, fee, err := bc.checkContractUpgrade(upgrade)
+	if err != nil {
+		return upgrade, err
+	}
+	upgrade.Fee = fee
+	if owner := bc.findContractByID(upgrade.ContractID).Wallet; bc.getBalance(owner) < upgrade.Fee {
+		return upgrade, fmt.Errorf("insufficient balance to pay the upgrade fee")
+	}
+
+	bc.ContractUpgradePool = append(bc.ContractUpgradePool, upgrade)
+	return upgrade, nil
+}
+
+// mineContractUpgrade mines upgrades from the upgrade pool into the current block
+// The fee of the new code, as large as it is when mined, is transferred from the owner to the miner
+func (bc *Blockchain) mineContractUpgrade(miner string) (ContractUpgrade, error) {
+	if len(bc.ContractUpgradePool) == 0 {
+		return ContractUpgrade{}, fmt.Errorf("no contract upgrades to mine")
+	}
+
+	// Process the first upgrade in the pool (FIFO) and remove it from the pool,
+	// releasing the fee reserved for it
+	upgrade := bc.ContractUpgradePool[0]
+	bc.ContractUpgradePool = bc.ContractUpgradePool[1:]
+
+	state, fee, err := bc.checkContractUpgrade(upgrade)
+	if err != nil {
+		return ContractUpgrade{}, fmt.Errorf("upgrade of contract %s failed: %v", upgrade.ContractID, err)
+	}
+	owner := bc.findContractByID(upgrade.ContractID).Wallet
+	if bc.getBalance(owner) < fee {
+		return ContractUpgrade{}, fmt.Errorf("owner cannot afford the fee to upgrade contract %s", upgrade.ContractID)
+	}
+	upgrade.Fee = fee
+
+	lastBlock := bc.getLastBlock()
+	lastBlock.Data.Transactions = append(lastBlock.Data.Transactions, Transaction{
+		From:   owner,
+		To:     miner,
+		Amount: upgrade.Fee,
+	})
+	lastBlock.Data.ContractUpgrades = append(lastBlock.Data.ContractUpgrades, upgrade)
+	bc.ContractStates[upgrade.ContractID] = state
+	return upgrade, nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"math"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// signedUpgrade builds the upgrade of a contract to its next version signed by the wallet
+func signedUpgrade(t *testing.T, bc *Blockchain, w *testWallet, contractID string, contractType string) ContractUpgrade {
+	t.Helper()
+	current, err := bc.getContractVersion(contractID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upgrade := ContractUpgrade{ContractID: contractID, Type: contractType, Version: current.Version + 1}
+	upgrade.Signature = w.sign(t, upgrade.signingMessage())
+	return upgrade
+}
+
+// upgradeContract submits a signed upgrade and mines it
+func upgradeContract(t *testing.T, bc *Blockchain, w *testWallet, contractID string, contractType string, miner string) ContractUpgrade {
+	t.Helper()
+	if _, err := bc.addContractUpgrade(signedUpgrade(t, bc, w, contractID, contractType)); err != nil {
+		t.Fatalf("upgrading to %s failed: %v", contractType, err)
+	}
+	upgrade, err := bc.mineContractUpgrade(miner)
+	if err != nil {
+		t.Fatalf("mining the upgrade to %s failed: %v", contractType, err)
+	}
+	return upgrade
+}
+
+func TestUpgradeKeepsTheStateAndChargesAFee(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, miner := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+
+	before := bc.getBalance(alice.ID)
+	pending, err := bc.addContractUpgrade(signedUpgrade(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reserved := before - bc.getBalance(alice.ID); pending.Fee <= 0 || math.Abs(reserved-pending.Fee) > BALANCE_TOLERANCE {
+		t.Fatalf("upgrade reserved %v, expected its fee %v", before-bc.getBalance(alice.ID), pending.Fee)
+	}
+	if _, err := bc.addContractUpgrade(signedUpgrade(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE)); err == nil {
+		t.Fatal("second upgrade of a contract with a pending upgrade was accepted")
+	}
+	upgrade, err := bc.mineContractUpgrade(miner.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if paid := bc.getBalance(miner.ID); paid != upgrade.Fee {
+		t.Fatalf("miner received %v, expected the fee %v", paid, upgrade.Fee)
+	}
+
+	if count := callView(t, bc, counter, "count", nil); count != "2" {
+		t.Fatalf("upgraded contract counts %s, expected the 2 executions of version 1", count)
+	}
+	mustFail(t, executeContract(t, bc, bob, counter, "reset", nil, 0))
+	if _, err := bc.callContract(ContractExecution{ContractID: counter, Caller: alice.ID, Method: "reset", GasLimit: VIEW_GAS_LIMIT}); err == nil {
+		t.Fatal("unsigned call reset the counter as its owner")
+	}
+	reset := executeContract(t, bc, alice, counter, "reset", nil, 0)
+	if mustSucceed(t, reset); reset.Version != 2 {
+		t.Fatalf("reset ran version %d", reset.Version)
+	}
+}
+
+func TestUpgradeRequiresTheOwnerSignature(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, mallory := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, mallory.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+
+	forged := signedUpgrade(t, bc, mallory, counter, CONTRACT_EXAMPLE_V2_TYPE)
+	if _, err := bc.addContractUpgrade(forged); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Fatalf("upgrade signed by another wallet returned %v", err)
+	}
+
+	// A signature of version 2 cannot publish version 3
+	upgradeContract(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE, alice.ID)
+	replayed := ContractUpgrade{ContractID: counter, Type: CONTRACT_EXAMPLE_V2_TYPE, Version: 2}
+	replayed.Signature = alice.sign(t, replayed.signingMessage())
+	if _, err := bc.addContractUpgrade(replayed); err == nil {
+		t.Fatal("signature of a published version was replayed")
+	}
+}
+
+func TestUpgradeIsLimitedToSuccessors(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	vault := deployContract(t, bc, alice, TEST_VAULT_TYPE, "")
+
+	tests := []struct {
+		name       string
+		contractID string
+		to         string
+	}{
+		{"vault to an example", vault, CONTRACT_EXAMPLE_TYPE},
+		{"vault to itself", vault, TEST_VAULT_TYPE},
+		{"example to a vault", counter, TEST_VAULT_TYPE},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := bc.addContractUpgrade(signedUpgrade(t, bc, alice, test.contractID, test.to)); err == nil || !strings.Contains(err.Error(), "cannot be upgraded") {
+				t.Fatalf("upgrade returned %v", err)
+			}
+		})
+	}
+
+	upgradeContract(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE, alice.ID)
+	if _, err := bc.addContractUpgrade(signedUpgrade(t, bc, alice, counter, CONTRACT_EXAMPLE_TYPE)); err == nil {
+		t.Fatal("contract was downgraded to a type that is not its successor")
+	}
+	upgradeContract(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE, alice.ID)
+}
+
+func TestReplaceChainReplaysUpgrades(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+	mustSucceed(t, executeContract(t, bc, alice, counter, "increment", nil, 0))
+	upgradeContract(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE, bob.ID)
+	mustSucceed(t, executeContract(t, bc, alice, counter, "reset", nil, 0))
+	mineBlocks(t, bc, bob.ID, 1)
+	upgradeContract(t, bc, alice, counter, CONTRACT_EXAMPLE_V2_TYPE, bob.ID)
+
+	node := newTestBlockchain()
+	if err := node.replaceChain(receive(t, bc.Chain)); err != nil {
+		t.Fatalf("chain with upgrades was rejected: %v", err)
+	}
+	if versions := node.getContractVersions(counter); len(versions) != 3 || versions[2].Type != CONTRACT_EXAMPLE_V2_TYPE {
+		t.Fatalf("replayed chain has the versions %+v", versions)
+	}
+
+	// The fee of an upgrade is replayed with it
+	chain := receive(t, bc.Chain)
+	last := len(chain) - 1
+	for j, tx := range chain[last].Data.Transactions {
+		if tx.From == alice.ID && tx.To == bob.ID {
+			chain[last].Data.Transactions = append(chain[last].Data.Transactions[:j], chain[last].Data.Transactions[j+1:]...)
+			break
+		}
+	}
+	if err := newTestBlockchain().replaceChain(chain); err == nil || !strings.Contains(err.Error(), "fee") {
+		t.Fatalf("chain without the upgrade fee returned %v", err)
+	}
+}
+
+func TestVersionsRouteListsEachVersion(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds and runs the node")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build the node")
+	}
+	node := startServer(t, ".")
+	alice := newTestWallet(t)
+	request(t, http.MethodGet, node+"/mine/block?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+
+	contract := SmartContract{Type: CONTRACT_EXAMPLE_TYPE, Specification: "counter", Nonce: alice.nextNonce()}
+	deployed := request(t, http.MethodPost, node+"/contract/new", map[string]any{
+		"wallet": alice.ID, "type": contract.Type, "specification": contract.Specification,
+		"nonce": contract.Nonce, "signature": alice.sign(t, contract.signingMessage()),
+	}, http.StatusCreated)
+	contractID := deployed["contractID"].(string)
+	request(t, http.MethodGet, node+"/mine/deployment?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+
+	for version := 2; version <= 3; version++ {
+		upgrade := ContractUpgrade{ContractID: contractID, Type: CONTRACT_EXAMPLE_V2_TYPE, Version: version}
+		request(t, http.MethodPost, node+"/contract/upgrade", map[string]any{
+			"contract_id": contractID, "type": upgrade.Type, "signature": alice.sign(t, upgrade.signingMessage()),
+		}, http.StatusCreated)
+		request(t, http.MethodGet, node+"/mine/upgrade?wallet="+url.QueryEscape(alice.ID), nil, http.StatusOK)
+	}
+
+	response := request(t, http.MethodGet, node+"/contract/"+contractID+"/versions", nil, http.StatusOK)
+	versions := response["versions"].([]any)
+	if len(versions) != 3 {
+		t.Fatalf("versions route listed %v", versions)
+	}
+	for i, expected := range []string{CONTRACT_EXAMPLE_TYPE, CONTRACT_EXAMPLE_V2_TYPE, CONTRACT_EXAMPLE_V2_TYPE} {
+		version := versions[i].(map[string]any)
+		if version["version"] != float64(i+1) || version["type"] != expected {
+			t.Fatalf("version %d listed as %v", i+1, version)
+		}
+	}
+	request(t, http.MethodGet, node+"/contract/unknown/versions", nil, http.StatusNotFound)
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+const CONTRACT_VESTING_TYPE string = "vesting"
+
+func init() {
+	registerCode(CONTRACT_VESTING_TYPE, func() Code { return &ContractCodeVesting{} })
+}
+
+// VestingSpecification is the specification of a vesting contract, given as JSON at deployment
+// Nothing vests before Start+Cliff, the deposit then vests linearly until Start+Duration
+type VestingSpecification struct {
+	Beneficiary string `json:"beneficiary"`
+	Start       int    `json:"start"`
+	Cliff       int    `json:"cliff"`
+	Duration    int    `json:"duration"`
+	Revocable   bool   `json:"revocable"`
+}
+
+// VestingStatus reports the amounts of a vesting contract at the current block
+type VestingStatus struct {
+	Total     float64 `json:"total"`
+	Vested    float64 `json:"vested"`
+	Claimed   float64 `json:"claimed"`
+	Claimable float64 `json:"claimable"`
+	Revoked   bool    `json:"revoked"`
+}
+
+// ContractCodeVesting implements coins deposited by a grantor that unlock over block heights
+// for a beneficiary, the unvested coins can be revoked by the grantor
+type ContractCodeVesting struct {
+	VestingSpecification
+	Grantor   string  `json:"grantor"`
+	Total     float64 `json:"total"`
+	Claimed   float64 `json:"claimed"`
+	RevokedAt int     `json:"revoked_at"` // 0 until revoked
+}
+
+// Initialize configures the beneficiary and schedule of the vesting, the deployer is the grantor
+func (sc *ContractCodeVesting) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.VestingSpecification); err != nil {
+		return fmt.Errorf("vesting specification must be JSON: %v", err)
+	}
+	sc.Grantor = owner
+	return nil
+}
+
+func (sc *ContractCodeVesting) Validate(chain ChainReader) bool {
+	return sc.Beneficiary != "" && sc.Beneficiary != sc.Grantor && sc.Start >= 0 &&
+		sc.Cliff >= 0 && sc.Duration > 0 && sc.Cliff <= sc.Duration
+}
+
+func (sc *ContractCodeVesting) ABI() ContractABI {
+	amount := ParamABI{Name: "amount", Type: "number"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "vesting", Params: []ParamABI{}, Returns: "object", ReadOnly: true},
+			{Name: "fund", Params: []ParamABI{}, Returns: "number", Payable: true},
+			{Name: "claim", Params: []ParamABI{}, Returns: "number"},
+			{Name: "revoke", Params: []ParamABI{}, Returns: "number"},
+		},
+		Events: []EventABI{
+			{Name: "Funded", Topics: []string{"grantor"}, Payload: []ParamABI{amount}},
+			{Name: "Claimed", Topics: []string{"beneficiary"}, Payload: []ParamABI{amount}},
+			{Name: "Revoked", Topics: []string{"grantor"}, Payload: []ParamABI{amount}},
+		},
+	}
+}
+
+func (sc *ContractCodeVesting) Execute(ctx ExecutionContext) (string, error) {
+	if ctx.Method() == "vesting" {
+		return marshalResult(sc.status(ctx.BlockHeight()))
+	}
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+
+	switch ctx.Method() {
+	case "fund":
+		if ctx.Caller() != sc.Grantor {
+			return "", fmt.Errorf("only the grantor can fund")
+		}
+		if sc.RevokedAt > 0 {
+			return "", fmt.Errorf("vesting was revoked")
+		}
+		if ctx.Value() <= 0 {
+			return "", fmt.Errorf("funding must attach value")
+		}
+		sc.Total += ctx.Value()
+		return strconv.FormatFloat(sc.Total, 'f', -1, 64), ctx.Emit("Funded", []string{ctx.Caller()}, map[string]float64{"amount": ctx.Value()})
+	case "claim":
+		if ctx.Caller() != sc.Beneficiary {
+			return "", fmt.Errorf("only the beneficiary can claim")
+		}
+		claimable := sc.status(ctx.BlockHeight()).Claimable
+		// The rounding of the sums of transfers may leave the contract slightly short of the remainder
+		if balance := ctx.Balance(ctx.ContractID()); claimable > balance && claimable-balance <= BALANCE_TOLERANCE {
+			claimable = balance
+		}
+		if claimable <= 0 {
+			return "", fmt.Errorf("nothing to claim")
+		}
+		if err := ctx.Transfer(sc.Beneficiary, claimable); err != nil {
+			return "", err
+		}
+		sc.Claimed += claimable
+		if sc.vested(ctx.BlockHeight()) == sc.Total {
+			// Everything vested is now claimed, whatever the rounding of the sum
+			sc.Claimed = sc.Total
+		}
+		return strconv.FormatFloat(claimable, 'f', -1, 64), ctx.Emit("Claimed", []string{sc.Beneficiary}, map[string]float64{"amount": claimable})
+	case "revoke":
+		if ctx.Caller() != sc.Grantor {
+			return "", fmt.Errorf("only the grantor can revoke")
+		}
+		if !sc.Revocable {
+			return "", fmt.Errorf("vesting is not revocable")
+		}
+		if sc.RevokedAt > 0 {
+			return "", fmt.Errorf("vesting already revoked")
+		}
+		// The vested coins stay claimable by the beneficiary
+		vested := sc.vested(ctx.BlockHeight())
+		unvested := sc.Total - vested
+		if unvested > 0 {
+			if err := ctx.Transfer(sc.Grantor, unvested); err != nil {
+				return "", err
+			}
+		}
+		sc.Total = vested
+		sc.RevokedAt = ctx.BlockHeight()
+		return strconv.FormatFloat(unvested, 'f', -1, 64), ctx.Emit("Revoked", []string{sc.Grantor}, map[string]float64{"amount": unvested})
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// vested calculates the coins vested at a block height, all the remaining coins once revoked
+func (sc *ContractCodeVesting) vested(height int) float64 {
+	switch {
+	case sc.RevokedAt > 0:
+		return sc.Total
+	case height < sc.Start+sc.Cliff:
+		return 0
+	case height >= sc.Start+sc.Duration:
+		return sc.Total
+	}
+	return math.Min(sc.Total*float64(height-sc.Start)/float64(sc.Duration), sc.Total)
+}
+
+// status reports the amounts of the vesting at a block height, the claimable amount never
+// exceeds what is left of the total and is exactly that once everything vested
+func (sc *ContractCodeVesting) status(height int) VestingStatus {
+	vested := sc.vested(height)
+	claimable := math.Max(math.Min(vested-sc.Claimed, sc.Total-sc.Claimed), 0)
+	if vested == sc.Total {
+		claimable = math.Max(sc.Total-sc.Claimed, 0)
+	}
+	return VestingStatus{
+		Total:     sc.Total,
+		Vested:    vested,
+		Claimed:   sc.Claimed,
+		Claimable: claimable,
+		Revoked:   sc.RevokedAt > 0,
+	}
+}
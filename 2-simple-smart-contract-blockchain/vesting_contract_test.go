@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"testing"
+)
+
+// deployVesting deploys a vesting from the grantor to the beneficiary starting at the open block
+func deployVesting(t *testing.T, bc *Blockchain, grantor *testWallet, beneficiary *testWallet, cliff int, duration int, revocable bool) (string, int) {
+	t.Helper()
+	start := len(bc.Chain) - 1
+	spec := fmt.Sprintf(`{ "beneficiary": %q, "start": %d, "cliff": %d, "duration": %d, "revocable": %t }`, beneficiary.ID, start, cliff, duration, revocable)
+	return deployContract(t, bc, grantor, CONTRACT_VESTING_TYPE, spec), start
+}
+
+// vestingStatus returns the status of a vesting at the open block
+func vestingStatus(t *testing.T, bc *Blockchain, vesting string) VestingStatus {
+	t.Helper()
+	var status VestingStatus
+	if err := json.Unmarshal([]byte(callView(t, bc, vesting, "vesting", nil)), &status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+// claimed returns the amount a claim transferred to the beneficiary
+func claimed(t *testing.T, execution ContractExecution) float64 {
+	t.Helper()
+	amount, err := strconv.ParseFloat(mustSucceed(t, execution), 64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return amount
+}
+
+func TestVestingReleasesLinearlyAfterTheCliff(t *testing.T) {
+	bc := newTestBlockchain()
+	grantor, beneficiary := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, grantor.ID, 1)
+	mineBlocks(t, bc, beneficiary.ID, 1)
+	vesting, start := deployVesting(t, bc, grantor, beneficiary, 4, 10, false)
+	mustFail(t, executeContract(t, bc, beneficiary, vesting, "fund", nil, 10))
+	mustSucceed(t, executeContract(t, bc, grantor, vesting, "fund", nil, 10))
+
+	// Nothing vests before the cliff, the elapsed blocks since the start vest at the cliff
+	mineUntil(t, bc, grantor.ID, start+3)
+	mustFail(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0))
+	mineUntil(t, bc, grantor.ID, start+4)
+	if amount := claimed(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0)); amount != 4 {
+		t.Fatalf("claimed %v at the cliff, expected 4", amount)
+	}
+	mustFail(t, executeContract(t, bc, grantor, vesting, "claim", nil, 0))
+	mustFail(t, executeContract(t, bc, beneficiary, vesting, "revoke", nil, 0))
+
+	mineUntil(t, bc, grantor.ID, start+7)
+	if status := vestingStatus(t, bc, vesting); status.Vested != 7 || status.Claimable != 3 {
+		t.Fatalf("vesting reports %+v halfway", status)
+	}
+	mineUntil(t, bc, grantor.ID, start+10)
+	if amount := claimed(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0)); amount != 6 {
+		t.Fatalf("claimed %v at the end, expected the remaining 6", amount)
+	}
+	mustFail(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0))
+	if balance := bc.getBalance(vesting); balance != 0 {
+		t.Fatalf("vesting holds %v once claimed", balance)
+	}
+}
+
+func TestVestingFinalClaimReleasesExactlyTheRemainder(t *testing.T) {
+	bc := newTestBlockchain()
+	grantor, beneficiary := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, grantor.ID, 1)
+	mineBlocks(t, bc, beneficiary.ID, 1)
+	vesting, start := deployVesting(t, bc, grantor, beneficiary, 0, 7, false)
+	for i := 0; i < 3; i++ {
+		mustSucceed(t, executeContract(t, bc, grantor, vesting, "fund", nil, 0.1))
+	}
+
+	// Claims at every block sum fractions that do not add up exactly in float64
+	total := 0.0
+	for height := start + 1; height <= start+7; height++ {
+		mineUntil(t, bc, grantor.ID, height)
+		total += claimed(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0))
+		if status := vestingStatus(t, bc, vesting); status.Claimed > status.Total {
+			t.Fatalf("claimed %v of %v", status.Claimed, status.Total)
+		}
+	}
+	if status := vestingStatus(t, bc, vesting); status.Claimed != status.Total || status.Claimable != 0 {
+		t.Fatalf("vesting reports %+v at the end", status)
+	}
+	if balance := bc.getBalance(vesting); math.Abs(balance) > BALANCE_TOLERANCE || math.Abs(total-0.3) > BALANCE_TOLERANCE {
+		t.Fatalf("claimed %v, the vesting still holds %v", total, balance)
+	}
+	mustFail(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0))
+}
+
+func TestVestingRevocationReturnsTheUnvestedCoins(t *testing.T) {
+	bc := newTestBlockchain()
+	grantor, beneficiary := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, grantor.ID, 1)
+	mineBlocks(t, bc, beneficiary.ID, 1)
+
+	fixed, _ := deployVesting(t, bc, grantor, beneficiary, 0, 10, false)
+	mustSucceed(t, executeContract(t, bc, grantor, fixed, "fund", nil, 10))
+	mustFail(t, executeContract(t, bc, grantor, fixed, "revoke", nil, 0))
+
+	vesting, start := deployVesting(t, bc, grantor, beneficiary, 2, 10, true)
+	mustSucceed(t, executeContract(t, bc, grantor, vesting, "fund", nil, 10))
+	mineUntil(t, bc, beneficiary.ID, start+4)
+	revoke := executeContract(t, bc, grantor, vesting, "revoke", nil, 0)
+	if returned := mustSucceed(t, revoke); returned != "6" {
+		t.Fatalf("revoke returned %s, expected the 6 unvested coins", returned)
+	}
+	if len(revoke.Transfers) != 1 || revoke.Transfers[0].To != grantor.ID || revoke.Transfers[0].Amount != 6 {
+		t.Fatalf("revoke transferred %+v", revoke.Transfers)
+	}
+	mustFail(t, executeContract(t, bc, grantor, vesting, "revoke", nil, 0))
+	mustFail(t, executeContract(t, bc, grantor, vesting, "fund", nil, 1))
+
+	// The coins vested before the revocation stay claimable at once
+	if status := vestingStatus(t, bc, vesting); !status.Revoked || status.Total != 4 || status.Claimable != 4 {
+		t.Fatalf("revoked vesting reports %+v", status)
+	}
+	if amount := claimed(t, executeContract(t, bc, beneficiary, vesting, "claim", nil, 0)); amount != 4 {
+		t.Fatalf("claimed %v after the revocation, expected 4", amount)
+	}
+	if balance := bc.getBalance(vesting); balance != 0 {
+		t.Fatalf("revoked vesting holds %v once claimed", balance)
+	}
+}
@@ -0,0 +1,390 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+const CONTRACT_VOTING_TYPE string = "voting"
+
+func init() {
+	registerCode(CONTRACT_VOTING_TYPE, func() Code { return &ContractCodeVoting{} })
+}
+
+// VotingSpecification is the specification of a governance contract, given as JSON at deployment
+// Voting power is one per wallet of Voters, or the tokens of the Token contract locked with the vote
+type VotingSpecification struct {
+	Voters []string `json:"voters"`
+	Token  string   `json:"token"`
+	Quorum float64  `json:"quorum"` // Voting power that must be cast for a proposal to pass
+}
+
+// VotingProposal is a proposal voted on by the eligible wallets between two block heights
+type VotingProposal struct {
+	ProposalID int                `json:"proposal_id"`
+	Proposer   string             `json:"proposer"`
+	Title      string             `json:"title"`
+	Options    []string           `json:"options"`
+	Start      int                `json:"start"`
+	End        int                `json:"end"`
+	Votes      map[string]float64 `json:"votes"` // Voting power cast per option
+	Voters     int                `json:"voters"`
+	Finalized  bool               `json:"finalized"`
+}
+
+// VotingTally is the final result of a proposal, stored in the contract storage
+type VotingTally struct {
+	ProposalID int                `json:"proposal_id"`
+	Votes      map[string]float64 `json:"votes"`
+	Turnout    float64            `json:"turnout"`
+	Quorum     float64            `json:"quorum"`
+	Passed     bool               `json:"passed"`
+	Winner     string             `json:"winner"` // Empty when the quorum is not reached or the vote is tied
+	Block      int                `json:"block"`
+}
+
+// ContractCodeVoting implements proposals voted on by an eligibility list or by token holders,
+// one vote per wallet, with a quorum and a final tally. Token holders lock the tokens they vote
+// with in the contract until the voting window is over, so the same tokens cannot vote twice
+type ContractCodeVoting struct {
+	VotingSpecification
+	Proposals []*VotingProposal `json:"proposals"`
+}
+
+// Initialize configures the eligibility and quorum of the governance contract
+func (sc *ContractCodeVoting) Initialize(owner string, specification string) error {
+	if err := json.Unmarshal([]byte(specification), &sc.VotingSpecification); err != nil {
+		return fmt.Errorf("voting specification must be JSON: %v", err)
+	}
+	sc.Proposals = []*VotingProposal{}
+	return nil
+}
+
+func (sc *ContractCodeVoting) Validate(chain ChainReader) bool {
+	if (len(sc.Voters) == 0) == (sc.Token == "") {
+		return false // Exactly one eligibility rule
+	}
+	// Votes are weighted by balance_of and locked with transfer_from, which only tokens implement
+	if tokenType, _ := chain.ContractType(sc.Token); sc.Token != "" && tokenType != CONTRACT_TOKEN_TYPE {
+		return false
+	}
+	return sc.Quorum >= 0
+}
+
+func (sc *ContractCodeVoting) ABI() ContractABI {
+	proposalID := ParamABI{Name: "proposal_id", Type: "integer"}
+	return ContractABI{
+		Methods: []MethodABI{
+			{Name: "proposal", Params: []ParamABI{proposalID}, Returns: "object", ReadOnly: true},
+			{Name: "proposals", Params: []ParamABI{}, Returns: "array", ReadOnly: true},
+			{Name: "voting_power", Params: []ParamABI{{Name: "voter", Type: "address"}}, Returns: "number", ReadOnly: true},
+			{Name: "vote_of", Params: []ParamABI{proposalID, {Name: "voter", Type: "address"}}, Returns: "string", ReadOnly: true},
+			{Name: "tally", Params: []ParamABI{proposalID}, Returns: "object", ReadOnly: true},
+			{Name: "propose", Params: []ParamABI{{Name: "title", Type: "string"}, {Name: "options", Type: "array"}, {Name: "start", Type: "integer"}, {Name: "end", Type: "integer"}}, Returns: "integer"},
+			{Name: "vote", Params: []ParamABI{proposalID, {Name: "option", Type: "string"}, {Name: "amount", Type: "integer", Optional: true}}, Returns: "number"},
+			{Name: "withdraw", Params: []ParamABI{proposalID}, Returns: "integer"},
+			{Name: "finalize", Params: []ParamABI{proposalID}, Returns: "object"},
+		},
+		Events: []EventABI{
+			{Name: "Proposed", Topics: []string{"proposal_id", "proposer"}, Payload: []ParamABI{{Name: "title", Type: "string"}, {Name: "options", Type: "array"}, {Name: "start", Type: "integer"}, {Name: "end", Type: "integer"}}},
+			{Name: "Voted", Topics: []string{"proposal_id", "voter"}, Payload: []ParamABI{{Name: "option", Type: "string"}, {Name: "power", Type: "number"}}},
+			{Name: "Withdrawn", Topics: []string{"proposal_id", "voter"}, Payload: []ParamABI{{Name: "amount", Type: "integer"}}},
+			{Name: "Finalized", Topics: []string{"proposal_id"}, Payload: []ParamABI{{Name: "votes", Type: "object"}, {Name: "turnout", Type: "number"}, {Name: "quorum", Type: "number"}, {Name: "passed", Type: "bool"}, {Name: "winner", Type: "string"}, {Name: "block", Type: "integer"}}},
+		},
+	}
+}
+
+func (sc *ContractCodeVoting) Execute(ctx ExecutionContext) (string, error) {
+	switch ctx.Method() {
+	case "proposal":
+		proposal, err := sc.proposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		return marshalResult(proposal)
+	case "proposals":
+		return marshalResult(sc.Proposals)
+	case "voting_power":
+		var voter string
+		if err := ctx.Arg("voter", &voter); err != nil {
+			return "", err
+		}
+		power, err := sc.votingPower(ctx, voter)
+		if err != nil {
+			return "", err
+		}
+		return strconv.FormatFloat(power, 'f', -1, 64), nil
+	case "vote_of":
+		proposal, err := sc.proposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		var voter, option string
+		if err := ctx.Arg("voter", &voter); err != nil {
+			return "", err
+		}
+		if _, err := ctx.GetStorage(voteKey(proposal.ProposalID, voter), &option); err != nil {
+			return "", err
+		}
+		return option, nil
+	case "tally":
+		proposal, err := sc.proposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		var tally VotingTally
+		exists, err := ctx.GetStorage(tallyKey(proposal.ProposalID), &tally)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return "", fmt.Errorf("proposal %d is not finalized", proposal.ProposalID)
+		}
+		return marshalResult(tally)
+	case "finalize":
+		// Anyone can finalize a proposal once its voting window is over
+		return sc.finalize(ctx)
+	}
+
+	if !ctx.Authenticated() {
+		return "", fmt.Errorf("method %s requires a signed execution", ctx.Method())
+	}
+	if ctx.Method() == "withdraw" {
+		// Locked tokens are returned even when the voter no longer holds any
+		return sc.withdraw(ctx)
+	}
+	power, err := sc.votingPower(ctx, ctx.Caller())
+	if err != nil {
+		return "", err
+	}
+	if power <= 0 {
+		return "", fmt.Errorf("caller is not eligible to vote")
+	}
+
+	switch ctx.Method() {
+	case "propose":
+		proposal := &VotingProposal{
+			ProposalID: len(sc.Proposals) + 1,
+			Proposer:   ctx.Caller(),
+			Votes:      map[string]float64{},
+		}
+		if err := ctx.Arg("title", &proposal.Title); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("options", &proposal.Options); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("start", &proposal.Start); err != nil {
+			return "", err
+		}
+		if err := ctx.Arg("end", &proposal.End); err != nil {
+			return "", err
+		}
+		if proposal.Title == "" {
+			return "", fmt.Errorf("title must not be empty")
+		}
+		if len(proposal.Options) < 2 {
+			return "", fmt.Errorf("a proposal needs at least two options")
+		}
+		for i, option := range proposal.Options {
+			if _, exists := proposal.Votes[option]; exists || option == "" {
+				return "", fmt.Errorf("option %d is empty or duplicated", i)
+			}
+			proposal.Votes[option] = 0
+		}
+		if proposal.Start < ctx.BlockHeight() || proposal.End < proposal.Start {
+			return "", fmt.Errorf("voting window must start from the current block and end after its start")
+		}
+		if err := ctx.UseGas(GAS_PER_STORAGE_WRITE); err != nil {
+			return "", err
+		}
+		sc.Proposals = append(sc.Proposals, proposal)
+		err := ctx.Emit("Proposed", []string{strconv.Itoa(proposal.ProposalID), proposal.Proposer}, map[string]any{
+			"title":   proposal.Title,
+			"options": proposal.Options,
+			"start":   proposal.Start,
+			"end":     proposal.End,
+		})
+		return strconv.Itoa(proposal.ProposalID), err
+	case "vote":
+		proposal, err := sc.proposal(ctx)
+		if err != nil {
+			return "", err
+		}
+		if ctx.BlockHeight() < proposal.Start || ctx.BlockHeight() > proposal.End {
+			return "", fmt.Errorf("voting on proposal %d is open from block %d to %d", proposal.ProposalID, proposal.Start, proposal.End)
+		}
+		var option string
+		if err := ctx.Arg("option", &option); err != nil {
+			return "", err
+		}
+		if _, exists := proposal.Votes[option]; !exists {
+			return "", fmt.Errorf("unknown option %s", option)
+		}
+		key := voteKey(proposal.ProposalID, ctx.Caller())
+		var previous string
+		voted, err := ctx.GetStorage(key, &previous)
+		if err != nil {
+			return "", err
+		}
+		if voted {
+			return "", fmt.Errorf("caller already voted on proposal %d", proposal.ProposalID)
+		}
+		if sc.Token != "" {
+			if power, err = sc.lock(ctx, proposal); err != nil {
+				return "", err
+			}
+		} else if ctx.Arg("amount", new(int64)) == nil {
+			return "", fmt.Errorf("amount only applies to token-weighted voting")
+		}
+		if err := ctx.SetStorage(key, option); err != nil {
+			return "", err
+		}
+		proposal.Votes[option] += power
+		proposal.Voters++
+		err = ctx.Emit("Voted", []string{strconv.Itoa(proposal.ProposalID), ctx.Caller()}, map[string]any{
+			"option": option,
+			"power":  power,
+		})
+		return strconv.FormatFloat(power, 'f', -1, 64), err
+	}
+	return "", fmt.Errorf("unknown method %s", ctx.Method())
+}
+
+// voteKey returns the storage key of the vote of a wallet on a proposal
+func voteKey(proposalID int, voter string) string {
+	return "vote:" + strconv.Itoa(proposalID) + ":" + voter
+}
+
+// lockKey returns the storage key of the tokens a wallet locked with its vote on a proposal
+func lockKey(proposalID int, voter string) string {
+	return "lock:" + strconv.Itoa(proposalID) + ":" + voter
+}
+
+// tallyKey returns the storage key of the final tally of a proposal
+func tallyKey(proposalID int) string {
+	return "tally:" + strconv.Itoa(proposalID)
+}
+
+// proposal returns the proposal identified by the proposal_id argument
+func (sc *ContractCodeVoting) proposal(ctx ExecutionContext) (*VotingProposal, error) {
+	var proposalID int
+	if err := ctx.Arg("proposal_id", &proposalID); err != nil {
+		return nil, err
+	}
+	if proposalID < 1 || proposalID > len(sc.Proposals) {
+		return nil, fmt.Errorf("proposal %d not found", proposalID)
+	}
+	return sc.Proposals[proposalID-1], nil
+}
+
+// votingPower returns the voting power of a wallet, its token balance when voting is
+// token-weighted. It makes the wallet eligible to propose, a vote counts the tokens it locks
+func (sc *ContractCodeVoting) votingPower(ctx ExecutionContext, voter string) (float64, error) {
+	if sc.Token == "" {
+		for _, eligible := range sc.Voters {
+			if eligible == voter {
+				return 1, nil
+			}
+		}
+		return 0, nil
+	}
+	result, err := ctx.Call(sc.Token, "balance_of", map[string]any{"owner": voter}, 0)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(result, 64)
+}
+
+// lock moves the amount of tokens voted with from the caller to the contract, the caller must
+// have approved the contract to spend them. The locked amount is the voting power of the vote
+func (sc *ContractCodeVoting) lock(ctx ExecutionContext, proposal *VotingProposal) (float64, error) {
+	var amount int64
+	if err := ctx.Arg("amount", &amount); err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount of tokens to lock must be positive")
+	}
+	args := map[string]any{"from": ctx.Caller(), "to": ctx.ContractID(), "amount": amount}
+	if _, err := ctx.Call(sc.Token, "transfer_from", args, 0); err != nil {
+		return 0, fmt.Errorf("locking %d tokens failed: %v", amount, err)
+	}
+	if err := ctx.SetStorage(lockKey(proposal.ProposalID, ctx.Caller()), amount); err != nil {
+		return 0, err
+	}
+	return float64(amount), nil
+}
+
+// withdraw returns the tokens the caller locked with its vote once the voting window is over
+func (sc *ContractCodeVoting) withdraw(ctx ExecutionContext) (string, error) {
+	proposal, err := sc.proposal(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ctx.BlockHeight() <= proposal.End {
+		return "", fmt.Errorf("voting on proposal %d is open until block %d", proposal.ProposalID, proposal.End)
+	}
+	key := lockKey(proposal.ProposalID, ctx.Caller())
+	var amount int64
+	locked, err := ctx.GetStorage(key, &amount)
+	if err != nil {
+		return "", err
+	}
+	if !locked {
+		return "", fmt.Errorf("no tokens locked on proposal %d", proposal.ProposalID)
+	}
+	if err := ctx.SetStorage(key, nil); err != nil {
+		return "", err
+	}
+	if _, err := ctx.Call(sc.Token, "transfer", map[string]any{"to": ctx.Caller(), "amount": amount}, 0); err != nil {
+		return "", err
+	}
+	err = ctx.Emit("Withdrawn", []string{strconv.Itoa(proposal.ProposalID), ctx.Caller()}, map[string]int64{"amount": amount})
+	return strconv.FormatInt(amount, 10), err
+}
+
+// finalize stores and emits the final tally of a proposal once its voting window is over
+func (sc *ContractCodeVoting) finalize(ctx ExecutionContext) (string, error) {
+	proposal, err := sc.proposal(ctx)
+	if err != nil {
+		return "", err
+	}
+	if ctx.BlockHeight() <= proposal.End {
+		return "", fmt.Errorf("voting on proposal %d is open until block %d", proposal.ProposalID, proposal.End)
+	}
+	if proposal.Finalized {
+		return "", fmt.Errorf("proposal %d already finalized", proposal.ProposalID)
+	}
+
+	tally := VotingTally{
+		ProposalID: proposal.ProposalID,
+		Votes:      proposal.Votes,
+		Quorum:     sc.Quorum,
+		Block:      ctx.BlockHeight(),
+	}
+	highest, tied := 0.0, false
+	for _, option := range proposal.Options {
+		votes := proposal.Votes[option]
+		tally.Turnout += votes
+		if votes > highest {
+			highest, tied, tally.Winner = votes, false, option
+		} else if votes == highest {
+			tied = true
+		}
+	}
+	tally.Passed = tally.Turnout > 0 && tally.Turnout >= sc.Quorum && !tied
+	if !tally.Passed {
+		tally.Winner = ""
+	}
+
+	proposal.Finalized = true
+	if err := ctx.SetStorage(tallyKey(proposal.ProposalID), tally); err != nil {
+		return "", err
+	}
+	if err := ctx.Emit("Finalized", []string{strconv.Itoa(proposal.ProposalID)}, tally); err != nil {
+		return "", err
+	}
+	return marshalResult(tally)
+}
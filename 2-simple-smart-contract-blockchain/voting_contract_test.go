@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// propose opens a proposal with two options voted on during the next blocks
+func propose(t *testing.T, bc *Blockchain, w *testWallet, voting string, blocks int) int {
+	t.Helper()
+	start := len(bc.Chain) - 1
+	args := map[string]any{"title": "Upgrade", "options": []string{"yes", "no"}, "start": start, "end": start + blocks}
+	mustSucceed(t, executeContract(t, bc, w, voting, "propose", args, 0))
+	return start + blocks
+}
+
+func TestVotingByListCountsOneVotePerWallet(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob, mallory := newTestWallet(t), newTestWallet(t), newTestWallet(t)
+	for _, w := range []*testWallet{alice, bob, mallory} {
+		mineBlocks(t, bc, w.ID, 1)
+	}
+	voting := deployContract(t, bc, alice, CONTRACT_VOTING_TYPE, fmt.Sprintf(`{ "voters": [%q, %q], "quorum": 2 }`, alice.ID, bob.ID))
+	end := propose(t, bc, alice, voting, 10)
+
+	mustSucceed(t, executeContract(t, bc, alice, voting, "vote", map[string]any{"proposal_id": 1, "option": "yes"}, 0))
+	mustFail(t, executeContract(t, bc, alice, voting, "vote", map[string]any{"proposal_id": 1, "option": "no"}, 0))
+	mustFail(t, executeContract(t, bc, mallory, voting, "vote", map[string]any{"proposal_id": 1, "option": "no"}, 0))
+	mustFail(t, executeContract(t, bc, bob, voting, "vote", map[string]any{"proposal_id": 1, "option": "yes", "amount": 5}, 0))
+	mustSucceed(t, executeContract(t, bc, bob, voting, "vote", map[string]any{"proposal_id": 1, "option": "yes"}, 0))
+
+	mustFail(t, executeContract(t, bc, bob, voting, "finalize", map[string]any{"proposal_id": 1}, 0))
+	for len(bc.Chain)-1 <= end {
+		mineBlocks(t, bc, alice.ID, 1)
+	}
+	tally := mustSucceed(t, executeContract(t, bc, bob, voting, "finalize", map[string]any{"proposal_id": 1}, 0))
+	if !strings.Contains(tally, `"turnout":2`) || !strings.Contains(tally, `"passed":true`) || !strings.Contains(tally, `"winner":"yes"`) {
+		t.Fatalf("tally is %s", tally)
+	}
+}
+
+func TestVotingByTokenLocksTheVotedTokens(t *testing.T) {
+	bc := newTestBlockchain()
+	alice, bob := newTestWallet(t), newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	mineBlocks(t, bc, bob.ID, 1)
+	token := deployToken(t, bc, alice, 100)
+	voting := deployContract(t, bc, alice, CONTRACT_VOTING_TYPE, fmt.Sprintf(`{ "token": %q, "quorum": 50 }`, token))
+	end := propose(t, bc, alice, voting, 20)
+
+	// Tokens the voting contract was not approved to spend cannot be locked
+	mustFail(t, executeContract(t, bc, alice, voting, "vote", map[string]any{"proposal_id": 1, "option": "yes", "amount": 100}, 0))
+	mustSucceed(t, executeContract(t, bc, alice, token, "approve", map[string]any{"spender": voting, "amount": 100}, 0))
+	if power := mustSucceed(t, executeContract(t, bc, alice, voting, "vote", map[string]any{"proposal_id": 1, "option": "yes", "amount": 100}, 0)); power != "100" {
+		t.Fatalf("vote counted %s tokens", power)
+	}
+	if got := callView(t, bc, token, "balance_of", map[string]any{"owner": voting}); got != "100" {
+		t.Fatalf("voting contract holds %s tokens", got)
+	}
+
+	// The locked tokens cannot be moved to another wallet to vote again
+	mustFail(t, executeContract(t, bc, alice, token, "transfer", map[string]any{"to": bob.ID, "amount": 100}, 0))
+	mustFail(t, executeContract(t, bc, bob, voting, "vote", map[string]any{"proposal_id": 1, "option": "no", "amount": 100}, 0))
+	mustFail(t, executeContract(t, bc, alice, voting, "withdraw", map[string]any{"proposal_id": 1}, 0))
+
+	for len(bc.Chain)-1 <= end {
+		mineBlocks(t, bc, alice.ID, 1)
+	}
+	tally := mustSucceed(t, executeContract(t, bc, bob, voting, "finalize", map[string]any{"proposal_id": 1}, 0))
+	if !strings.Contains(tally, `"turnout":100`) || !strings.Contains(tally, `"passed":true`) {
+		t.Fatalf("tally is %s", tally)
+	}
+	if withdrawn := mustSucceed(t, executeContract(t, bc, alice, voting, "withdraw", map[string]any{"proposal_id": 1}, 0)); withdrawn != "100" {
+		t.Fatalf("withdrew %s tokens", withdrawn)
+	}
+	mustFail(t, executeContract(t, bc, alice, voting, "withdraw", map[string]any{"proposal_id": 1}, 0))
+	if got := callView(t, bc, token, "balance_of", map[string]any{"owner": alice.ID}); got != "100" {
+		t.Fatalf("voter holds %s tokens after withdrawing", got)
+	}
+}
+
+func TestVotingByTokenRequiresATokenContract(t *testing.T) {
+	bc := newTestBlockchain()
+	alice := newTestWallet(t)
+	mineBlocks(t, bc, alice.ID, 1)
+	counter := deployContract(t, bc, alice, CONTRACT_EXAMPLE_TYPE, "counter")
+
+	code, err := newCode(CONTRACT_VOTING_TYPE)
+	if err != nil {
+		t.Fatal(err)
+	}
+	contract := SmartContract{
+		ContractID:    "voting",
+		Wallet:        alice.ID,
+		Type:          CONTRACT_VOTING_TYPE,
+		Specification: fmt.Sprintf(`{ "token": %q, "quorum": 10 }`, counter),
+		Nonce:         alice.nextNonce(),
+		Code:          code,
+	}
+	contract.Signature = alice.sign(t, contract.signingMessage())
+	if err := bc.addContract(contract); err == nil {
+		t.Fatal("voting weighted by a contract that is not a token was deployed")
+	}
+}